@@ -12,6 +12,69 @@ CREATE INDEX IF NOT EXISTS idx_address_nonce on transaction_details (address, no
 CREATE INDEX IF NOT EXISTS idx_tx_hash on transaction_details (tx_hash);
 CREATE INDEX IF NOT EXISTS idx_encrypted_tx_hash on transaction_details (encrypted_tx_hash);
 
+CREATE TABLE IF NOT EXISTS api_keys (
+    id BIGSERIAL PRIMARY KEY,
+    key VARCHAR(255) NOT NULL,
+    name VARCHAR(255),
+    requests_per_second DOUBLE PRECISION NOT NULL DEFAULT 0,
+    request_burst BIGINT NOT NULL DEFAULT 0,
+    submissions_per_minute DOUBLE PRECISION NOT NULL DEFAULT 0,
+    submission_burst BIGINT NOT NULL DEFAULT 0,
+    disabled BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_api_key on api_keys (key);
+
+ALTER TABLE transaction_details ADD COLUMN IF NOT EXISTS api_key_id BIGINT NOT NULL DEFAULT 0;
+CREATE INDEX IF NOT EXISTS idx_api_key_id on transaction_details (api_key_id);
+
+ALTER TABLE transaction_details ADD COLUMN IF NOT EXISTS request_id VARCHAR(255) NOT NULL DEFAULT '';
+
+ALTER TABLE transaction_details ADD COLUMN IF NOT EXISTS signer_address VARCHAR(255) NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS idx_signer_address on transaction_details (signer_address);
+
+CREATE TABLE IF NOT EXISTS tracked_receipts (
+    tx_hash VARCHAR(255) PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS tracked_submissions (
+    original_tx_hash VARCHAR(255) PRIMARY KEY,
+    signer_address VARCHAR(255) NOT NULL,
+    raw_tx BYTEA NOT NULL,
+    sent_block BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS submission_replacements (
+    id BIGSERIAL PRIMARY KEY,
+    original_tx_hash VARCHAR(255) NOT NULL,
+    tx_hash VARCHAR(255) NOT NULL,
+    signer_address VARCHAR(255) NOT NULL,
+    nonce BIGINT NOT NULL,
+    gas_tip_cap VARCHAR(255) NOT NULL,
+    gas_fee_cap VARCHAR(255) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_original_tx_hash on submission_replacements (original_tx_hash);
+
+CREATE TABLE IF NOT EXISTS spendings (
+    id BIGSERIAL PRIMARY KEY,
+    signer_address VARCHAR(255) NOT NULL,
+    tx_hash VARCHAR(255) NOT NULL,
+    amount NUMERIC NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_spending_created_at on spendings (created_at);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_spending_tx_hash on spendings (tx_hash);
+
+CREATE TABLE IF NOT EXISTS fee_payments (
+    tx_hash VARCHAR(255) PRIMARY KEY,
+    payer_address VARCHAR(255) NOT NULL,
+    signer_address VARCHAR(255) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
 
 DO $$
 BEGIN
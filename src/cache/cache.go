@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"sync"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/shutter-network/encrypting-rpc-server/utils"
 )
@@ -12,6 +13,8 @@ type TransactionInfo struct {
 	Tx         *types.Transaction
 	CachedTime int64
 	Delayed    bool
+	APIKeyID   uint
+	RequestID  string
 }
 
 type Cache struct {
@@ -50,6 +53,99 @@ func (c *Cache) UpdateEntry(key string, tx *types.Transaction, cachedTime int64,
 		key, c.Data[key].CachedTime)
 }
 
+// SetRequest records the API key and the id of the request the transaction cached at key was
+// submitted with.
+func (c *Cache) SetRequest(key string, apiKeyID uint, requestID string) {
+	c.Lock()
+	defer c.Unlock()
+	if info, found := c.Data[key]; found {
+		info.APIKeyID = apiKeyID
+		info.RequestID = requestID
+		c.Data[key] = info
+	}
+}
+
+// TakeExpired removes the entries whose delay has passed at currentTime and returns the ones among
+// them which are waiting to be sent.
+func (c *Cache) TakeExpired(currentTime int64) []TransactionInfo {
+	c.Lock()
+	defer c.Unlock()
+	var delayed []TransactionInfo
+	for key, info := range c.Data {
+		if info.CachedTime+c.DelayFactor <= currentTime {
+			utils.Logger.Debug().Msgf("Deleting entry at key [%s]", key)
+			delete(c.Data, key)
+			if info.Delayed {
+				delayed = append(delayed, info)
+			}
+		}
+	}
+	return delayed
+}
+
+// TakeDelayed removes all entries which are waiting to be sent, regardless of their delay, and
+// returns them.
+func (c *Cache) TakeDelayed() []TransactionInfo {
+	c.Lock()
+	defer c.Unlock()
+	var delayed []TransactionInfo
+	for key, info := range c.Data {
+		if info.Delayed {
+			delete(c.Data, key)
+			delayed = append(delayed, info)
+		}
+	}
+	return delayed
+}
+
+// FindByHash returns the entry of the transaction with txHash.
+func (c *Cache) FindByHash(txHash common.Hash) (TransactionInfo, bool) {
+	c.RLock()
+	defer c.RUnlock()
+	for _, info := range c.Data {
+		if info.Tx.Hash() == txHash {
+			return info, true
+		}
+	}
+	return TransactionInfo{}, false
+}
+
+// TrackReceipt marks txHash as waiting for its receipt. It returns false if it already is.
+func (c *Cache) TrackReceipt(txHash string) bool {
+	c.Lock()
+	defer c.Unlock()
+	if c.WaitingForReceiptCache[txHash] {
+		return false
+	}
+	c.WaitingForReceiptCache[txHash] = true
+	return true
+}
+
+func (c *Cache) IsTrackingReceipt(txHash string) bool {
+	c.RLock()
+	defer c.RUnlock()
+	return c.WaitingForReceiptCache[txHash]
+}
+
+func (c *Cache) UntrackReceipt(txHash string) {
+	c.Lock()
+	defer c.Unlock()
+	delete(c.WaitingForReceiptCache, txHash)
+}
+
+// TrackedReceipts returns the hashes of the transactions still waiting for their receipt.
+func (c *Cache) TrackedReceipts() []string {
+	c.RLock()
+	defer c.RUnlock()
+	hashes := make([]string, 0, len(c.WaitingForReceiptCache))
+	for txHash, waiting := range c.WaitingForReceiptCache {
+		if waiting {
+			hashes = append(hashes, txHash)
+		}
+	}
+	return hashes
+}
+
 func (c *Cache) ProcessTxEntry(newTx *types.Transaction, currentTime int64) (ProcessTxEntryResp, error) {
 	key, err := c.Key(newTx)
 	if err != nil {
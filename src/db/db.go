@@ -5,42 +5,124 @@ import (
 	"fmt"
 
 	"github.com/shutter-network/encrypting-rpc-server/utils"
+	"gorm.io/gorm/clause"
 )
 
 func (db *PostgresDb) InsertNewTx(txDetails TransactionDetails) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		utils.Logger.Warn().Msgf("Database closed, not recording tx | txHash: %s", txDetails.TxHash)
+		return
+	}
 	db.AddTxCh <- txDetails
 }
 
+func (db *PostgresDb) InsertSpending(spending Spending) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		utils.Logger.Warn().Msgf("Database closed, not recording spending | txHash: %s", spending.TxHash)
+		return
+	}
+	db.SpendingCh <- spending
+}
+
 // txhash and inclusion time are mandatory fields to update the finalised tx
 func (db *PostgresDb) FinaliseTx(receipt TransactionDetails) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		utils.Logger.Warn().Msgf("Database closed, not updating inclusion time | txHash: %s", receipt.TxHash)
+		return
+	}
 	db.InclusionCh <- receipt
 }
 
-func (db *PostgresDb) Start(ctx context.Context) {
+// Start writes the queued transactions to the database until Close is called. It does not stop
+// when the server context is done, so that writes of requests still being served are not lost.
+func (db *PostgresDb) Start() {
+	defer close(db.done)
 	sqlDb, err := db.DB.DB()
 	if err != nil {
 		utils.Logger.Info().Msgf("cannot initiate sqlDb | err: %v", err)
 		panic(fmt.Sprintf("cannot initiate sqlDb | err: %v", err))
 	}
 	defer sqlDb.Close()
-	for {
 
+	for {
 		select {
 		case txDetails := <-db.AddTxCh:
-			if err := db.DB.Create(txDetails).Error; err != nil {
-				utils.Logger.Info().Msgf("Error recording tx | txHash: %s | err: %v", txDetails.TxHash, err)
-				continue
-			}
+			db.recordTx(txDetails)
 		case txDetails := <-db.InclusionCh:
-			if err := db.updateInclusion(txDetails); err != nil {
-				utils.Logger.Info().Msgf("Error updating inclusion time | txHash: %s | err: %v", txDetails.TxHash, err)
-				continue
+			db.recordInclusion(txDetails)
+		case spending := <-db.SpendingCh:
+			db.recordSpending(spending)
+		case <-db.closing:
+			// nothing is queued anymore after Close, so what is left in the channels is all
+			for {
+				select {
+				case txDetails := <-db.AddTxCh:
+					db.recordTx(txDetails)
+				case txDetails := <-db.InclusionCh:
+					db.recordInclusion(txDetails)
+				case spending := <-db.SpendingCh:
+					db.recordSpending(spending)
+				default:
+					return
+				}
 			}
-
-		case <-ctx.Done():
-			close(db.AddTxCh)
-			close(db.InclusionCh)
-			return
 		}
 	}
 }
+
+func (db *PostgresDb) recordTx(txDetails TransactionDetails) {
+	if err := db.DB.Create(txDetails).Error; err != nil {
+		utils.Logger.Info().Msgf("Error recording tx | txHash: %s | err: %v", txDetails.TxHash, err)
+	}
+}
+
+func (db *PostgresDb) recordInclusion(txDetails TransactionDetails) {
+	if err := db.updateInclusion(txDetails); err != nil {
+		utils.Logger.Info().Msgf("Error updating inclusion time | txHash: %s | err: %v", txDetails.TxHash, err)
+	}
+}
+
+// recordSpending inserts spending unless it has been reconciled already, which may happen before
+// the queued insert is written.
+func (db *PostgresDb) recordSpending(spending Spending) {
+	onConflict := clause.OnConflict{Columns: []clause.Column{{Name: "tx_hash"}}, DoNothing: true}
+	if err := db.DB.Clauses(onConflict).Create(&spending).Error; err != nil {
+		utils.Logger.Info().Msgf("Error recording spending | txHash: %s | err: %v", spending.TxHash, err)
+	}
+}
+
+// Close stops accepting writes and waits until the queued ones have been written or ctx is done.
+// Writes after Close are dropped, so that requests and receipt trackers still running during
+// shutdown do not fail.
+func (db *PostgresDb) Close(ctx context.Context) error {
+	// waits for writers which are queueing right now
+	db.mu.Lock()
+	wasClosed := db.closed
+	db.closed = true
+	db.mu.Unlock()
+	if !wasClosed {
+		close(db.closing)
+	}
+
+	select {
+	case <-db.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Ping checks that the database can be reached.
+func (db *PostgresDb) Ping(ctx context.Context) error {
+	sqlDb, err := db.DB.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDb.PingContext(ctx)
+}
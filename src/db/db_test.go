@@ -0,0 +1,58 @@
+package db_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/shutter-network/encrypting-rpc-server/db"
+	"github.com/shutter-network/encrypting-rpc-server/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClose_WritesQueuedTransactions(t *testing.T) {
+	mockDb, pgDb := test.NewPostgresTestDB(t)
+	// both channels are drained concurrently
+	mockDb.MatchExpectationsInOrder(false)
+
+	mockDb.ExpectBegin()
+	mockDb.ExpectExec(`INSERT INTO "transaction_details"`).WillReturnResult(sqlmock.NewResult(1, 1))
+	mockDb.ExpectCommit()
+	mockDb.ExpectBegin()
+	mockDb.ExpectExec(`UPDATE "transaction_details"`).WillReturnResult(sqlmock.NewResult(1, 1))
+	mockDb.ExpectCommit()
+	mockDb.ExpectClose()
+
+	pgDb.InsertNewTx(db.TransactionDetails{Address: "0x1", Nonce: 1, TxHash: "0x2", EncryptedTxHash: "0x3"})
+	pgDb.FinaliseTx(db.TransactionDetails{TxHash: "0x2", InclusionTime: 10})
+
+	go pgDb.Start()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(t, pgDb.Close(ctx))
+	assert.NoError(t, mockDb.ExpectationsWereMet(), "Expected queued writes to be done before Close returns")
+}
+
+func TestClose_DropsLateWrites(t *testing.T) {
+	mockDb, pgDb := test.NewPostgresTestDB(t)
+	mockDb.ExpectClose()
+
+	go pgDb.Start()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(t, pgDb.Close(ctx))
+
+	assert.NotPanics(t, func() {
+		pgDb.InsertNewTx(db.TransactionDetails{Address: "0x1", Nonce: 1, TxHash: "0x2", EncryptedTxHash: "0x3"})
+		pgDb.FinaliseTx(db.TransactionDetails{TxHash: "0x2", InclusionTime: 10})
+		pgDb.InsertSpending(db.Spending{TxHash: "0x2", Amount: "1"})
+	})
+	assert.Empty(t, pgDb.AddTxCh)
+	assert.Empty(t, pgDb.InclusionCh)
+	assert.Empty(t, pgDb.SpendingCh)
+	assert.NoError(t, pgDb.Close(ctx), "closing twice should be fine")
+	assert.NoError(t, mockDb.ExpectationsWereMet())
+}
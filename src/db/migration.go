@@ -2,6 +2,8 @@ package db
 
 import (
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/shutter-network/encrypting-rpc-server/utils"
 	"gorm.io/driver/postgres"
@@ -15,6 +17,26 @@ type PostgresDb struct {
 	DB          *gorm.DB
 	AddTxCh     chan TransactionDetails
 	InclusionCh chan TransactionDetails
+	SpendingCh  chan Spending
+
+	// mu guards closed, writers hold it for reading while they queue
+	mu     sync.RWMutex
+	closed bool
+	// closing is closed by Close, Start writes what is queued and returns then
+	closing chan struct{}
+	// done is closed once Start has written everything queued before Close
+	done chan struct{}
+}
+
+func NewPostgresDb(db *gorm.DB, bufferSize int) *PostgresDb {
+	return &PostgresDb{
+		DB:          db,
+		AddTxCh:     make(chan TransactionDetails, bufferSize),
+		InclusionCh: make(chan TransactionDetails, bufferSize),
+		SpendingCh:  make(chan Spending, bufferSize),
+		closing:     make(chan struct{}),
+		done:        make(chan struct{}),
+	}
 }
 
 type TransactionDetails struct {
@@ -22,9 +44,78 @@ type TransactionDetails struct {
 	Nonce           uint64 `gorm:"primaryKey;index:idx_address_nonce"`
 	TxHash          string `gorm:"primaryKey;index:idx_tx_hash"`
 	EncryptedTxHash string `gorm:"primaryKey;index:idx_encrypted_tx_hash"`
+	SignerAddress   string `gorm:"index:idx_signer_address"`
 	SubmissionTime  int64
 	InclusionTime   uint64
 	IsCancellation  bool
+	APIKeyID        uint `gorm:"index:idx_api_key_id"`
+	RequestID       string
+}
+
+// TrackedReceipt is a transaction whose receipt is waited for. It is removed once the receipt has
+// been found or waiting stopped, so that waiting is resumed on the next start, also after a crash.
+type TrackedReceipt struct {
+	TxHash    string `gorm:"primaryKey"`
+	CreatedAt time.Time
+}
+
+// TrackedSubmission is a sequencer transaction which the submission monitor watches. RawTx is its
+// latest replacement, OriginalTxHash the first transaction. It is removed once the transaction or
+// one of its replacements is mined, so that watching is resumed on the next start, also after a
+// crash.
+type TrackedSubmission struct {
+	OriginalTxHash string `gorm:"primaryKey"`
+	SignerAddress  string
+	RawTx          []byte
+	SentBlock      uint64
+	CreatedAt      time.Time
+}
+
+// SubmissionReplacement records that a sequencer transaction which was not mined in time has been
+// sent again with the same nonce and higher fees. OriginalTxHash is the first transaction, also
+// for replacements of replacements.
+type SubmissionReplacement struct {
+	ID             uint   `gorm:"primaryKey"`
+	OriginalTxHash string `gorm:"index:idx_original_tx_hash"`
+	TxHash         string
+	SignerAddress  string
+	Nonce          uint64
+	GasTipCap      string
+	GasFeeCap      string
+	CreatedAt      time.Time
+}
+
+// FeePayment is a fee paid for relaying a pre-encrypted transaction. Each payment is accepted
+// once, the row is removed again if relaying failed.
+type FeePayment struct {
+	TxHash        string `gorm:"primaryKey"`
+	PayerAddress  string
+	SignerAddress string
+	CreatedAt     time.Time
+}
+
+// Spending is what the signing accounts paid for a submission to the sequencer, the value
+// forwarded for the encrypted transaction plus the maximum fee of the submission, in wei. Once the
+// submission is mined, the amount is replaced by what it actually cost.
+type Spending struct {
+	ID            uint `gorm:"primaryKey"`
+	SignerAddress string
+	TxHash        string    `gorm:"uniqueIndex:idx_spending_tx_hash"`
+	Amount        string    `gorm:"type:numeric"`
+	CreatedAt     time.Time `gorm:"index:idx_spending_created_at"`
+}
+
+// APIKey grants access to the server. Rates of zero mean unlimited.
+type APIKey struct {
+	ID                   uint   `gorm:"primaryKey"`
+	Key                  string `gorm:"uniqueIndex:idx_api_key"`
+	Name                 string
+	RequestsPerSecond    float64
+	RequestBurst         int
+	SubmissionsPerMinute float64
+	SubmissionBurst      int
+	Disabled             bool
+	CreatedAt            time.Time
 }
 
 func InitialMigration(dbUrl string) (*PostgresDb, error) {
@@ -38,13 +129,10 @@ func InitialMigration(dbUrl string) (*PostgresDb, error) {
 	}
 
 	// run migrations
-	if err := db.AutoMigrate(TransactionDetails{}); err != nil {
+	if err := db.AutoMigrate(TransactionDetails{}, APIKey{}, TrackedReceipt{}, TrackedSubmission{}, SubmissionReplacement{}, FeePayment{}, Spending{}); err != nil {
 		utils.Logger.Error().Err(err).Msg("failed to automigrate tables")
 		return nil, fmt.Errorf("failed to automigrate tables | err: %v", err)
 	}
 
-	inclusionCh := make(chan TransactionDetails, BufferSize)
-	addTxCh := make(chan TransactionDetails, BufferSize)
-
-	return &PostgresDb{DB: db, AddTxCh: addTxCh, InclusionCh: inclusionCh}, nil
+	return NewPostgresDb(db, BufferSize), nil
 }
@@ -1,7 +1,10 @@
 package db
 
 import (
+	"time"
+
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 func (db *PostgresDb) updateInclusion(txDetails TransactionDetails) error {
@@ -23,3 +26,125 @@ func (db *PostgresDb) updateInclusion(txDetails TransactionDetails) error {
 	}
 	return nil
 }
+
+// GetTransactionDetails returns all rows recorded for txHash, the latest submission first.
+func (db *PostgresDb) GetTransactionDetails(txHash string) ([]TransactionDetails, error) {
+	var details []TransactionDetails
+	if err := db.DB.Where("tx_hash = ?", txHash).Order("submission_time desc").Find(&details).Error; err != nil {
+		return nil, err
+	}
+	return details, nil
+}
+
+func (db *PostgresDb) GetAPIKeys() ([]APIKey, error) {
+	var keys []APIKey
+	if err := db.DB.Where("disabled = ?", false).Find(&keys).Error; err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (db *PostgresDb) SaveTrackedReceipts(txHashes []string) error {
+	if len(txHashes) == 0 {
+		return nil
+	}
+	receipts := make([]TrackedReceipt, 0, len(txHashes))
+	for _, txHash := range txHashes {
+		receipts = append(receipts, TrackedReceipt{TxHash: txHash})
+	}
+	return db.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&receipts).Error
+}
+
+// GetTrackedReceipts returns the hashes of the transactions whose receipts are waited for.
+func (db *PostgresDb) GetTrackedReceipts() ([]string, error) {
+	var receipts []TrackedReceipt
+	if err := db.DB.Find(&receipts).Error; err != nil {
+		return nil, err
+	}
+
+	txHashes := make([]string, 0, len(receipts))
+	for _, receipt := range receipts {
+		txHashes = append(txHashes, receipt.TxHash)
+	}
+	return txHashes, nil
+}
+
+// DeleteTrackedReceipt removes txHash once its receipt has been found or waiting for it stopped.
+func (db *PostgresDb) DeleteTrackedReceipt(txHash string) error {
+	return db.DB.Delete(&TrackedReceipt{TxHash: txHash}).Error
+}
+
+func (db *PostgresDb) SaveTrackedSubmissions(submissions []TrackedSubmission) error {
+	if len(submissions) == 0 {
+		return nil
+	}
+	return db.DB.Clauses(clause.OnConflict{UpdateAll: true}).Create(&submissions).Error
+}
+
+// GetTrackedSubmissions returns the saved submissions.
+func (db *PostgresDb) GetTrackedSubmissions() ([]TrackedSubmission, error) {
+	var submissions []TrackedSubmission
+	if err := db.DB.Find(&submissions).Error; err != nil {
+		return nil, err
+	}
+	return submissions, nil
+}
+
+// DeleteTrackedSubmissions removes the submissions with the given original hashes, which are no
+// longer watched.
+func (db *PostgresDb) DeleteTrackedSubmissions(originalTxHashes []string) error {
+	if len(originalTxHashes) == 0 {
+		return nil
+	}
+	return db.DB.Where("original_tx_hash IN ?", originalTxHashes).Delete(&TrackedSubmission{}).Error
+}
+
+func (db *PostgresDb) InsertReplacement(replacement SubmissionReplacement) error {
+	return db.DB.Create(&replacement).Error
+}
+
+// LatestReplacement returns the hash of the latest replacement of the sequencer transaction
+// originalTxHash, empty if it has not been replaced.
+func (db *PostgresDb) LatestReplacement(originalTxHash string) (string, error) {
+	var replacements []SubmissionReplacement
+	if err := db.DB.Where("original_tx_hash = ?", originalTxHash).Order("id desc").Limit(1).Find(&replacements).Error; err != nil {
+		return "", err
+	}
+	if len(replacements) == 0 {
+		return "", nil
+	}
+	return replacements[0].TxHash, nil
+}
+
+// ClaimFeePayment records payment and reports whether it has not been recorded before.
+func (db *PostgresDb) ClaimFeePayment(payment FeePayment) (bool, error) {
+	result := db.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&payment)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// ReleaseFeePayment removes the fee payment txHash, so that it can be used again.
+func (db *PostgresDb) ReleaseFeePayment(txHash string) error {
+	return db.DB.Delete(&FeePayment{TxHash: txHash}).Error
+}
+
+// ReconcileSpending replaces the amount spent on the submission of spending, and inserts spending
+// if the queued insert has not been written yet.
+func (db *PostgresDb) ReconcileSpending(spending Spending) error {
+	onConflict := clause.OnConflict{
+		Columns:   []clause.Column{{Name: "tx_hash"}},
+		DoUpdates: clause.AssignmentColumns([]string{"amount"}),
+	}
+	return db.DB.Clauses(onConflict).Create(&spending).Error
+}
+
+// GetSpendingsSince returns the spendings recorded after since, oldest first.
+func (db *PostgresDb) GetSpendingsSince(since time.Time) ([]Spending, error) {
+	var spendings []Spending
+	if err := db.DB.Where("created_at > ?", since).Order("created_at").Find(&spendings).Error; err != nil {
+		return nil, err
+	}
+	return spendings, nil
+}
@@ -6,10 +6,12 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/rs/zerolog/log"
 	"github.com/shutter-network/encrypting-rpc-server/db"
 	"github.com/shutter-network/encrypting-rpc-server/metrics"
+	"github.com/shutter-network/encrypting-rpc-server/upstream"
 	"github.com/shutter-network/encrypting-rpc-server/utils"
 	"github.com/shutter-network/rolling-shutter/rolling-shutter/medley/encodeable/url"
 	"github.com/shutter-network/rolling-shutter/rolling-shutter/medley/metricsserver"
@@ -25,28 +27,70 @@ import (
 	sequencerBindings "github.com/shutter-network/gnosh-contracts/gnoshcontracts/sequencer"
 	shopContractBindings "github.com/shutter-network/shop-contracts/bindings"
 
-	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/shutter-network/rolling-shutter/rolling-shutter/cmd/shversion"
 
 	"github.com/spf13/cobra"
 )
 
 var Config struct {
-	SigningKey                  string `mapstructure:"signing-key"`
-	KeyperSetChangeLookAhead    int    `mapstructure:"keyper-set-change-look-ahead"`
-	RPCUrl                      string `mapstructure:"rpc-url"`
-	HTTPListenAddress           string `mapstructure:"http-listen-address"`
-	KeyBroadcastContractAddress string `mapstructure:"key-broadcast-contract-address"`
-	SequencerAddress            string `mapstructure:"sequencer-address"`
-	KeyperSetManagerAddress     string `mapstructure:"keyperset-manager-address"`
-	DelayInSeconds              int    `mapstructure:"delay-in-seconds"`
-	EncryptedGasLimit           uint64 `mapstructure:"encrypted-gas-limit"`
-	DbUrl                       string `mapstructure:"dburl"`
-	WaitMinedInterval           int    `mapstructure:"wait-mined-interval"`
+	SigningKey                  string         `mapstructure:"signing-key"`
+	SigningKeys                 []string       `mapstructure:"signing-keys"`
+	SigningKeystore             string         `mapstructure:"signing-keystore"`
+	SigningKeystorePasswordFile string         `mapstructure:"signing-keystore-password-file"`
+	RemoteSignerURL             string         `mapstructure:"remote-signer-url"`
+	RemoteSignerAddresses       []string       `mapstructure:"remote-signer-addresses"`
+	KeyperSetChangeLookAhead    int            `mapstructure:"keyper-set-change-look-ahead"`
+	RPCUrls                     []string       `mapstructure:"rpc-url"`
+	HealthCheckInterval         int            `mapstructure:"upstream-health-check-interval"`
+	MaxBlockLag                 uint64         `mapstructure:"upstream-max-block-lag"`
+	MaxErrorRate                float64        `mapstructure:"upstream-max-error-rate"`
+	HTTPListenAddress           string         `mapstructure:"http-listen-address"`
+	MaxRequestBodySize          int64          `mapstructure:"max-request-body-size"`
+	MaxBatchSize                int            `mapstructure:"max-batch-size"`
+	ShutdownTimeout             int            `mapstructure:"shutdown-timeout"`
+	TLSCertFile                 string         `mapstructure:"tls-cert-file"`
+	TLSKeyFile                  string         `mapstructure:"tls-key-file"`
+	TLSClientCAFile             string         `mapstructure:"tls-client-ca-file"`
+	TLSReloadInterval           int            `mapstructure:"tls-reload-interval"`
+	WSRPCUrl                    string         `mapstructure:"ws-rpc-url"`
+	WSListenAddress             string         `mapstructure:"ws-listen-address"`
+	RoutingConfig               string         `mapstructure:"routing-config"`
+	CORSConfig                  string         `mapstructure:"cors-config"`
+	ResponseCacheTTLs           map[string]int `mapstructure:"response-cache-ttls"`
+	ResponseCacheSize           int            `mapstructure:"response-cache-size"`
+	FinalityDepth               uint64         `mapstructure:"finality-depth"`
+	HeadPollInterval            int            `mapstructure:"head-poll-interval"`
+	APIKeysEnabled              bool           `mapstructure:"api-keys-enabled"`
+	APIKeyRequired              bool           `mapstructure:"api-key-required"`
+	APIKeyRefreshInterval       int            `mapstructure:"api-key-refresh-interval"`
+	IPRateLimit                 float64        `mapstructure:"ip-rate-limit"`
+	IPRateBurst                 int            `mapstructure:"ip-rate-burst"`
+	SenderRateLimit             float64        `mapstructure:"sender-rate-limit"`
+	SenderRateBurst             int            `mapstructure:"sender-rate-burst"`
+	TrustProxyHeaders           bool           `mapstructure:"trust-proxy-headers"`
+	KeyBroadcastContractAddress string         `mapstructure:"key-broadcast-contract-address"`
+	SequencerAddress            string         `mapstructure:"sequencer-address"`
+	KeyperSetManagerAddress     string         `mapstructure:"keyperset-manager-address"`
+	DelayInSeconds              int            `mapstructure:"delay-in-seconds"`
+	EncryptedGasLimit           uint64         `mapstructure:"encrypted-gas-limit"`
+	DbUrl                       string         `mapstructure:"dburl"`
+	WaitMinedInterval           int            `mapstructure:"wait-mined-interval"`
 	MetricsConfig               metrics_server.MetricsConfig
-	FetchBalanceDelay           int    `mapstructure:"fetch-balance-delay"`
-	GasPriceMultiplier          int    `mapstructure:"fetch-balance-delay"`
-	EffectivePriorityFee        uint64 `mapstructure:"effective-priority-fee"`
+	FetchBalanceDelay           int     `mapstructure:"fetch-balance-delay"`
+	EonRefreshInterval          int     `mapstructure:"eon-refresh-interval"`
+	EonKeyAlertBlocks           uint64  `mapstructure:"eon-key-alert-blocks"`
+	NonceResyncInterval         int     `mapstructure:"nonce-resync-interval"`
+	MinSignerBalance            float64 `mapstructure:"min-signer-balance"`
+	HourlySpendingLimit         float64 `mapstructure:"hourly-spending-limit"`
+	DailySpendingLimit          float64 `mapstructure:"daily-spending-limit"`
+	MaxTransactionValue         float64 `mapstructure:"max-transaction-value"`
+	GasPriceMultiplier          int     `mapstructure:"fetch-balance-delay"`
+	EffectivePriorityFee        uint64  `mapstructure:"effective-priority-fee"`
+	MaxPriorityFeePerGas        uint64  `mapstructure:"max-priority-fee-per-gas"`
+	MaxFeePerGas                uint64  `mapstructure:"max-fee-per-gas"`
+	BaseFeeMultiplier           uint64  `mapstructure:"base-fee-multiplier"`
+	ReplacementFeeBump          uint64  `mapstructure:"replacement-fee-bump"`
+	StuckSubmissionBlocks       uint64  `mapstructure:"stuck-submission-blocks"`
 }
 
 func Cmd() *cobra.Command {
@@ -74,6 +118,46 @@ func Cmd() *cobra.Command {
 		"private key to sign and submit transactions with",
 	)
 
+	cmd.PersistentFlags().StringVarP(
+		&Config.SigningKeystore,
+		"signing-keystore",
+		"",
+		"",
+		"go-ethereum keystore file with the signing key, instead of signing-key",
+	)
+
+	cmd.PersistentFlags().StringVarP(
+		&Config.SigningKeystorePasswordFile,
+		"signing-keystore-password-file",
+		"",
+		"",
+		"file with the password of signing-keystore, if not set it is read from "+utils.KeystorePasswordEnv,
+	)
+
+	cmd.PersistentFlags().StringSliceVarP(
+		&Config.SigningKeys,
+		"signing-keys",
+		"",
+		nil,
+		"additional private keys, submissions are spread across them and signing-key",
+	)
+
+	cmd.PersistentFlags().StringVarP(
+		&Config.RemoteSignerURL,
+		"remote-signer-url",
+		"",
+		"",
+		"URL of a signer with eth_signTransaction, e.g. Clef or Web3Signer, which signs for remote-signer-addresses",
+	)
+
+	cmd.PersistentFlags().StringSliceVarP(
+		&Config.RemoteSignerAddresses,
+		"remote-signer-addresses",
+		"",
+		nil,
+		"addresses the remote signer signs submissions for",
+	)
+
 	cmd.PersistentFlags().StringVarP(
 		&Config.HTTPListenAddress,
 		"http-listen-address",
@@ -82,12 +166,227 @@ func Cmd() *cobra.Command {
 		"server listening address",
 	)
 
+	cmd.PersistentFlags().Int64VarP(
+		&Config.MaxRequestBodySize,
+		"max-request-body-size",
+		"",
+		5*1024*1024,
+		"maximum size of request bodies in bytes, larger requests get a JSON-RPC error",
+	)
+
+	cmd.PersistentFlags().IntVarP(
+		&Config.MaxBatchSize,
+		"max-batch-size",
+		"",
+		100,
+		"maximum number of calls in a JSON-RPC batch, larger batches get a JSON-RPC error, 0 means unlimited",
+	)
+
+	cmd.PersistentFlags().IntVarP(
+		&Config.ShutdownTimeout,
+		"shutdown-timeout",
+		"",
+		30,
+		"seconds to finish requests, send delayed transactions and write to the database on shutdown",
+	)
+
+	cmd.PersistentFlags().StringVarP(
+		&Config.TLSCertFile,
+		"tls-cert-file",
+		"",
+		"",
+		"path to a PEM certificate, the http and websocket servers use TLS if set",
+	)
+
 	cmd.PersistentFlags().StringVarP(
-		&Config.RPCUrl,
+		&Config.TLSKeyFile,
+		"tls-key-file",
+		"",
+		"",
+		"path to the PEM private key of the tls certificate",
+	)
+
+	cmd.PersistentFlags().StringVarP(
+		&Config.TLSClientCAFile,
+		"tls-client-ca-file",
+		"",
+		"",
+		"path to PEM CA certificates, clients have to present a certificate signed by one of them if set",
+	)
+
+	cmd.PersistentFlags().IntVarP(
+		&Config.TLSReloadInterval,
+		"tls-reload-interval",
+		"",
+		60,
+		"interval in seconds for checking the tls files for changes",
+	)
+
+	cmd.PersistentFlags().StringSliceVarP(
+		&Config.RPCUrls,
 		"rpc-url",
 		"",
-		"http://localhost:8545",
-		"address to forward requests to",
+		[]string{"http://localhost:8545"},
+		"addresses to forward requests to, in order of preference",
+	)
+
+	cmd.PersistentFlags().IntVarP(
+		&Config.HealthCheckInterval,
+		"upstream-health-check-interval",
+		"",
+		10,
+		"interval in seconds between upstream health checks",
+	)
+
+	cmd.PersistentFlags().Uint64VarP(
+		&Config.MaxBlockLag,
+		"upstream-max-block-lag",
+		"",
+		5,
+		"number of blocks an upstream can be behind the others before it is considered unhealthy",
+	)
+
+	cmd.PersistentFlags().Float64VarP(
+		&Config.MaxErrorRate,
+		"upstream-max-error-rate",
+		"",
+		0.5,
+		"share of failed requests above which an upstream is considered unhealthy",
+	)
+
+	cmd.PersistentFlags().StringVarP(
+		&Config.WSListenAddress,
+		"ws-listen-address",
+		"",
+		"",
+		"websocket server listening address, websocket is disabled if empty",
+	)
+
+	cmd.PersistentFlags().StringVarP(
+		&Config.WSRPCUrl,
+		"ws-rpc-url",
+		"",
+		"",
+		"websocket address to forward requests and subscriptions to",
+	)
+
+	cmd.PersistentFlags().StringVarP(
+		&Config.RoutingConfig,
+		"routing-config",
+		"",
+		"",
+		"path to a JSON file with method routes and named backends",
+	)
+
+	cmd.PersistentFlags().StringVarP(
+		&Config.CORSConfig,
+		"cors-config",
+		"",
+		"",
+		"path to a JSON file with CORS policies, by default all origins are allowed",
+	)
+
+	cmd.PersistentFlags().StringToIntVarP(
+		&Config.ResponseCacheTTLs,
+		"response-cache-ttls",
+		"",
+		map[string]int{
+			"eth_chainId":               3600,
+			"net_version":               3600,
+			"eth_blockNumber":           5,
+			"eth_getBlockByNumber":      300,
+			"eth_getBlockByHash":        300,
+			"eth_getTransactionReceipt": 300,
+		},
+		"TTL in seconds per method for cached upstream responses, an empty map disables the cache",
+	)
+
+	cmd.PersistentFlags().IntVarP(
+		&Config.ResponseCacheSize,
+		"response-cache-size",
+		"",
+		10000,
+		"maximum number of cached upstream responses, the least recently used ones are dropped first",
+	)
+
+	cmd.PersistentFlags().Uint64VarP(
+		&Config.FinalityDepth,
+		"finality-depth",
+		"",
+		20,
+		"number of blocks after which blocks and receipts are considered final and are cached",
+	)
+
+	cmd.PersistentFlags().IntVarP(
+		&Config.HeadPollInterval,
+		"head-poll-interval",
+		"",
+		1,
+		"interval in seconds for checking for new blocks to invalidate cached responses",
+	)
+
+	cmd.PersistentFlags().BoolVarP(
+		&Config.APIKeysEnabled,
+		"api-keys-enabled",
+		"",
+		false,
+		"accept api keys from the database and enforce their quotas",
+	)
+
+	cmd.PersistentFlags().BoolVarP(
+		&Config.APIKeyRequired,
+		"api-key-required",
+		"",
+		false,
+		"reject requests without a valid api key",
+	)
+
+	cmd.PersistentFlags().IntVarP(
+		&Config.APIKeyRefreshInterval,
+		"api-key-refresh-interval",
+		"",
+		60,
+		"interval in seconds for reloading api keys from the database",
+	)
+
+	cmd.PersistentFlags().Float64VarP(
+		&Config.IPRateLimit,
+		"ip-rate-limit",
+		"",
+		0,
+		"transaction submissions per minute allowed per client ip, 0 disables the limit, behind a proxy only enable it with trust-proxy-headers",
+	)
+
+	cmd.PersistentFlags().IntVarP(
+		&Config.IPRateBurst,
+		"ip-rate-burst",
+		"",
+		20,
+		"number of transaction submissions a client ip can make at once",
+	)
+
+	cmd.PersistentFlags().Float64VarP(
+		&Config.SenderRateLimit,
+		"sender-rate-limit",
+		"",
+		10,
+		"transaction submissions per minute allowed per sender address, 0 disables the limit",
+	)
+
+	cmd.PersistentFlags().IntVarP(
+		&Config.SenderRateBurst,
+		"sender-rate-burst",
+		"",
+		5,
+		"number of transaction submissions a sender address can make at once",
+	)
+
+	cmd.PersistentFlags().BoolVarP(
+		&Config.TrustProxyHeaders,
+		"trust-proxy-headers",
+		"",
+		false,
+		"take the client ip from the X-Forwarded-For or X-Real-IP header, only enable behind a trusted proxy",
 	)
 
 	cmd.PersistentFlags().StringVarP(
@@ -178,6 +477,62 @@ func Cmd() *cobra.Command {
 		"delay after which balance of signing address is re recorded",
 	)
 
+	cmd.PersistentFlags().IntVarP(
+		&Config.EonRefreshInterval,
+		"eon-refresh-interval",
+		"",
+		5,
+		"seconds between checks for new keyper sets and eon keys",
+	)
+
+	cmd.PersistentFlags().Uint64VarP(
+		&Config.EonKeyAlertBlocks,
+		"eon-key-alert-blocks",
+		"",
+		100,
+		"number of blocks before the activation of an eon below which a missing eon key is reported",
+	)
+
+	cmd.PersistentFlags().IntVarP(
+		&Config.NonceResyncInterval,
+		"nonce-resync-interval",
+		"",
+		30,
+		"seconds between comparisons of the local nonce of the signing address with the upstream",
+	)
+
+	cmd.PersistentFlags().Float64VarP(
+		&Config.MinSignerBalance,
+		"min-signer-balance",
+		"",
+		0,
+		"balance of the signing address in native tokens which it has to exceed for the server to be ready",
+	)
+
+	cmd.PersistentFlags().Float64VarP(
+		&Config.HourlySpendingLimit,
+		"hourly-spending-limit",
+		"",
+		0,
+		"native tokens the signing accounts may spend on submissions per hour, 0 for no limit",
+	)
+
+	cmd.PersistentFlags().Float64VarP(
+		&Config.DailySpendingLimit,
+		"daily-spending-limit",
+		"",
+		0,
+		"native tokens the signing accounts may spend on submissions per day, 0 for no limit",
+	)
+
+	cmd.PersistentFlags().Float64VarP(
+		&Config.MaxTransactionValue,
+		"max-transaction-value",
+		"",
+		0,
+		"native tokens the server pays the sequencer at most for a single transaction, 0 for no limit",
+	)
+
 	cmd.PersistentFlags().IntVarP(
 		&Config.GasPriceMultiplier,
 		"gas-price-multiplier",
@@ -194,19 +549,91 @@ func Cmd() *cobra.Command {
 		"effective priority fee",
 	)
 
+	cmd.PersistentFlags().Uint64VarP(
+		&Config.MaxPriorityFeePerGas,
+		"max-priority-fee-per-gas",
+		"",
+		0,
+		"cap in wei of the priority fee of submissions to the sequencer, 0 for no cap",
+	)
+
+	cmd.PersistentFlags().Uint64VarP(
+		&Config.MaxFeePerGas,
+		"max-fee-per-gas",
+		"",
+		0,
+		"cap in wei of the max fee of submissions to the sequencer, 0 for no cap",
+	)
+
+	cmd.PersistentFlags().Uint64VarP(
+		&Config.BaseFeeMultiplier,
+		"base-fee-multiplier",
+		"",
+		2,
+		"how many times the latest base fee the max fee of submissions to the sequencer covers",
+	)
+
+	cmd.PersistentFlags().Uint64VarP(
+		&Config.ReplacementFeeBump,
+		"replacement-fee-bump",
+		"",
+		10,
+		"fee increase in percent when replacing a stuck submission, at least 10",
+	)
+
+	cmd.PersistentFlags().Uint64VarP(
+		&Config.StuckSubmissionBlocks,
+		"stuck-submission-blocks",
+		"",
+		10,
+		"number of blocks after which an unmined submission to the sequencer is replaced, 0 to never replace",
+	)
+
 	return cmd
 }
 
 func Start() error {
-	signingKey, err := crypto.HexToECDSA(Config.SigningKey)
-	if err != nil {
-		utils.Logger.Fatal().Err(err).Msg("failed to parse signing key")
+	var (
+		signingKey *ecdsa.PrivateKey
+		err        error
+	)
+	if Config.SigningKey != "" && Config.SigningKeystore != "" {
+		utils.Logger.Fatal().Msg("signing-key and signing-keystore can not be used together")
+	}
+	if Config.SigningKey != "" {
+		signingKey, err = crypto.HexToECDSA(Config.SigningKey)
+		if err != nil {
+			utils.Logger.Fatal().Err(err).Msg("failed to parse signing key")
+		}
+	}
+	if Config.SigningKeystore != "" {
+		password, err := utils.ReadKeystorePassword(Config.SigningKeystorePasswordFile)
+		if err != nil {
+			utils.Logger.Fatal().Err(err).Msg("failed to read signing keystore password")
+		}
+		signingKey, err = utils.LoadKeystoreKey(Config.SigningKeystore, password)
+		if err != nil {
+			utils.Logger.Fatal().Err(err).Msg("failed to load signing key from keystore")
+		}
+	}
+	if (Config.RemoteSignerURL == "") != (len(Config.RemoteSignerAddresses) == 0) {
+		utils.Logger.Fatal().Msg("remote-signer-url and remote-signer-addresses have to be set together")
+	}
+	if signingKey == nil && Config.RemoteSignerURL == "" {
+		utils.Logger.Fatal().Msg("signing-key, signing-keystore or remote-signer-url is required")
 	}
 
 	if Config.KeyperSetChangeLookAhead < 1 {
 		utils.Logger.Fatal().Msg("keyper set change look ahead should be positive")
 	}
 
+	if (Config.TLSCertFile == "") != (Config.TLSKeyFile == "") {
+		utils.Logger.Fatal().Msg("tls-cert-file and tls-key-file have to be set together")
+	}
+	if Config.TLSClientCAFile != "" && Config.TLSCertFile == "" {
+		utils.Logger.Fatal().Msg("tls-client-ca-file requires tls-cert-file")
+	}
+
 	utils.Logger.Info().Msgf("Starting rpc server version %s", shversion.Version())
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -219,16 +646,22 @@ func Start() error {
 		cancel()
 	}()
 
-	publicKeyECDSA, ok := signingKey.Public().(*ecdsa.PublicKey)
-	if !ok {
-		utils.Logger.Fatal().Msg("can not create public key")
+	if len(Config.RPCUrls) == 0 {
+		utils.Logger.Fatal().Msg("at least one rpc-url is required")
+	}
+	if Config.HealthCheckInterval < 1 {
+		utils.Logger.Fatal().Msg("upstream health check interval should be positive")
 	}
-	publicAddress := crypto.PubkeyToAddress(*publicKeyECDSA)
 
-	client, err := ethclient.Dial(Config.RPCUrl)
+	upstreams, err := upstream.NewPool(Config.RPCUrls, upstream.Config{
+		HealthCheckInterval: time.Duration(Config.HealthCheckInterval) * time.Second,
+		MaxBlockLag:         Config.MaxBlockLag,
+		MaxErrorRate:        Config.MaxErrorRate,
+	})
 	if err != nil {
 		utils.Logger.Fatal().Err(err).Msg("can not connect to rpc")
 	}
+	client := upstream.NewClient(upstreams)
 
 	broadcastContract, err := shopContractBindings.NewKeyBroadcastContract(common.HexToAddress(Config.KeyBroadcastContractAddress), client)
 	if err != nil {
@@ -248,9 +681,43 @@ func Start() error {
 		utils.Logger.Fatal().Err(err).Msg("can not instantiate postgres")
 	}
 
+	var txSigners []rpc.TransactionSigner
+	if signingKey != nil {
+		txSigners = append(txSigners, rpc.NewKeySigner(signingKey))
+	}
+	for _, key := range Config.SigningKeys {
+		additionalKey, err := crypto.HexToECDSA(key)
+		if err != nil {
+			utils.Logger.Fatal().Err(err).Msg("can not parse signing-keys")
+		}
+		txSigners = append(txSigners, rpc.NewKeySigner(additionalKey))
+	}
+	for _, address := range Config.RemoteSignerAddresses {
+		if !common.IsHexAddress(address) {
+			utils.Logger.Fatal().Str("address", address).Msg("can not parse remote-signer-addresses")
+		}
+		remoteSigner, err := rpc.NewRemoteSigner(ctx, Config.RemoteSignerURL, common.HexToAddress(address))
+		if err != nil {
+			utils.Logger.Fatal().Err(err).Msg("can not connect to remote signer")
+		}
+		txSigners = append(txSigners, remoteSigner)
+	}
+
+	var signers []*rpc.Signer
+	for _, txSigner := range txSigners {
+		signer := rpc.NewSigner(client, txSigner)
+		for _, other := range signers {
+			if other.Address == signer.Address {
+				utils.Logger.Fatal().Str("address", signer.Address.Hex()).Msg("signing key is configured twice")
+			}
+		}
+		signers = append(signers, signer)
+	}
+	publicAddress := signers[0].Address
+
 	processor := rpc.Processor{
 		URL:                      Config.HTTPListenAddress,
-		RPCUrl:                   Config.RPCUrl,
+		RPCUrl:                   Config.RPCUrls[0],
 		SigningKey:               signingKey,
 		SigningAddress:           &publicAddress,
 		KeyperSetChangeLookAhead: Config.KeyperSetChangeLookAhead,
@@ -258,34 +725,110 @@ func Start() error {
 		KeyBroadcastContract:     broadcastContract,
 		SequencerContract:        sequencerContract,
 		KeyperSetManagerContract: keyperSetManagerContract,
+		EonKeys:                  rpc.NewEonKeyCache(keyperSetManagerContract, broadcastContract),
+		Signers:                  rpc.NewSignerPool(signers...),
 		Db:                       dbInst,
 		MetricsConfig:            &Config.MetricsConfig,
 	}
 
+	fees := &rpc.FeeStrategy{
+		BaseFeeMultiplier: Config.BaseFeeMultiplier,
+		BumpPercent:       Config.ReplacementFeeBump,
+	}
+	if Config.MaxPriorityFeePerGas > 0 {
+		fees.MaxTip = new(big.Int).SetUint64(Config.MaxPriorityFeePerGas)
+	}
+	if Config.MaxFeePerGas > 0 {
+		fees.MaxFee = new(big.Int).SetUint64(Config.MaxFeePerGas)
+	}
+	processor.Fees = fees
+	if Config.HourlySpendingLimit > 0 || Config.DailySpendingLimit > 0 || Config.MaxTransactionValue > 0 {
+		limits := rpc.SpendingLimits{}
+		if Config.HourlySpendingLimit > 0 {
+			limits.Hourly = toWei(Config.HourlySpendingLimit)
+		}
+		if Config.DailySpendingLimit > 0 {
+			limits.Daily = toWei(Config.DailySpendingLimit)
+		}
+		if Config.MaxTransactionValue > 0 {
+			limits.MaxTransactionValue = toWei(Config.MaxTransactionValue)
+		}
+		processor.Spending = rpc.NewSpendingLimiter(limits, dbInst)
+		if err := processor.Spending.Load(); err != nil {
+			utils.Logger.Fatal().Err(err).Msg("can not load spendings")
+		}
+	}
+	// the monitor also reconciles the spendings once submissions are mined
+	if Config.StuckSubmissionBlocks > 0 || processor.Spending != nil {
+		processor.Submissions = rpc.NewSubmissionMonitor(processor, Config.StuckSubmissionBlocks)
+	}
+
 	backendURL := &url.URL{}
-	err = backendURL.UnmarshalText([]byte(Config.RPCUrl))
+	err = backendURL.UnmarshalText([]byte(Config.RPCUrls[0]))
 	if err != nil {
 		utils.Logger.Fatal().Err(err).Msg("failed to parse RPCUrl")
 	}
 
+	wsBackendURL := &url.URL{}
+	if Config.WSListenAddress != "" {
+		if Config.WSRPCUrl == "" {
+			utils.Logger.Fatal().Msg("ws-rpc-url is required when ws-listen-address is set")
+		}
+		err = wsBackendURL.UnmarshalText([]byte(Config.WSRPCUrl))
+		if err != nil {
+			utils.Logger.Fatal().Err(err).Msg("failed to parse WSRPCUrl")
+		}
+	}
+
 	if Config.MetricsConfig.Enabled {
 		metrics.InitMetrics()
 		processor.MetricsServer = metricsserver.New(&Config.MetricsConfig)
 	}
 
 	config := rpc.Config{
-		BackendURL:           backendURL,
-		HTTPListenAddress:    Config.HTTPListenAddress,
-		DelayInSeconds:       Config.DelayInSeconds,
-		EncryptedGasLimit:    Config.EncryptedGasLimit,
-		WaitMinedInterval:    Config.WaitMinedInterval,
-		FetchBalanceDelay:    Config.FetchBalanceDelay,
-		GasMultiplier:        big.NewInt(int64(Config.GasPriceMultiplier)),
-		EffectivePriorityFee: Config.EffectivePriorityFee,
+		BackendURL:            backendURL,
+		Upstreams:             upstreams,
+		HTTPListenAddress:     Config.HTTPListenAddress,
+		MaxRequestBodySize:    Config.MaxRequestBodySize,
+		MaxBatchSize:          Config.MaxBatchSize,
+		ShutdownTimeout:       Config.ShutdownTimeout,
+		TLSCertFile:           Config.TLSCertFile,
+		TLSKeyFile:            Config.TLSKeyFile,
+		TLSClientCAFile:       Config.TLSClientCAFile,
+		TLSReloadInterval:     Config.TLSReloadInterval,
+		WSBackendURL:          wsBackendURL,
+		WSListenAddress:       Config.WSListenAddress,
+		RoutingConfigPath:     Config.RoutingConfig,
+		CORSConfigPath:        Config.CORSConfig,
+		ResponseCacheTTLs:     Config.ResponseCacheTTLs,
+		ResponseCacheSize:     Config.ResponseCacheSize,
+		FinalityDepth:         Config.FinalityDepth,
+		HeadPollInterval:      Config.HeadPollInterval,
+		APIKeysEnabled:        Config.APIKeysEnabled || Config.APIKeyRequired,
+		APIKeyRequired:        Config.APIKeyRequired,
+		APIKeyRefreshInterval: Config.APIKeyRefreshInterval,
+		IPRateLimit:           Config.IPRateLimit,
+		IPRateBurst:           Config.IPRateBurst,
+		SenderRateLimit:       Config.SenderRateLimit,
+		SenderRateBurst:       Config.SenderRateBurst,
+		TrustProxyHeaders:     Config.TrustProxyHeaders,
+		DelayInSeconds:        Config.DelayInSeconds,
+		EncryptedGasLimit:     Config.EncryptedGasLimit,
+		WaitMinedInterval:     Config.WaitMinedInterval,
+		FetchBalanceDelay:     Config.FetchBalanceDelay,
+		EonRefreshInterval:    Config.EonRefreshInterval,
+		EonKeyAlertBlocks:     Config.EonKeyAlertBlocks,
+		NonceResyncInterval:   Config.NonceResyncInterval,
+		MinSignerBalance:      toWei(Config.MinSignerBalance),
+		GasMultiplier:         big.NewInt(int64(Config.GasPriceMultiplier)),
+		EffectivePriorityFee:  Config.EffectivePriorityFee,
 	}
 
 	service := server.NewRPCService(processor, config, dbInst)
 	utils.Logger.Info().Str("listen-on", Config.HTTPListenAddress).Msg("Serving JSON-RPC")
+	if Config.WSListenAddress != "" {
+		utils.Logger.Info().Str("listen-on", Config.WSListenAddress).Msg("Serving JSON-RPC over WebSocket")
+	}
 
 	func() {
 		err = medleyService.Run(ctx, service)
@@ -297,6 +840,12 @@ func Start() error {
 	return err
 }
 
+// toWei converts an amount of native tokens to wei.
+func toWei(amount float64) *big.Int {
+	wei, _ := new(big.Float).Mul(big.NewFloat(amount), big.NewFloat(1e18)).Int(nil)
+	return wei
+}
+
 func main() {
 	status := 0
 
@@ -47,6 +47,102 @@ var UpstreamRequestDuration = prometheus.NewHistogramVec(
 	[]string{"method"},
 )
 
+var ResponseCacheHits = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "encrypting_rpc_server",
+		Subsystem: "upstream_request",
+		Name:      "cache_hits_total",
+		Help:      "Counter of upstream requests served from the response cache",
+	},
+	[]string{"method"},
+)
+
+var ResponseCacheMisses = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "encrypting_rpc_server",
+		Subsystem: "upstream_request",
+		Name:      "cache_misses_total",
+		Help:      "Counter of cacheable upstream requests not found in the response cache",
+	},
+	[]string{"method"},
+)
+
+var UpstreamHealthy = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Namespace: "encrypting_rpc_server",
+		Subsystem: "upstream",
+		Name:      "healthy",
+		Help:      "Health of the upstream, 1 if healthy and 0 otherwise",
+	},
+	[]string{"upstream"},
+)
+
+var UpstreamBlockLag = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Namespace: "encrypting_rpc_server",
+		Subsystem: "upstream",
+		Name:      "block_lag",
+		Help:      "Number of blocks the upstream is behind the highest known block",
+	},
+	[]string{"upstream"},
+)
+
+var UpstreamErrors = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "encrypting_rpc_server",
+		Subsystem: "upstream",
+		Name:      "errors_total",
+		Help:      "Counter of failed requests and health checks per upstream",
+	},
+	[]string{"upstream"},
+)
+
+var RateLimitedRequests = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "encrypting_rpc_server",
+		Subsystem: "request",
+		Name:      "rate_limited_total",
+		Help:      "Counter of requests rejected by a rate limiter",
+	},
+	[]string{"limiter"},
+)
+
+var EonKeyCacheHits = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Namespace: "encrypting_rpc_server",
+		Subsystem: "eon_key_cache",
+		Name:      "hits_total",
+		Help:      "Counter of eon and eon key lookups served from the cache",
+	},
+)
+
+var EonKeyCacheMisses = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Namespace: "encrypting_rpc_server",
+		Subsystem: "eon_key_cache",
+		Name:      "misses_total",
+		Help:      "Counter of eon and eon key lookups which needed contract calls",
+	},
+)
+
+var EonKeyCacheRefreshes = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Namespace: "encrypting_rpc_server",
+		Subsystem: "eon_key_cache",
+		Name:      "refreshes_total",
+		Help:      "Counter of refreshes of the eon key cache after new keyper sets were detected",
+	},
+)
+
+var MissingUpcomingEonKeys = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Namespace: "encrypting_rpc_server",
+		Subsystem: "eon_key_cache",
+		Name:      "missing_upcoming_keys",
+		Help:      "Number of eons close to activation whose eon key has not been broadcast",
+	},
+)
+
 var CancellationTxGauge = prometheus.NewGauge(
 	prometheus.GaugeOpts{
 		Namespace: "encrypting_rpc_server",
@@ -74,12 +170,65 @@ var ERPCBalance = prometheus.NewGauge(
 	},
 )
 
+var SignerBalance = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Namespace: "encrypting_rpc_server",
+		Subsystem: "balance",
+		Name:      "signer_balance_xdai",
+		Help:      "Native token balance of each signing address",
+	},
+	[]string{"address"},
+)
+
+var SubmissionReplacements = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Namespace: "encrypting_rpc_server",
+		Subsystem: "signer",
+		Name:      "submission_replacements_total",
+		Help:      "Counter of sequencer transactions sent again with higher fees because they were not mined in time",
+	},
+)
+
+var SpendingBudgetRemaining = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Namespace: "encrypting_rpc_server",
+		Subsystem: "signer",
+		Name:      "spending_budget_remaining_xdai",
+		Help:      "Native tokens left of the hourly and daily spending limits of the signing accounts",
+	},
+	[]string{"period"},
+)
+
+var SignerPendingTransactions = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Namespace: "encrypting_rpc_server",
+		Subsystem: "signer",
+		Name:      "pending_transactions",
+		Help:      "Transactions of each signing address which are not mined yet",
+	},
+	[]string{"address"},
+)
+
 func InitMetrics() {
 	prometheus.MustRegister(TotalRequestDuration)
 	prometheus.MustRegister(EncryptionDuration)
 	prometheus.MustRegister(RequestedGasLimit)
 	prometheus.MustRegister(UpstreamRequestDuration)
+	prometheus.MustRegister(ResponseCacheHits)
+	prometheus.MustRegister(ResponseCacheMisses)
+	prometheus.MustRegister(UpstreamHealthy)
+	prometheus.MustRegister(UpstreamBlockLag)
+	prometheus.MustRegister(UpstreamErrors)
+	prometheus.MustRegister(RateLimitedRequests)
+	prometheus.MustRegister(EonKeyCacheHits)
+	prometheus.MustRegister(EonKeyCacheMisses)
+	prometheus.MustRegister(EonKeyCacheRefreshes)
+	prometheus.MustRegister(MissingUpcomingEonKeys)
 	prometheus.MustRegister(CancellationTxGauge)
 	prometheus.MustRegister(ErrorReturnedGauge)
 	prometheus.MustRegister(ERPCBalance)
+	prometheus.MustRegister(SignerBalance)
+	prometheus.MustRegister(SignerPendingTransactions)
+	prometheus.MustRegister(SubmissionReplacements)
+	prometheus.MustRegister(SpendingBudgetRemaining)
 }
@@ -0,0 +1,83 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+type bucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// KeyedLimiter keeps a token bucket per key. Buckets which have not been used for a while are
+// removed by Cleanup.
+type KeyedLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+func NewKeyedLimiter() *KeyedLimiter {
+	return &KeyedLimiter{buckets: make(map[string]*bucket)}
+}
+
+// Allow takes a token from the bucket of key. The bucket is refilled with perSecond tokens per
+// second up to burst tokens. A rate of zero or less means unlimited.
+func (l *KeyedLimiter) Allow(key string, perSecond float64, burst int) bool {
+	if perSecond <= 0 {
+		return true
+	}
+	if burst < 1 {
+		burst = 1
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{limiter: rate.NewLimiter(rate.Limit(perSecond), burst)}
+		l.buckets[key] = b
+	} else if b.limiter.Limit() != rate.Limit(perSecond) || b.limiter.Burst() != burst {
+		b.limiter.SetLimit(rate.Limit(perSecond))
+		b.limiter.SetBurst(burst)
+	}
+	b.lastSeen = time.Now()
+	return b.limiter.Allow()
+}
+
+// Cleanup removes the buckets which have not been used for longer than idle.
+func (l *KeyedLimiter) Cleanup(idle time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for key, b := range l.buckets {
+		if time.Since(b.lastSeen) > idle {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// RunCleanup periodically removes the buckets which have not been used for longer than idle.
+func (l *KeyedLimiter) RunCleanup(ctx context.Context, idle time.Duration) {
+	timer := time.NewTicker(idle)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			l.Cleanup(idle)
+		}
+	}
+}
+
+// Size returns the number of buckets currently kept.
+func (l *KeyedLimiter) Size() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.buckets)
+}
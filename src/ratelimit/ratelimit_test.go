@@ -0,0 +1,37 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyedLimiter_Allow(t *testing.T) {
+	l := NewKeyedLimiter()
+
+	assert.True(t, l.Allow("a", 1, 2))
+	assert.True(t, l.Allow("a", 1, 2))
+	assert.False(t, l.Allow("a", 1, 2), "burst should be used up")
+	assert.True(t, l.Allow("b", 1, 2), "keys should have separate buckets")
+}
+
+func TestKeyedLimiter_Unlimited(t *testing.T) {
+	l := NewKeyedLimiter()
+
+	for i := 0; i < 100; i++ {
+		assert.True(t, l.Allow("a", 0, 0))
+	}
+	assert.Equal(t, 0, l.Size())
+}
+
+func TestKeyedLimiter_Cleanup(t *testing.T) {
+	l := NewKeyedLimiter()
+
+	l.Allow("a", 1, 1)
+	l.Cleanup(time.Hour)
+	assert.Equal(t, 1, l.Size())
+
+	l.Cleanup(0)
+	assert.Equal(t, 0, l.Size())
+}
@@ -0,0 +1,192 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/shutter-network/encrypting-rpc-server/metrics"
+	"github.com/shutter-network/encrypting-rpc-server/utils"
+	"github.com/shutter-network/shutter/shlib/shcrypto"
+)
+
+// errEonKeyNotBroadcast is returned for eons whose keyper set has not broadcast the eon key yet.
+var errEonKeyNotBroadcast = errors.New("eon key has not been broadcast")
+
+type cachedEon struct {
+	activationBlock uint64
+	key             *shcrypto.EonPublicKey
+}
+
+// EonKeyCache keeps the activation blocks of the keyper sets and the parsed eon keys, so that
+// transactions can be encrypted without contract calls. Keyper sets are only ever appended, the
+// eon of a block is the last keyper set activated at or before it. New keyper sets are detected by
+// Refresh, which compares the number of keyper sets with the cached ones.
+type EonKeyCache struct {
+	keyperSetManager KeyperSetManagerContract
+	keyBroadcast     KeyBroadcastContract
+
+	mu   sync.RWMutex
+	eons []cachedEon // indexed by eon
+}
+
+func NewEonKeyCache(keyperSetManager KeyperSetManagerContract, keyBroadcast KeyBroadcastContract) *EonKeyCache {
+	return &EonKeyCache{
+		keyperSetManager: keyperSetManager,
+		keyBroadcast:     keyBroadcast,
+	}
+}
+
+// Refresh loads the activation blocks of keyper sets added since the last refresh.
+func (c *EonKeyCache) Refresh(ctx context.Context) error {
+	opts := &bind.CallOpts{Context: ctx}
+	numKeyperSets, err := c.keyperSetManager.GetNumKeyperSets(opts)
+	if err != nil {
+		return err
+	}
+
+	c.mu.RLock()
+	known := uint64(len(c.eons))
+	c.mu.RUnlock()
+	if numKeyperSets <= known {
+		return nil
+	}
+
+	added := make([]cachedEon, 0, numKeyperSets-known)
+	for eon := known; eon < numKeyperSets; eon++ {
+		activationBlock, err := c.keyperSetManager.GetKeyperSetActivationBlock(opts, eon)
+		if err != nil {
+			return err
+		}
+		added = append(added, cachedEon{activationBlock: activationBlock})
+	}
+
+	c.mu.Lock()
+	// a concurrent refresh might have added them already
+	if uint64(len(c.eons)) == known {
+		c.eons = append(c.eons, added...)
+	}
+	c.mu.Unlock()
+
+	metrics.EonKeyCacheRefreshes.Inc()
+	utils.Logger.Info().Uint64("keyper-sets", numKeyperSets).Msg("Refreshed eon key cache")
+	return nil
+}
+
+// lookupEon returns the eon active at blockNumber.
+func (c *EonKeyCache) lookupEon(blockNumber uint64) (uint64, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	next := sort.Search(len(c.eons), func(i int) bool {
+		return c.eons[i].activationBlock > blockNumber
+	})
+	if next == 0 {
+		return 0, false
+	}
+	return uint64(next - 1), true
+}
+
+// Eon returns the eon active at blockNumber. The cache is refreshed if no keyper set is known to be
+// active at that block yet.
+func (c *EonKeyCache) Eon(ctx context.Context, blockNumber uint64) (uint64, error) {
+	if eon, ok := c.lookupEon(blockNumber); ok {
+		metrics.EonKeyCacheHits.Inc()
+		return eon, nil
+	}
+	metrics.EonKeyCacheMisses.Inc()
+	if err := c.Refresh(ctx); err != nil {
+		return 0, err
+	}
+	if eon, ok := c.lookupEon(blockNumber); ok {
+		return eon, nil
+	}
+	return 0, fmt.Errorf("no keyper set is active at block %d", blockNumber)
+}
+
+// ActivationBlock returns the activation block of eon. The cache is refreshed if the keyper set is
+// not known yet, found is false if it does not exist.
+func (c *EonKeyCache) ActivationBlock(ctx context.Context, eon uint64) (activationBlock uint64, found bool, err error) {
+	if activationBlock, found := c.lookupActivationBlock(eon); found {
+		metrics.EonKeyCacheHits.Inc()
+		return activationBlock, true, nil
+	}
+	metrics.EonKeyCacheMisses.Inc()
+	if err := c.Refresh(ctx); err != nil {
+		return 0, false, err
+	}
+	activationBlock, found = c.lookupActivationBlock(eon)
+	return activationBlock, found, nil
+}
+
+func (c *EonKeyCache) lookupActivationBlock(eon uint64) (uint64, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if eon >= uint64(len(c.eons)) {
+		return 0, false
+	}
+	return c.eons[eon].activationBlock, true
+}
+
+// Key returns the eon key of eon, fetching it from the key broadcast contract if it is not cached
+// yet.
+func (c *EonKeyCache) Key(ctx context.Context, eon uint64) (*shcrypto.EonPublicKey, error) {
+	c.mu.RLock()
+	var key *shcrypto.EonPublicKey
+	if eon < uint64(len(c.eons)) {
+		key = c.eons[eon].key
+	}
+	c.mu.RUnlock()
+	if key != nil {
+		metrics.EonKeyCacheHits.Inc()
+		return key, nil
+	}
+
+	metrics.EonKeyCacheMisses.Inc()
+	return c.fetchKey(ctx, eon)
+}
+
+// fetchKey loads the eon key of eon from the key broadcast contract and caches it.
+func (c *EonKeyCache) fetchKey(ctx context.Context, eon uint64) (*shcrypto.EonPublicKey, error) {
+	eonKeyBytes, err := c.keyBroadcast.GetEonKey(&bind.CallOpts{Context: ctx}, eon)
+	if err != nil {
+		return nil, err
+	}
+	if len(eonKeyBytes) == 0 {
+		return nil, fmt.Errorf("%w for eon %d", errEonKeyNotBroadcast, eon)
+	}
+	key := &shcrypto.EonPublicKey{}
+	if err := key.Unmarshal(eonKeyBytes); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	if eon < uint64(len(c.eons)) {
+		c.eons[eon].key = key
+	}
+	c.mu.Unlock()
+	return key, nil
+}
+
+type upcomingEon struct {
+	eon             uint64
+	activationBlock uint64
+	hasKey          bool
+}
+
+// upcoming returns the eon active at blockNumber and all eons activated after it.
+func (c *EonKeyCache) upcoming(blockNumber uint64) []upcomingEon {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var eons []upcomingEon
+	for eon, cached := range c.eons {
+		next := eon + 1
+		if next < len(c.eons) && c.eons[next].activationBlock <= blockNumber {
+			continue
+		}
+		eons = append(eons, upcomingEon{eon: uint64(eon), activationBlock: cached.activationBlock, hasKey: cached.key != nil})
+	}
+	return eons
+}
@@ -0,0 +1,72 @@
+package rpc_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/shutter-network/encrypting-rpc-server/rpc"
+	"github.com/shutter-network/encrypting-rpc-server/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEonKeyCache(t *testing.T) {
+	ctx := context.Background()
+	keyperSetManager := new(MockKeyperSetManagerContract)
+	keyBroadcast := new(MockKeyBroadcastContract)
+	c := rpc.NewEonKeyCache(keyperSetManager, keyBroadcast)
+
+	keyperSetManager.On("GetNumKeyperSets", mock.Anything).Return(uint64(2), nil).Once()
+	keyperSetManager.On("GetKeyperSetActivationBlock", mock.Anything, uint64(0)).Return(uint64(0), nil).Once()
+	keyperSetManager.On("GetKeyperSetActivationBlock", mock.Anything, uint64(1)).Return(uint64(100), nil).Once()
+	require.NoError(t, c.Refresh(ctx))
+
+	eon, err := c.Eon(ctx, 99)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), eon)
+	eon, err = c.Eon(ctx, 100)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), eon)
+	eon, err = c.Eon(ctx, 5000)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), eon, "the last keyper set stays active")
+
+	keyBroadcast.On("GetEonKey", mock.Anything, uint64(1)).Return(test.TestEonKey.Marshal(), nil).Once()
+	key, err := c.Key(ctx, 1)
+	require.NoError(t, err)
+	assert.True(t, key.Equal(test.TestEonKey))
+	_, err = c.Key(ctx, 1)
+	require.NoError(t, err, "the key should be served from the cache")
+
+	// a new keyper set is only loaded once it is detected
+	keyperSetManager.On("GetNumKeyperSets", mock.Anything).Return(uint64(3), nil).Once()
+	keyperSetManager.On("GetKeyperSetActivationBlock", mock.Anything, uint64(2)).Return(uint64(200), nil).Once()
+	require.NoError(t, c.Refresh(ctx))
+	eon, err = c.Eon(ctx, 5000)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), eon)
+
+	keyBroadcast.On("GetEonKey", mock.Anything, uint64(2)).Return([]byte{}, nil).Once()
+	_, err = c.Key(ctx, 2)
+	assert.ErrorContains(t, err, "has not been broadcast")
+
+	keyperSetManager.AssertExpectations(t)
+	keyBroadcast.AssertExpectations(t)
+}
+
+func TestEonKeyCache_RefreshOnMiss(t *testing.T) {
+	ctx := context.Background()
+	keyperSetManager := new(MockKeyperSetManagerContract)
+	c := rpc.NewEonKeyCache(keyperSetManager, new(MockKeyBroadcastContract))
+
+	keyperSetManager.On("GetNumKeyperSets", mock.Anything).Return(uint64(1), nil).Once()
+	keyperSetManager.On("GetKeyperSetActivationBlock", mock.Anything, uint64(0)).Return(uint64(10), nil).Once()
+	eon, err := c.Eon(ctx, 10)
+	require.NoError(t, err, "the cache should be filled on the first lookup")
+	assert.Equal(t, uint64(0), eon)
+
+	keyperSetManager.On("GetNumKeyperSets", mock.Anything).Return(uint64(1), nil).Once()
+	_, err = c.Eon(ctx, 5)
+	assert.Error(t, err, "there is no keyper set before the first activation block")
+}
@@ -0,0 +1,86 @@
+package rpc
+
+import (
+	"context"
+	"time"
+
+	"github.com/shutter-network/encrypting-rpc-server/metrics"
+	"github.com/shutter-network/encrypting-rpc-server/utils"
+)
+
+// EonWatcher polls the keyper set manager and key broadcast contracts, so that upcoming eons and
+// their keys are in the EonKeyCache before they activate. It warns when an upcoming eon comes
+// within alertBlocks of its activation without a broadcast key.
+type EonWatcher struct {
+	eonKeys     *EonKeyCache
+	client      EthereumClient
+	lookAhead   uint64
+	alertBlocks uint64
+}
+
+func NewEonWatcher(processor Processor, alertBlocks uint64) *EonWatcher {
+	return &EonWatcher{
+		eonKeys:     processor.EonKeys,
+		client:      processor.Client,
+		lookAhead:   uint64(processor.KeyperSetChangeLookAhead),
+		alertBlocks: alertBlocks,
+	}
+}
+
+// Run checks for new eons and keys periodically until ctx is done.
+func (w *EonWatcher) Run(ctx context.Context, interval time.Duration) {
+	w.Check(ctx)
+
+	timer := time.NewTicker(interval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			w.Check(ctx)
+		}
+	}
+}
+
+// Check refreshes the keyper sets and fetches the keys of the current and upcoming eons which are
+// not cached yet.
+func (w *EonWatcher) Check(ctx context.Context) {
+	if err := w.eonKeys.Refresh(ctx); err != nil {
+		utils.Logger.Error().Err(err).Msg("failed to refresh eon key cache")
+		return
+	}
+	blockNumber, err := w.client.BlockNumber(ctx)
+	if err != nil {
+		utils.Logger.Error().Err(err).Msg("failed to get block number for eon watcher")
+		return
+	}
+	// transactions are encrypted for the eon active lookAhead blocks after the latest block
+	encryptionBlock := blockNumber + w.lookAhead
+
+	missing := 0
+	for _, upcoming := range w.eonKeys.upcoming(encryptionBlock) {
+		if upcoming.hasKey {
+			continue
+		}
+		if _, err := w.eonKeys.fetchKey(ctx, upcoming.eon); err == nil {
+			utils.Logger.Info().Uint64("eon", upcoming.eon).Uint64("activation-block", upcoming.activationBlock).Msg("Prefetched eon key")
+			continue
+		}
+
+		var blocksLeft uint64
+		if upcoming.activationBlock > encryptionBlock {
+			blocksLeft = upcoming.activationBlock - encryptionBlock
+		}
+		if blocksLeft <= w.alertBlocks {
+			missing++
+			utils.Logger.Warn().
+				Uint64("eon", upcoming.eon).
+				Uint64("activation-block", upcoming.activationBlock).
+				Uint64("blocks-left", blocksLeft).
+				Msg("eon key has not been broadcast for an eon close to activation")
+		}
+	}
+	metrics.MissingUpcomingEonKeys.Set(float64(missing))
+}
@@ -0,0 +1,51 @@
+package rpc_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/shutter-network/encrypting-rpc-server/metrics"
+	"github.com/shutter-network/encrypting-rpc-server/rpc"
+	"github.com/shutter-network/encrypting-rpc-server/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEonWatcher_Check(t *testing.T) {
+	client := new(MockEthereumClient)
+	keyperSetManager := new(MockKeyperSetManagerContract)
+	keyBroadcast := new(MockKeyBroadcastContract)
+	processor := rpc.Processor{
+		Client:                   client,
+		KeyperSetChangeLookAhead: 5,
+		EonKeys:                  rpc.NewEonKeyCache(keyperSetManager, keyBroadcast),
+	}
+	watcher := rpc.NewEonWatcher(processor, 10)
+
+	client.On("BlockNumber", mock.Anything).Return(uint64(90), nil)
+	keyperSetManager.On("GetNumKeyperSets", mock.Anything).Return(uint64(3), nil)
+	keyperSetManager.On("GetKeyperSetActivationBlock", mock.Anything, uint64(0)).Return(uint64(0), nil).Once()
+	keyperSetManager.On("GetKeyperSetActivationBlock", mock.Anything, uint64(1)).Return(uint64(100), nil).Once()
+	keyperSetManager.On("GetKeyperSetActivationBlock", mock.Anything, uint64(2)).Return(uint64(150), nil).Once()
+	keyBroadcast.On("GetEonKey", mock.Anything, uint64(0)).Return(test.TestEonKey.Marshal(), nil).Once()
+	keyBroadcast.On("GetEonKey", mock.Anything, uint64(1)).Return([]byte{}, nil).Once()
+	keyBroadcast.On("GetEonKey", mock.Anything, uint64(2)).Return([]byte{}, nil).Once()
+
+	// eon 1 activates 5 blocks after the block transactions are encrypted for, eon 2 is far away
+	watcher.Check(context.Background())
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.MissingUpcomingEonKeys))
+
+	key, err := processor.EonKeys.Key(context.Background(), 0)
+	require.NoError(t, err, "the key of the current eon should have been prefetched")
+	assert.True(t, key.Equal(test.TestEonKey))
+
+	keyBroadcast.On("GetEonKey", mock.Anything, uint64(1)).Return(test.TestEonKey.Marshal(), nil).Once()
+	keyBroadcast.On("GetEonKey", mock.Anything, uint64(2)).Return([]byte{}, nil).Once()
+	watcher.Check(context.Background())
+	assert.Equal(t, float64(0), testutil.ToFloat64(metrics.MissingUpcomingEonKeys))
+
+	keyperSetManager.AssertExpectations(t)
+	keyBroadcast.AssertExpectations(t)
+}
@@ -0,0 +1,82 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"math/big"
+)
+
+// minReplacementBump is the fee increase in percent nodes require to replace a transaction in the
+// mempool.
+const minReplacementBump = 10
+
+// FeeStrategy decides the EIP-1559 fees of the transactions the signers submit to the sequencer
+// contract. Nil caps and zero values mean no cap and the defaults.
+type FeeStrategy struct {
+	// MaxTip caps the priority fee per gas suggested by the upstream.
+	MaxTip *big.Int
+	// MaxFee caps the max fee per gas.
+	MaxFee *big.Int
+	// BaseFeeMultiplier is how many times the latest base fee the max fee covers, so that the
+	// transaction stays includable while the base fee rises. Default: 2
+	BaseFeeMultiplier uint64
+	// BumpPercent is the fee increase of replacements. At least 10, the default.
+	BumpPercent uint64
+}
+
+// Fees returns the priority fee and max fee per gas for a new transaction.
+func (f *FeeStrategy) Fees(ctx context.Context, client EthereumClient) (tip *big.Int, feeCap *big.Int, err error) {
+	tip, err = client.SuggestGasTipCap(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	head, err := client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if head.BaseFee == nil {
+		return nil, nil, errors.New("the chain does not support EIP-1559 fees")
+	}
+
+	multiplier := f.BaseFeeMultiplier
+	if multiplier == 0 {
+		multiplier = 2
+	}
+	tip = capFee(tip, f.MaxTip)
+	feeCap = new(big.Int).Mul(head.BaseFee, new(big.Int).SetUint64(multiplier))
+	feeCap = capFee(feeCap.Add(feeCap, tip), f.MaxFee)
+	if tip.Cmp(feeCap) > 0 {
+		tip = new(big.Int).Set(feeCap)
+	}
+	return tip, feeCap, nil
+}
+
+// Bump returns the fees of a replacement for a transaction with tip and feeCap. It returns false
+// if the caps do not leave room for the increase nodes require.
+func (f *FeeStrategy) Bump(tip *big.Int, feeCap *big.Int) (*big.Int, *big.Int, bool) {
+	percent := f.BumpPercent
+	if percent < minReplacementBump {
+		percent = minReplacementBump
+	}
+	newTip := capFee(bumpFee(tip, percent), f.MaxTip)
+	newFeeCap := capFee(bumpFee(feeCap, percent), f.MaxFee)
+	if newTip.Cmp(newFeeCap) > 0 {
+		newTip = new(big.Int).Set(newFeeCap)
+	}
+	ok := newTip.Cmp(bumpFee(tip, minReplacementBump)) >= 0 && newFeeCap.Cmp(bumpFee(feeCap, minReplacementBump)) >= 0
+	return newTip, newFeeCap, ok
+}
+
+// bumpFee increases fee by percent, rounded up.
+func bumpFee(fee *big.Int, percent uint64) *big.Int {
+	bumped := new(big.Int).Mul(fee, new(big.Int).SetUint64(100+percent))
+	bumped.Add(bumped, big.NewInt(99))
+	return bumped.Div(bumped, big.NewInt(100))
+}
+
+func capFee(fee *big.Int, max *big.Int) *big.Int {
+	if max != nil && fee.Cmp(max) > 0 {
+		return new(big.Int).Set(max)
+	}
+	return fee
+}
@@ -0,0 +1,44 @@
+package rpc_test
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/shutter-network/encrypting-rpc-server/rpc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFeeStrategy_Fees(t *testing.T) {
+	client := new(MockEthereumClient)
+	client.On("SuggestGasTipCap", mock.Anything).Return(big.NewInt(3), nil)
+	client.On("HeaderByNumber", mock.Anything, (*big.Int)(nil)).Return(&types.Header{BaseFee: big.NewInt(100)}, nil)
+
+	fees := &rpc.FeeStrategy{}
+	tip, feeCap, err := fees.Fees(context.Background(), client)
+	require.NoError(t, err)
+	assert.Equal(t, big.NewInt(3), tip)
+	assert.Equal(t, big.NewInt(203), feeCap, "twice the base fee plus the tip")
+
+	fees = &rpc.FeeStrategy{MaxTip: big.NewInt(2), MaxFee: big.NewInt(150), BaseFeeMultiplier: 3}
+	tip, feeCap, err = fees.Fees(context.Background(), client)
+	require.NoError(t, err)
+	assert.Equal(t, big.NewInt(2), tip)
+	assert.Equal(t, big.NewInt(150), feeCap)
+}
+
+func TestFeeStrategy_Bump(t *testing.T) {
+	fees := &rpc.FeeStrategy{BumpPercent: 5}
+	tip, feeCap, ok := fees.Bump(big.NewInt(1000), big.NewInt(2001))
+	assert.True(t, ok)
+	assert.Equal(t, big.NewInt(1100), tip, "bumps are at least 10 percent")
+	assert.Equal(t, big.NewInt(2202), feeCap, "bumps are rounded up")
+
+	fees = &rpc.FeeStrategy{BumpPercent: 50, MaxFee: big.NewInt(2100)}
+	_, feeCap, ok = fees.Bump(big.NewInt(1000), big.NewInt(2000))
+	assert.False(t, ok, "the cap leaves no room for a replacement")
+	assert.Equal(t, big.NewInt(2100), feeCap)
+}
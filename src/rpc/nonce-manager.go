@@ -0,0 +1,242 @@
+package rpc
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/shutter-network/encrypting-rpc-server/utils"
+)
+
+// nonceErrors are the errors of the upstream which show that the nonce of a transaction is not the
+// one the node expected, so the local nonce is out of sync with the chain.
+var nonceErrors = []string{
+	"nonce too low",
+	"nonce too high",
+	"already known",
+	"replacement transaction underpriced",
+}
+
+func isNonceError(err error) bool {
+	msg := strings.ToLower(err.Error())
+	for _, nonceError := range nonceErrors {
+		if strings.Contains(msg, nonceError) {
+			return true
+		}
+	}
+	return false
+}
+
+// gapConfirmations is how many syncs in a row have to find the same nonce missing before it is
+// treated as a gap. Upstreams behind a load balancer may report a pending nonce which lags behind.
+const gapConfirmations = 3
+
+// NonceManager hands out the nonces of the signing address, so that concurrent submissions do not
+// race to the same pending nonce. Every nonce returned by Next has to be passed to Done with the
+// result of sending the transaction. Nonces of failed sends are handed out again before new ones,
+// so that no gap blocks the transactions after them. On nonce errors the manager resyncs with the
+// pending nonce of the upstream.
+type NonceManager struct {
+	client  EthereumClient
+	address common.Address
+
+	// syncMu serializes syncs, the upstream is called without holding mu
+	syncMu sync.Mutex
+
+	mu       sync.Mutex
+	synced   bool
+	next     uint64
+	released []uint64 // sorted nonces below next which are not used by a sent transaction
+	inFlight map[uint64]struct{}
+	gap      uint64 // the nonce missing in the last syncs
+	gapSeen  int    // the number of syncs in a row gap has been missing in
+}
+
+func NewNonceManager(client EthereumClient, address common.Address) *NonceManager {
+	return &NonceManager{
+		client:   client,
+		address:  address,
+		inFlight: make(map[uint64]struct{}),
+	}
+}
+
+// Next returns the nonce to use for the next transaction.
+func (m *NonceManager) Next(ctx context.Context) (uint64, error) {
+	if !m.isSynced() {
+		m.syncMu.Lock()
+		// a concurrent call might have synced in the meantime
+		var err error
+		if !m.isSynced() {
+			err = m.sync(ctx)
+		}
+		m.syncMu.Unlock()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var nonce uint64
+	if len(m.released) > 0 {
+		nonce = m.released[0]
+		m.released = m.released[1:]
+	} else {
+		nonce = m.next
+		m.next++
+	}
+	m.inFlight[nonce] = struct{}{}
+	return nonce, nil
+}
+
+func (m *NonceManager) isSynced() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.synced
+}
+
+// Issued returns the number of nonces handed out, which is the next new nonce.
+func (m *NonceManager) Issued() uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.next
+}
+
+// Done marks nonce as used if err is nil. Otherwise the nonce is released to be used by the next
+// transaction, or the manager resyncs with the upstream if err is a nonce error.
+func (m *NonceManager) Done(ctx context.Context, nonce uint64, err error) {
+	m.mu.Lock()
+	delete(m.inFlight, nonce)
+	if err == nil {
+		m.mu.Unlock()
+		return
+	}
+	if !isNonceError(err) {
+		m.release(nonce)
+		m.mu.Unlock()
+		return
+	}
+	m.mu.Unlock()
+
+	utils.Logger.Warn().Err(err).Uint64("nonce", nonce).Msg("Nonce out of sync with upstream, resyncing")
+	if err := m.Resync(ctx); err != nil {
+		// retried on the next call of Next
+		m.mu.Lock()
+		m.synced = false
+		m.mu.Unlock()
+		utils.Logger.Error().Err(err).Msg("Failed to resync nonce")
+	}
+}
+
+// Run resyncs with the upstream every interval until ctx is done, so that gaps left by dropped
+// transactions are detected even if no send fails.
+func (m *NonceManager) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := m.Resync(ctx); err != nil {
+				utils.Logger.Error().Err(err).Msg("Failed to resync nonce")
+			}
+		}
+	}
+}
+
+// Resync compares the local nonce with the pending nonce of the upstream.
+func (m *NonceManager) Resync(ctx context.Context) error {
+	m.syncMu.Lock()
+	defer m.syncMu.Unlock()
+	return m.sync(ctx)
+}
+
+// sync loads the pending nonce of the upstream. Released nonces below it have been used in the
+// meantime. If it is ahead, the address has been used by someone else and the nonces up to it are
+// skipped. If it is behind and the transaction with the pending nonce is not being sent right now,
+// that transaction might have been dropped. The nonce is released to fill the gap once it has been
+// missing for gapConfirmations syncs in a row and the mined nonce confirms that it has not been
+// included. Must be called with syncMu held.
+func (m *NonceManager) sync(ctx context.Context) error {
+	pending, err := m.client.PendingNonceAt(ctx, m.address)
+	if err != nil {
+		return err
+	}
+	gap, suspected := m.update(pending)
+	if !suspected {
+		return nil
+	}
+
+	mined, err := m.client.NonceAt(ctx, m.address, nil)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if mined > gap {
+		// the upstream reported a stale pending nonce
+		m.gapSeen = 0
+		return nil
+	}
+	if _, ok := m.inFlight[gap]; ok || gap != m.gap {
+		return nil
+	}
+	utils.Logger.Warn().Uint64("nonce", gap).Uint64("next", m.next).Msg("Detected nonce gap")
+	m.release(gap)
+	m.gapSeen = 0
+	return nil
+}
+
+// update applies the pending nonce of the upstream. It returns the nonce of a suspected gap, which
+// has been missing for gapConfirmations syncs in a row, and whether there is one.
+func (m *NonceManager) update(pending uint64) (uint64, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	released := m.released[:0]
+	for _, nonce := range m.released {
+		if nonce >= pending {
+			released = append(released, nonce)
+		}
+	}
+	m.released = released
+
+	if !m.synced || pending > m.next {
+		m.next = pending
+	}
+	m.synced = true
+	if pending == m.next {
+		m.gapSeen = 0
+		return 0, false
+	}
+	if _, ok := m.inFlight[pending]; ok || m.isReleased(pending) {
+		m.gapSeen = 0
+		return 0, false
+	}
+	if m.gapSeen > 0 && m.gap == pending {
+		m.gapSeen++
+	} else {
+		m.gap, m.gapSeen = pending, 1
+	}
+	return pending, m.gapSeen >= gapConfirmations
+}
+
+// isReleased must be called with mu held.
+func (m *NonceManager) isReleased(nonce uint64) bool {
+	i := sort.Search(len(m.released), func(i int) bool { return m.released[i] >= nonce })
+	return i < len(m.released) && m.released[i] == nonce
+}
+
+// release adds nonce to the released nonces. Must be called with mu held.
+func (m *NonceManager) release(nonce uint64) {
+	if nonce >= m.next || m.isReleased(nonce) {
+		return
+	}
+	i := sort.Search(len(m.released), func(i int) bool { return m.released[i] >= nonce })
+	m.released = append(m.released, 0)
+	copy(m.released[i+1:], m.released[i:])
+	m.released[i] = nonce
+}
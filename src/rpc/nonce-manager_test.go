@@ -0,0 +1,134 @@
+package rpc_test
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/shutter-network/encrypting-rpc-server/rpc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// gapChecks is the number of syncs a missing nonce has to be seen in to be treated as a gap.
+const gapChecks = 3
+
+func TestNonceManager_Concurrent(t *testing.T) {
+	client := new(MockEthereumClient)
+	client.On("PendingNonceAt", mock.Anything, mock.Anything).Return(uint64(10), nil).Once()
+	nonces := rpc.NewNonceManager(client, common.Address{})
+
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		seen = make(map[uint64]bool)
+	)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			nonce, err := nonces.Next(context.Background())
+			assert.NoError(t, err)
+			nonces.Done(context.Background(), nonce, nil)
+			mu.Lock()
+			defer mu.Unlock()
+			assert.False(t, seen[nonce], "nonce %d handed out twice", nonce)
+			seen[nonce] = true
+		}()
+	}
+	wg.Wait()
+	for nonce := uint64(10); nonce < 60; nonce++ {
+		assert.True(t, seen[nonce], "nonce %d skipped", nonce)
+	}
+	client.AssertExpectations(t)
+}
+
+func TestNonceManager_FailedSend(t *testing.T) {
+	ctx := context.Background()
+	client := new(MockEthereumClient)
+	client.On("PendingNonceAt", mock.Anything, mock.Anything).Return(uint64(0), nil).Once()
+	nonces := rpc.NewNonceManager(client, common.Address{})
+
+	first, err := nonces.Next(ctx)
+	require.NoError(t, err)
+	second, err := nonces.Next(ctx)
+	require.NoError(t, err)
+	nonces.Done(ctx, first, errors.New("insufficient funds for gas * price + value"))
+	nonces.Done(ctx, second, nil)
+
+	nonce, err := nonces.Next(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, first, nonce, "the nonce of the failed send fills the gap")
+	nonce, err = nonces.Next(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), nonce)
+	client.AssertExpectations(t)
+}
+
+func TestNonceManager_Resync(t *testing.T) {
+	ctx := context.Background()
+	client := new(MockEthereumClient)
+	client.On("PendingNonceAt", mock.Anything, mock.Anything).Return(uint64(0), nil).Once()
+	nonces := rpc.NewNonceManager(client, common.Address{})
+
+	nonce, err := nonces.Next(ctx)
+	require.NoError(t, err)
+
+	// the signing key has been used elsewhere
+	client.On("PendingNonceAt", mock.Anything, mock.Anything).Return(uint64(5), nil).Once()
+	nonces.Done(ctx, nonce, errors.New("nonce too low: next nonce 5, tx nonce 0"))
+	nonce, err = nonces.Next(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(5), nonce)
+	nonces.Done(ctx, nonce, nil)
+	for i := 0; i < 3; i++ {
+		nonce, err = nonces.Next(ctx)
+		require.NoError(t, err)
+		nonces.Done(ctx, nonce, nil)
+	}
+
+	// the transaction with nonce 6 has been dropped, which is only trusted after several syncs
+	client.On("PendingNonceAt", mock.Anything, mock.Anything).Return(uint64(6), nil).Times(gapChecks)
+	client.On("NonceAt", mock.Anything, mock.Anything, (*big.Int)(nil)).Return(uint64(6), nil).Once()
+	for i := 0; i < gapChecks; i++ {
+		nonce, err = nonces.Next(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(9), nonce, "the gap should not be filled before it is confirmed")
+		nonces.Done(ctx, nonce, errors.New("insufficient funds for gas * price + value"))
+		require.NoError(t, nonces.Resync(ctx))
+	}
+	nonce, err = nonces.Next(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(6), nonce)
+	nonce, err = nonces.Next(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(9), nonce)
+	client.AssertExpectations(t)
+}
+
+func TestNonceManager_StalePendingNonce(t *testing.T) {
+	ctx := context.Background()
+	client := new(MockEthereumClient)
+	client.On("PendingNonceAt", mock.Anything, mock.Anything).Return(uint64(0), nil).Once()
+	nonces := rpc.NewNonceManager(client, common.Address{})
+	for i := 0; i < 3; i++ {
+		nonce, err := nonces.Next(ctx)
+		require.NoError(t, err)
+		nonces.Done(ctx, nonce, nil)
+	}
+
+	// an upstream which lags behind reports nonce 1 as pending, but it has been mined
+	client.On("PendingNonceAt", mock.Anything, mock.Anything).Return(uint64(1), nil).Times(gapChecks)
+	client.On("NonceAt", mock.Anything, mock.Anything, (*big.Int)(nil)).Return(uint64(2), nil).Once()
+	for i := 0; i < gapChecks; i++ {
+		require.NoError(t, nonces.Resync(ctx))
+	}
+	nonce, err := nonces.Next(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), nonce, "a mined nonce is no gap")
+	client.AssertExpectations(t)
+}
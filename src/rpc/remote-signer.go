@@ -0,0 +1,117 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	txtypes "github.com/ethereum/go-ethereum/core/types"
+	ethrpc "github.com/ethereum/go-ethereum/rpc"
+)
+
+// RemoteSigner signs transactions with eth_signTransaction of an external signer such as Clef or
+// Web3Signer, so that the private key is never held by the server.
+type RemoteSigner struct {
+	client  *ethrpc.Client
+	address common.Address
+}
+
+// NewRemoteSigner connects to the signer at url, which signs for address.
+func NewRemoteSigner(ctx context.Context, url string, address common.Address) (*RemoteSigner, error) {
+	client, err := ethrpc.DialContext(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	return &RemoteSigner{client: client, address: address}, nil
+}
+
+func (s *RemoteSigner) Address() common.Address {
+	return s.address
+}
+
+// signTransactionArgs are the arguments of eth_signTransaction.
+type signTransactionArgs struct {
+	From                 common.Address  `json:"from"`
+	To                   *common.Address `json:"to,omitempty"`
+	Gas                  hexutil.Uint64  `json:"gas"`
+	GasPrice             *hexutil.Big    `json:"gasPrice,omitempty"`
+	MaxFeePerGas         *hexutil.Big    `json:"maxFeePerGas,omitempty"`
+	MaxPriorityFeePerGas *hexutil.Big    `json:"maxPriorityFeePerGas,omitempty"`
+	Value                *hexutil.Big    `json:"value"`
+	Nonce                hexutil.Uint64  `json:"nonce"`
+	Data                 hexutil.Bytes   `json:"data"`
+	ChainID              *hexutil.Big    `json:"chainId"`
+}
+
+// SignTx lets the remote signer sign tx. The result is checked to be tx signed by the address of
+// the signer, so that a misbehaving signer can not submit something else.
+func (s *RemoteSigner) SignTx(ctx context.Context, tx *txtypes.Transaction, chainID *big.Int) (*txtypes.Transaction, error) {
+	args := signTransactionArgs{
+		From:    s.address,
+		To:      tx.To(),
+		Gas:     hexutil.Uint64(tx.Gas()),
+		Value:   (*hexutil.Big)(tx.Value()),
+		Nonce:   hexutil.Uint64(tx.Nonce()),
+		Data:    tx.Data(),
+		ChainID: (*hexutil.Big)(chainID),
+	}
+	if tx.Type() == txtypes.LegacyTxType {
+		args.GasPrice = (*hexutil.Big)(tx.GasPrice())
+	} else {
+		args.MaxFeePerGas = (*hexutil.Big)(tx.GasFeeCap())
+		args.MaxPriorityFeePerGas = (*hexutil.Big)(tx.GasTipCap())
+	}
+
+	var result json.RawMessage
+	if err := s.client.CallContext(ctx, &result, "eth_signTransaction", args); err != nil {
+		return nil, fmt.Errorf("remote signer: %w", err)
+	}
+	raw, err := parseSignTransactionResult(result)
+	if err != nil {
+		return nil, fmt.Errorf("remote signer: %w", err)
+	}
+	signed := new(txtypes.Transaction)
+	if err := signed.UnmarshalBinary(raw); err != nil {
+		return nil, fmt.Errorf("remote signer returned an invalid transaction: %w", err)
+	}
+
+	txSigner := txtypes.LatestSignerForChainID(chainID)
+	sender, err := txtypes.Sender(txSigner, signed)
+	if err != nil {
+		return nil, fmt.Errorf("remote signer returned an invalid signature: %w", err)
+	}
+	if sender != s.address {
+		return nil, fmt.Errorf("remote signer signed with %s instead of %s", sender.Hex(), s.address.Hex())
+	}
+	if txSigner.Hash(signed) != txSigner.Hash(tx) {
+		return nil, fmt.Errorf("remote signer signed a different transaction")
+	}
+	return signed, nil
+}
+
+// parseSignTransactionResult returns the raw transaction of an eth_signTransaction result, which
+// is either the raw transaction itself (Web3Signer) or an object holding it (Clef, geth).
+func parseSignTransactionResult(result json.RawMessage) ([]byte, error) {
+	var raw hexutil.Bytes
+	if err := json.Unmarshal(result, &raw); err == nil {
+		return raw, nil
+	}
+	var object struct {
+		Raw hexutil.Bytes `json:"raw"`
+	}
+	if err := json.Unmarshal(result, &object); err != nil {
+		return nil, fmt.Errorf("unexpected eth_signTransaction result: %w", err)
+	}
+	if len(object.Raw) == 0 {
+		return nil, fmt.Errorf("eth_signTransaction result has no raw transaction")
+	}
+	return object.Raw, nil
+}
+
+// Close closes the connection to the signer.
+func (s *RemoteSigner) Close() {
+	s.client.Close()
+}
@@ -0,0 +1,115 @@
+package rpc_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"math/big"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	ethrpc "github.com/ethereum/go-ethereum/rpc"
+	"github.com/shutter-network/encrypting-rpc-server/rpc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type signTransactionArgs struct {
+	To                   *common.Address `json:"to"`
+	Gas                  hexutil.Uint64  `json:"gas"`
+	MaxFeePerGas         *hexutil.Big    `json:"maxFeePerGas"`
+	MaxPriorityFeePerGas *hexutil.Big    `json:"maxPriorityFeePerGas"`
+	Value                *hexutil.Big    `json:"value"`
+	Nonce                hexutil.Uint64  `json:"nonce"`
+	Data                 hexutil.Bytes   `json:"data"`
+	ChainID              *hexutil.Big    `json:"chainId"`
+}
+
+// standInSigner answers eth_signTransaction like Clef, or like Web3Signer if rawOnly is set.
+type standInSigner struct {
+	key     *ecdsa.PrivateKey
+	rawOnly bool
+}
+
+func (s *standInSigner) SignTransaction(args signTransactionArgs) (interface{}, error) {
+	tx, err := types.SignNewTx(s.key, types.LatestSignerForChainID(args.ChainID.ToInt()), &types.DynamicFeeTx{
+		ChainID:   args.ChainID.ToInt(),
+		Nonce:     uint64(args.Nonce),
+		GasTipCap: args.MaxPriorityFeePerGas.ToInt(),
+		GasFeeCap: args.MaxFeePerGas.ToInt(),
+		Gas:       uint64(args.Gas),
+		To:        args.To,
+		Value:     args.Value.ToInt(),
+		Data:      args.Data,
+	})
+	if err != nil {
+		return nil, err
+	}
+	raw, err := tx.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	if s.rawOnly {
+		return hexutil.Bytes(raw), nil
+	}
+	return map[string]interface{}{"raw": hexutil.Bytes(raw), "tx": tx}, nil
+}
+
+func newRemoteSigner(t *testing.T, standIn *standInSigner, address common.Address) *rpc.RemoteSigner {
+	server := ethrpc.NewServer()
+	require.NoError(t, server.RegisterName("eth", standIn))
+	httpServer := httptest.NewServer(server)
+	t.Cleanup(httpServer.Close)
+
+	signer, err := rpc.NewRemoteSigner(context.Background(), httpServer.URL, address)
+	require.NoError(t, err)
+	t.Cleanup(signer.Close)
+	return signer
+}
+
+func TestRemoteSigner_SignTx(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	address := crypto.PubkeyToAddress(key.PublicKey)
+	chainID := big.NewInt(100)
+	to := common.HexToAddress("0x1234")
+	tx := types.NewTx(&types.DynamicFeeTx{
+		ChainID:   chainID,
+		Nonce:     3,
+		GasTipCap: big.NewInt(1000000000),
+		GasFeeCap: big.NewInt(2000000000),
+		Gas:       100000,
+		To:        &to,
+		Value:     big.NewInt(42),
+		Data:      []byte{1, 2, 3},
+	})
+
+	for _, rawOnly := range []bool{false, true} {
+		signer := newRemoteSigner(t, &standInSigner{key: key, rawOnly: rawOnly}, address)
+		assert.Equal(t, address, signer.Address())
+
+		signed, err := signer.SignTx(context.Background(), tx, chainID)
+		require.NoError(t, err)
+		sender, err := types.Sender(types.LatestSignerForChainID(chainID), signed)
+		require.NoError(t, err)
+		assert.Equal(t, address, sender)
+		assert.Equal(t, tx.Nonce(), signed.Nonce())
+		assert.Equal(t, tx.Data(), signed.Data())
+	}
+}
+
+func TestRemoteSigner_WrongKey(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	otherKey, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	chainID := big.NewInt(100)
+	tx := types.NewTx(&types.DynamicFeeTx{ChainID: chainID, GasTipCap: big.NewInt(1), GasFeeCap: big.NewInt(1), Value: big.NewInt(0)})
+
+	signer := newRemoteSigner(t, &standInSigner{key: otherKey}, crypto.PubkeyToAddress(key.PublicKey))
+	_, err = signer.SignTx(context.Background(), tx, chainID)
+	assert.ErrorContains(t, err, "instead of")
+}
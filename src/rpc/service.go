@@ -5,11 +5,13 @@ import (
 	"crypto/ecdsa"
 	"math/big"
 
+	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/shutter-network/encrypting-rpc-server/db"
+	"github.com/shutter-network/encrypting-rpc-server/upstream"
 	"github.com/shutter-network/rolling-shutter/rolling-shutter/medley/encodeable/url"
 	"github.com/shutter-network/rolling-shutter/rolling-shutter/medley/metricsserver"
 )
@@ -24,20 +26,54 @@ type Processor struct {
 	KeyBroadcastContract     KeyBroadcastContract
 	SequencerContract        SequencerContract
 	KeyperSetManagerContract KeyperSetManagerContract
+	EonKeys                  *EonKeyCache
+	Nonces                   *NonceManager
+	Signers                  *SignerPool
+	Fees                     *FeeStrategy
+	Submissions              *SubmissionMonitor
+	Spending                 *SpendingLimiter
 	Db                       *db.PostgresDb
 	MetricsServer            *metricsserver.MetricsServer
 	MetricsConfig            *metricsserver.MetricsConfig
 }
 
 type Config struct {
-	BackendURL           *url.URL
-	HTTPListenAddress    string
-	DelayInSeconds       int
-	EncryptedGasLimit    uint64
-	WaitMinedInterval    int
-	FetchBalanceDelay    int
-	GasMultiplier        *big.Int
-	EffectivePriorityFee uint64
+	BackendURL            *url.URL
+	Upstreams             *upstream.Pool
+	HTTPListenAddress     string
+	ShutdownTimeout       int
+	MaxRequestBodySize    int64
+	MaxBatchSize          int
+	TLSCertFile           string
+	TLSKeyFile            string
+	TLSClientCAFile       string
+	TLSReloadInterval     int
+	WSBackendURL          *url.URL
+	WSListenAddress       string
+	RoutingConfigPath     string
+	CORSConfigPath        string
+	ResponseCacheTTLs     map[string]int
+	ResponseCacheSize     int
+	FinalityDepth         uint64
+	HeadPollInterval      int
+	APIKeysEnabled        bool
+	APIKeyRequired        bool
+	APIKeyRefreshInterval int
+	IPRateLimit           float64
+	IPRateBurst           int
+	SenderRateLimit       float64
+	SenderRateBurst       int
+	TrustProxyHeaders     bool
+	DelayInSeconds        int
+	EncryptedGasLimit     uint64
+	WaitMinedInterval     int
+	FetchBalanceDelay     int
+	EonRefreshInterval    int
+	EonKeyAlertBlocks     uint64
+	NonceResyncInterval   int
+	MinSignerBalance      *big.Int
+	GasMultiplier         *big.Int
+	EffectivePriorityFee  uint64
 }
 
 type RPCService interface {
@@ -52,6 +88,8 @@ type RPCService interface {
 type EthereumClient interface {
 	PendingNonceAt(ctx context.Context, account common.Address) (uint64, error)
 	SuggestGasPrice(ctx context.Context) (*big.Int, error)
+	SuggestGasTipCap(ctx context.Context) (*big.Int, error)
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
 	ChainID(ctx context.Context) (*big.Int, error)
 	BlockNumber(ctx context.Context) (uint64, error)
 	SendTransaction(ctx context.Context, tx *types.Transaction) error
@@ -60,10 +98,13 @@ type EthereumClient interface {
 	NonceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (uint64, error)
 	BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error)
 	BlockByHash(ctx context.Context, hash common.Hash) (*types.Block, error)
+	SyncProgress(ctx context.Context) (*ethereum.SyncProgress, error)
 }
 
 type KeyperSetManagerContract interface {
 	GetKeyperSetIndexByBlock(opts *bind.CallOpts, blockNumber uint64) (uint64, error)
+	GetKeyperSetActivationBlock(opts *bind.CallOpts, index uint64) (uint64, error)
+	GetNumKeyperSets(opts *bind.CallOpts) (uint64, error)
 }
 
 type KeyBroadcastContract interface {
@@ -86,6 +127,14 @@ func (w *EthClientWrapper) SuggestGasPrice(ctx context.Context) (*big.Int, error
 	return w.Client.SuggestGasPrice(ctx)
 }
 
+func (w *EthClientWrapper) SuggestGasTipCap(ctx context.Context) (*big.Int, error) {
+	return w.Client.SuggestGasTipCap(ctx)
+}
+
+func (w *EthClientWrapper) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	return w.Client.HeaderByNumber(ctx, number)
+}
+
 func (w *EthClientWrapper) ChainID(ctx context.Context) (*big.Int, error) {
 	return w.Client.ChainID(ctx)
 }
@@ -116,4 +165,8 @@ func (w *EthClientWrapper) BalanceAt(ctx context.Context, account common.Address
 
 func (w *EthClientWrapper) BlockByHash(ctx context.Context, hash common.Hash) (*types.Block, error) {
 	return w.Client.BlockByHash(ctx, hash)
-}
\ No newline at end of file
+}
+
+func (w *EthClientWrapper) SyncProgress(ctx context.Context) (*ethereum.SyncProgress, error) {
+	return w.Client.SyncProgress(ctx)
+}
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"math/big"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/ethereum/go-ethereum"
@@ -15,10 +16,10 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	txtypes "github.com/ethereum/go-ethereum/core/types"
-	"github.com/ethereum/go-ethereum/rpc"
 	"github.com/shutter-network/encrypting-rpc-server/cache"
 	"github.com/shutter-network/encrypting-rpc-server/db"
 	"github.com/shutter-network/encrypting-rpc-server/metrics"
+	"github.com/shutter-network/encrypting-rpc-server/ratelimit"
 	"github.com/shutter-network/encrypting-rpc-server/utils"
 	"github.com/shutter-network/rolling-shutter/rolling-shutter/medley/identitypreimage"
 	"github.com/shutter-network/shutter/shlib/shcrypto"
@@ -47,13 +48,21 @@ type EthService struct {
 	Processor          Processor
 	Config             Config
 	Cache              *cache.Cache
+	SenderLimiter      *ratelimit.KeyedLimiter
 	ProcessTransaction func(tx *txtypes.Transaction, ctx context.Context, service *EthService, blockNumber uint64, b []byte) (*txtypes.Transaction, error)
+
+	// stopTracking is closed on shutdown to stop waiting for receipts, tracking counts the
+	// goroutines which are waiting
+	stopTracking chan struct{}
+	tracking     sync.WaitGroup
 }
 
 func (s *EthService) Init(processor Processor, config Config) {
 	s.Processor = processor
 	s.Config = config
 	s.Cache = cache.NewCache(int64(config.DelayInSeconds))
+	s.SenderLimiter = ratelimit.NewKeyedLimiter()
+	s.stopTracking = make(chan struct{})
 }
 
 func (s *EthService) Name() string {
@@ -81,42 +90,58 @@ func (s *EthService) SendTimeEvents(ctx context.Context, delayInSeconds int) {
 
 func (s *EthService) NewTimeEvent(ctx context.Context, newTime int64) {
 	utils.Logger.Info().Msg(fmt.Sprintf("Received new time event: %d", newTime))
-	for key, info := range s.Cache.Data {
-		if info.CachedTime+s.Cache.DelayFactor <= newTime {
-			utils.Logger.Debug().Msgf("Deleting entry at key [%s]", key)
-			delete(s.Cache.Data, key)
-
-			if info.Delayed {
-				utils.Logger.Debug().Msgf("Sending transaction [%s]", info.Tx.Hash().Hex())
-				rawTxBytes, err := info.Tx.MarshalBinary()
-				if err != nil {
-					utils.Logger.Error().Err(err).Msg("Failed to marshal data")
-				}
+	s.sendDelayed(ctx, s.Cache.TakeExpired(newTime))
+}
 
-				rawTx := "0x" + common.Bytes2Hex(rawTxBytes)
-				txHash, err := s.SendRawTransaction(ctx, rawTx)
+// flushDelayed sends all delayed transactions right away instead of waiting for their delay to
+// pass.
+func (s *EthService) flushDelayed(ctx context.Context) {
+	delayed := s.Cache.TakeDelayed()
+	if len(delayed) > 0 {
+		utils.Logger.Info().Int("count", len(delayed)).Msg("Flushing delayed transactions")
+	}
+	s.sendDelayed(ctx, delayed)
+}
 
-				if err != nil {
-					metrics.ErrorReturnedGauge.Dec()
-					utils.Logger.Error().Err(err).Msgf("Failed to send transaction.")
-					continue
-				}
+func (s *EthService) sendDelayed(ctx context.Context, delayed []cache.TransactionInfo) {
+	for _, info := range delayed {
+		utils.Logger.Debug().Msgf("Sending transaction [%s]", info.Tx.Hash().Hex())
+		rawTxBytes, err := info.Tx.MarshalBinary()
+		if err != nil {
+			utils.Logger.Error().Err(err).Msg("Failed to marshal data")
+		}
 
-				utils.Logger.Info().Msg("Transaction sent internally: " + txHash.Hex())
-			}
+		rawTx := "0x" + common.Bytes2Hex(rawTxBytes)
+		sendCtx := utils.WithRequestID(utils.WithAPIKeyID(ctx, info.APIKeyID), info.RequestID)
+		txHash, err := s.SendRawTransaction(utils.WithInternal(sendCtx), rawTx)
+
+		if err != nil {
+			metrics.ErrorReturnedGauge.Dec()
+			utils.Logger.Error().Err(err).Msgf("Failed to send transaction.")
+			continue
 		}
+
+		utils.Logger.Info().Msg("Transaction sent internally: " + txHash.Hex())
 	}
 }
 
 func (srv *EthService) GasPrice(ctx context.Context) (string, error) {
-	gasPrice, err := srv.Processor.Client.SuggestGasPrice(ctx)
+	adjustedGasPrice, err := srv.adjustedGasPrice(ctx)
 	if err != nil {
 		return "", err
 	}
-	adjustedGasPrice := new(big.Int).Mul(gasPrice, srv.Config.GasMultiplier)
 	return hexutil.EncodeBig(adjustedGasPrice), nil
 }
 
+// adjustedGasPrice is the suggested gas price of the upstream times GasMultiplier.
+func (srv *EthService) adjustedGasPrice(ctx context.Context) (*big.Int, error) {
+	gasPrice, err := srv.Processor.Client.SuggestGasPrice(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).Mul(gasPrice, srv.Config.GasMultiplier), nil
+}
+
 func (service *EthService) SendTransaction(ctx context.Context, tx *txtypes.Transaction) (*common.Hash, error) {
 	ts := txtypes.Transactions{tx}
 	buf := new(bytes.Buffer)
@@ -128,15 +153,11 @@ func (service *EthService) SendTransaction(ctx context.Context, tx *txtypes.Tran
 
 func (service *EthService) SendRawTransaction(ctx context.Context, s string) (*common.Hash, error) {
 	timeBefore := time.Now()
+	logger := utils.ContextLogger(ctx)
 	if service.ProcessTransaction == nil {
 		service.ProcessTransaction = DefaultProcessTransaction
 	}
 
-	blockNumber, err := service.Processor.Client.BlockNumber(ctx)
-	if err != nil {
-		return nil, returnError(-32602, err)
-	}
-
 	b, err := hexutil.Decode(s)
 	if err != nil {
 		return nil, returnError(-32602, err)
@@ -153,61 +174,33 @@ func (service *EthService) SendRawTransaction(ctx context.Context, s string) (*c
 		return nil, returnError(-32602, err)
 	}
 
-	accountNonce, err := service.Processor.Client.NonceAt(ctx, fromAddress, nil)
-	if err != nil {
-		return nil, returnError(-32602, err)
-	}
-
-	if accountNonce > tx.Nonce() {
-		return nil, returnError(-32000, errors.New("nonce is not correct"))
+	// checked before any upstream lookup so that a flooding sender does not cost upstream requests
+	if !service.allowSender(ctx, fromAddress) {
+		metrics.RateLimitedRequests.WithLabelValues("sender").Inc()
+		logger.Info().Str("sender", fromAddress.Hex()).Msg("sender rate limit exceeded")
+		return nil, returnError(-32005, errors.New("sender rate limit exceeded"))
 	}
 
-	accountBalance, err := service.Processor.Client.BalanceAt(ctx, fromAddress, nil)
+	blockNumber, err := service.Processor.Client.BlockNumber(ctx)
 	if err != nil {
 		return nil, returnError(-32602, err)
 	}
 
-	if accountBalance.Cmp(tx.Cost()) == -1 {
-		return nil, returnError(-32000, errors.New("gas cost is higher"))
-	}
-
-	intrinsicGas, err := CalculateIntrinsicGas(tx)
-	if err != nil {
-		return nil, returnError(-32602, errors.New("error calculating the intrinsic gas: "+err.Error()))
-	}
-
-	if tx.Gas() < intrinsicGas {
-		return nil, returnError(-32602, errors.New("gas limit below the intrinsic gas limit "+
-			""+strconv.FormatUint(intrinsicGas, 10)))
-	}
-
-	if tx.Gas() > service.Config.EncryptedGasLimit {
-		return nil, returnError(-32000, errors.New("gas limit exceeds encrypted gas limit "+
-			"(max gas limit allowed per shutterized block)"))
-	}
-
-	if tx.GasTipCap().Uint64() < service.Config.EffectivePriorityFee {
-		return nil, returnError(-32602, errors.New("priority fees too low "+
-			""+tx.GasTipCap().String()))
+	if err := service.validateTransaction(ctx, tx, fromAddress); err != nil {
+		return nil, err
 	}
 
 	if utils.IsCancellationTransaction(tx, fromAddress) {
-		utils.Logger.Info().Msg("Detected cancellation transaction, forwarding to backend")
-
-		backendClient, err := rpc.Dial(service.Config.BackendURL.String())
-		if err != nil {
-			utils.Logger.Err(err).Msg("Failed to connect to backend")
-			return nil, returnError(-32603, err)
-		}
+		logger.Info().Msg("Detected cancellation transaction, forwarding to backend")
 
-		err = backendClient.CallContext(ctx, &txHash, "eth_sendRawTransaction", s)
+		err = service.Processor.Client.SendTransaction(ctx, tx)
 		if err != nil {
-			utils.Logger.Err(err).Msg("Failed to send cancel transaction to backend")
+			logger.Err(err).Msg("Failed to send cancel transaction to backend")
 			return nil, returnError(-32602, err)
 		}
 
 		metrics.CancellationTxGauge.Inc()
-		utils.Logger.Info().Msg("Transaction forwarded with hash: " + txHash.Hex())
+		logger.Info().Msg("Transaction forwarded with hash: " + txHash.Hex())
 
 		service.Processor.Db.InsertNewTx(db.TransactionDetails{
 			Address:        fromAddress.String(),
@@ -215,26 +208,33 @@ func (service *EthService) SendRawTransaction(ctx context.Context, s string) (*c
 			TxHash:         txHash.String(),
 			SubmissionTime: time.Now().Unix(),
 			IsCancellation: true,
+			APIKeyID:       utils.APIKeyID(ctx),
+			RequestID:      utils.RequestID(ctx),
 		})
 
-		_ctx, cancelFunc := context.WithTimeout(context.Background(), time.Duration(service.Config.WaitMinedInterval)*10*time.Second)
-		go service.WaitTillMined(_ctx, cancelFunc, tx, service.Config.WaitMinedInterval)
+		service.trackReceipt(txHash)
 		return &txHash, nil
 	}
 
 	statuses, err := service.Cache.ProcessTxEntry(tx, time.Now().Unix())
 	if err != nil {
-		utils.Logger.Err(err).Msg("Failed to update the cache.")
+		logger.Err(err).Msg("Failed to update the cache.")
 		return nil, returnError(-32603, err)
 	}
 
 	if !statuses.SendStatus {
-		utils.Logger.Info().Hex("Tx hash", txHash.Bytes()).Msg("Transaction delayed")
+		logger.Info().Hex("Tx hash", txHash.Bytes()).Msg("Transaction delayed")
+		// the delayed transaction is sent later on behalf of the same api key and request
+		if cacheKey, err := service.Cache.Key(tx); err == nil {
+			service.Cache.SetRequest(cacheKey, utils.APIKeyID(ctx), utils.RequestID(ctx))
+		}
 		if statuses.UpdateStatus { // this is the same tx, just requested more than once so we do not add it to db
 			service.Processor.Db.InsertNewTx(db.TransactionDetails{
-				Address: fromAddress.String(),
-				Nonce:   tx.Nonce(),
-				TxHash:  txHash.String(),
+				Address:   fromAddress.String(),
+				Nonce:     tx.Nonce(),
+				TxHash:    txHash.String(),
+				APIKeyID:  utils.APIKeyID(ctx),
+				RequestID: utils.RequestID(ctx),
 			})
 		}
 		return &txHash, nil
@@ -242,20 +242,22 @@ func (service *EthService) SendRawTransaction(ctx context.Context, s string) (*c
 
 	submitTx, err := service.ProcessTransaction(tx, ctx, service, blockNumber, b)
 	if err != nil {
-		return nil, returnError(-32603, err)
+		return nil, submissionError(err)
 	}
-	utils.Logger.Info().Hex("Incoming tx hash", txHash.Bytes()).Hex("Encrypted tx hash", submitTx.Hash().Bytes()).Msg("Transaction sent")
+	logger.Info().Hex("Incoming tx hash", txHash.Bytes()).Hex("Encrypted tx hash", submitTx.Hash().Bytes()).Msg("Transaction sent")
 
 	service.Processor.Db.InsertNewTx(db.TransactionDetails{
 		Address:         fromAddress.String(),
 		Nonce:           tx.Nonce(),
 		TxHash:          txHash.String(),
 		EncryptedTxHash: submitTx.Hash().String(),
+		SignerAddress:   submitterAddress(submitTx),
 		SubmissionTime:  time.Now().Unix(),
+		APIKeyID:        utils.APIKeyID(ctx),
+		RequestID:       utils.RequestID(ctx),
 	})
 
-	_ctx, cancelFunc := context.WithTimeout(context.Background(), time.Duration(service.Config.WaitMinedInterval)*10*time.Second)
-	go service.WaitTillMined(_ctx, cancelFunc, tx, service.Config.WaitMinedInterval)
+	service.trackReceipt(txHash)
 
 	metrics.RequestedGasLimit.Observe(float64(tx.Gas()))
 	metrics.TotalRequestDuration.Observe(float64(time.Since(timeBefore).Seconds()))
@@ -263,97 +265,336 @@ func (service *EthService) SendRawTransaction(ctx context.Context, s string) (*c
 	return &txHash, nil
 }
 
+// validateTransaction checks that tx from fromAddress can be included: its nonce is not used yet,
+// the sender can pay for it and its gas fits into the limits for encrypted transactions.
+func (service *EthService) validateTransaction(ctx context.Context, tx *txtypes.Transaction, fromAddress common.Address) error {
+	accountNonce, err := service.Processor.Client.NonceAt(ctx, fromAddress, nil)
+	if err != nil {
+		return returnError(-32602, err)
+	}
+
+	if accountNonce > tx.Nonce() {
+		return returnError(-32000, errors.New("nonce is not correct"))
+	}
+
+	accountBalance, err := service.Processor.Client.BalanceAt(ctx, fromAddress, nil)
+	if err != nil {
+		return returnError(-32602, err)
+	}
+
+	if accountBalance.Cmp(tx.Cost()) == -1 {
+		return returnError(-32000, errors.New("gas cost is higher"))
+	}
+
+	intrinsicGas, err := CalculateIntrinsicGas(tx)
+	if err != nil {
+		return returnError(-32602, errors.New("error calculating the intrinsic gas: "+err.Error()))
+	}
+
+	if tx.Gas() < intrinsicGas {
+		return returnError(-32602, errors.New("gas limit below the intrinsic gas limit "+
+			""+strconv.FormatUint(intrinsicGas, 10)))
+	}
+
+	if tx.Gas() > service.Config.EncryptedGasLimit {
+		return returnError(-32000, errors.New("gas limit exceeds encrypted gas limit "+
+			"(max gas limit allowed per shutterized block)"))
+	}
+
+	if tx.GasTipCap().Uint64() < service.Config.EffectivePriorityFee {
+		return returnError(-32602, errors.New("priority fees too low "+
+			""+tx.GasTipCap().String()))
+	}
+	return nil
+}
+
 var DefaultProcessTransaction = func(tx *txtypes.Transaction, ctx context.Context, service *EthService, blockNumber uint64, b []byte) (*txtypes.Transaction, error) {
-	eon, err := service.Processor.KeyperSetManagerContract.GetKeyperSetIndexByBlock(nil, blockNumber+uint64(service.Processor.KeyperSetChangeLookAhead))
+	logger := utils.ContextLogger(ctx)
+	value := SequencerValue(tx)
+	signer := service.Processor.pickSigner(value)
+	encrypted, err := service.Processor.Encrypt(ctx, b, blockNumber, signer.Address)
 	if err != nil {
 		return nil, &EncodingError{StatusCode: -32602, Err: err}
 	}
 
-	eonKeyBytes, err := service.Processor.KeyBroadcastContract.GetEonKey(nil, eon)
+	submitTx, err := service.Processor.SubmitEncrypted(ctx, signer, encrypted.Eon, encrypted.IdentityPrefix, encrypted.EncryptedTx.Marshal(), value, tx.Gas())
 	if err != nil {
-		return nil, &EncodingError{StatusCode: -32602, Err: err}
+		logger.Err(err).Uint64("eon", encrypted.Eon).Hex("Incoming tx hash", tx.Hash().Bytes()).Msg("Failed to submit encrypted transaction")
+		return nil, err
 	}
+	logger.Debug().Uint64("eon", encrypted.Eon).Hex("Identity prefix", encrypted.IdentityPrefix[:]).Hex("Signer", signer.Address.Bytes()).Hex("Encrypted tx hash", submitTx.Hash().Bytes()).Msg("Encrypted transaction submitted")
 
-	eonKey := &shcrypto.EonPublicKey{}
-	if err := eonKey.Unmarshal(eonKeyBytes); err != nil {
-		return nil, &EncodingError{StatusCode: -32602, Err: err}
+	return submitTx, nil
+}
+
+// pickSigner returns the signer for the next submission of value wei, the signing key if there
+// is no signer pool.
+func (p *Processor) pickSigner(value *big.Int) *Signer {
+	if p.Signers != nil {
+		return p.Signers.Pick(value)
 	}
+	return &Signer{TxSigner: NewKeySigner(p.SigningKey), Address: *p.SigningAddress, Nonces: p.Nonces}
+}
 
-	sigma, err := shcrypto.RandomSigma(cryptorand.Reader)
+// signerByAddress returns the signer of address if it is one of the signing addresses.
+func (p *Processor) signerByAddress(address common.Address) (*Signer, bool) {
+	if p.Signers != nil {
+		return p.Signers.ByAddress(address)
+	}
+	if address != *p.SigningAddress {
+		return nil, false
+	}
+	return &Signer{TxSigner: NewKeySigner(p.SigningKey), Address: *p.SigningAddress, Nonces: p.Nonces}, true
+}
+
+// SigningAddresses returns the addresses encrypted transactions are submitted from, the primary
+// signing address first.
+func (p *Processor) SigningAddresses() []common.Address {
+	if p.Signers != nil {
+		return p.Signers.Addresses()
+	}
+	return []common.Address{*p.SigningAddress}
+}
+
+// SubmitEncrypted sends the encrypted transaction to the sequencer contract, signed by signer.
+// value pays for the gas of the encrypted transaction.
+func (p *Processor) SubmitEncrypted(ctx context.Context, signer *Signer, eon uint64, identityPrefix [32]byte, encryptedTx []byte, value *big.Int, gasLimit uint64) (*txtypes.Transaction, error) {
+	sub, err := p.prepareSubmission(ctx, signer, eon, identityPrefix, encryptedTx, value, gasLimit)
 	if err != nil {
-		return nil, &EncodingError{StatusCode: -32602, Err: err}
+		return nil, err
 	}
+	return p.sendSubmission(ctx, sub)
+}
+
+// submission is a sequencer transaction whose fees are decided and whose cost is reserved, but
+// which has not been sent yet. It has to be passed to sendSubmission or cancelSubmission.
+type submission struct {
+	signer         *Signer
+	opts           bind.TransactOpts
+	eon            uint64
+	identityPrefix [32]byte
+	encryptedTx    []byte
+	gasLimit       uint64
+	reservation    *Spend
+}
 
-	chainId, err := service.Processor.Client.ChainID(ctx)
+// prepareSubmission decides the fees of the sequencer transaction and reserves its cost in the
+// spending budgets.
+func (p *Processor) prepareSubmission(ctx context.Context, signer *Signer, eon uint64, identityPrefix [32]byte, encryptedTx []byte, value *big.Int, gasLimit uint64) (*submission, error) {
+	chainId, err := p.Client.ChainID(ctx)
 	if err != nil {
 		return nil, &EncodingError{StatusCode: -32603, Err: err}
 	}
 
-	newSigner, err := bind.NewKeyedTransactorWithChainID(service.Processor.SigningKey, chainId)
+	sub := &submission{
+		signer: signer,
+		opts: bind.TransactOpts{
+			From: signer.Address,
+			Signer: func(address common.Address, tx *txtypes.Transaction) (*txtypes.Transaction, error) {
+				if address != signer.Address {
+					return nil, bind.ErrNotAuthorized
+				}
+				return signer.TxSigner.SignTx(ctx, tx, chainId)
+			},
+			Value: value,
+		},
+		eon:            eon,
+		identityPrefix: identityPrefix,
+		encryptedTx:    encryptedTx,
+		gasLimit:       gasLimit,
+	}
+	if p.Fees != nil {
+		sub.opts.GasTipCap, sub.opts.GasFeeCap, err = p.Fees.Fees(ctx, p.Client)
+		if err != nil {
+			return nil, &EncodingError{StatusCode: -32603, Err: err}
+		}
+	}
+
+	if p.Spending != nil {
+		cost, err := p.submissionCost(sub)
+		if err != nil {
+			return nil, &EncodingError{StatusCode: -32603, Err: err}
+		}
+		sub.reservation, err = p.Spending.Reserve(value, cost)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return sub, nil
+}
+
+// submissionCost builds the transaction of sub without nonce and signature to learn its gas limit
+// and fees, which are fixed for sending it, and returns its worst-case cost: the value plus the gas
+// limit times the max fee per gas.
+func (p *Processor) submissionCost(sub *submission) (*big.Int, error) {
+	opts := sub.opts
+	opts.Nonce = new(big.Int)
+	opts.NoSend = true
+	opts.Signer = func(_ common.Address, tx *txtypes.Transaction) (*txtypes.Transaction, error) {
+		return tx, nil
+	}
+	tx, err := p.SequencerContract.SubmitEncryptedTransaction(&opts, sub.eon, sub.identityPrefix, sub.encryptedTx, new(big.Int).SetUint64(sub.gasLimit))
 	if err != nil {
-		return nil, &EncodingError{StatusCode: -32602, Err: err}
+		return nil, err
 	}
 
-	timeBefore := time.Now()
+	sub.opts.GasLimit = tx.Gas()
+	if tx.Type() == txtypes.LegacyTxType {
+		sub.opts.GasPrice = tx.GasPrice()
+	} else {
+		sub.opts.GasTipCap, sub.opts.GasFeeCap = tx.GasTipCap(), tx.GasFeeCap()
+	}
+	return tx.Cost(), nil
+}
 
-	identityPrefix, err := shcrypto.RandomSigma(cryptorand.Reader)
+// sendSubmission sends a prepared submission and replaces its reservation with its cost.
+func (p *Processor) sendSubmission(ctx context.Context, sub *submission) (*txtypes.Transaction, error) {
+	submitTx, err := p.submit(ctx, sub.signer, &sub.opts, sub.eon, sub.identityPrefix, sub.encryptedTx, sub.gasLimit)
 	if err != nil {
-		return nil, &EncodingError{StatusCode: -32602, Err: err}
+		p.cancelSubmission(sub)
+		return nil, err
 	}
-	identity := ComputeIdentity(identityPrefix[:], newSigner.From)
-	encryptedTx := shcrypto.Encrypt(b, eonKey, identity, sigma)
+	if sub.reservation != nil {
+		p.Spending.Settle(sub.reservation, submitTx.Cost(), sub.signer.Address, submitTx.Hash())
+	}
+	sub.signer.submitted(sub.opts.Value)
+	if p.Submissions != nil {
+		p.Submissions.Track(sub.signer, submitTx)
+	}
+	return submitTx, nil
+}
+
+// cancelSubmission releases the reservation of a submission which is not sent.
+func (p *Processor) cancelSubmission(sub *submission) {
+	if sub.reservation != nil {
+		p.Spending.Release(sub.reservation)
+	}
+}
+
+// submit sends the transaction to the sequencer contract with the next nonce of signer.
+func (p *Processor) submit(ctx context.Context, signer *Signer, opts *bind.TransactOpts, eon uint64, identityPrefix [32]byte, encryptedTx []byte, gasLimit uint64) (*txtypes.Transaction, error) {
+	if signer.Nonces == nil {
+		return p.SequencerContract.SubmitEncryptedTransaction(opts, eon, identityPrefix, encryptedTx, new(big.Int).SetUint64(gasLimit))
+	}
+	nonce, err := signer.Nonces.Next(ctx)
+	if err != nil {
+		return nil, &EncodingError{StatusCode: -32603, Err: err}
+	}
+	opts.Nonce = new(big.Int).SetUint64(nonce)
+	submitTx, err := p.SequencerContract.SubmitEncryptedTransaction(opts, eon, identityPrefix, encryptedTx, new(big.Int).SetUint64(gasLimit))
+	signer.Nonces.Done(ctx, nonce, err)
+	return submitTx, err
+}
+
+// submitterAddress returns the address which signed the sequencer transaction tx, empty if it
+// cannot be recovered.
+func submitterAddress(tx *txtypes.Transaction) string {
+	sender, err := txtypes.Sender(txtypes.LatestSignerForChainID(tx.ChainId()), tx)
+	if err != nil {
+		return ""
+	}
+	return sender.String()
+}
+
+// EncryptedPayload is what SubmitEncryptedTransaction of the sequencer contract takes, apart from
+// the value and gas limit.
+type EncryptedPayload struct {
+	Eon            uint64
+	IdentityPrefix [32]byte
+	EncryptedTx    *shcrypto.EncryptedMessage
+}
 
-	encryptionDuration := time.Since(timeBefore).Seconds()
+// Encrypt encrypts the signed transaction b for the eon at blockNumber. The identity is derived
+// from a random prefix and sender, the address which submits the payload to the sequencer.
+func (p *Processor) Encrypt(ctx context.Context, b []byte, blockNumber uint64, sender common.Address) (*EncryptedPayload, error) {
+	eon, eonKey, err := p.EonKey(ctx, blockNumber)
+	if err != nil {
+		return nil, err
+	}
 
-	opts := bind.TransactOpts{
-		From:   *service.Processor.SigningAddress,
-		Signer: newSigner.Signer,
+	sigma, err := shcrypto.RandomSigma(cryptorand.Reader)
+	if err != nil {
+		return nil, err
 	}
 
-	opts.Value = big.NewInt(0).Sub(tx.Cost(), tx.Value())
+	timeBefore := time.Now()
 
-	submitTx, err := service.Processor.SequencerContract.SubmitEncryptedTransaction(&opts, eon, identityPrefix, encryptedTx.Marshal(), new(big.Int).SetUint64(tx.Gas()))
+	identityPrefix, err := shcrypto.RandomSigma(cryptorand.Reader)
 	if err != nil {
 		return nil, err
 	}
-	metrics.EncryptionDuration.Observe(float64(encryptionDuration))
+	identity := ComputeIdentity(identityPrefix[:], sender)
+	encryptedTx := shcrypto.Encrypt(b, eonKey, identity, sigma)
 
-	return submitTx, nil
+	metrics.EncryptionDuration.Observe(time.Since(timeBefore).Seconds())
+
+	return &EncryptedPayload{Eon: eon, IdentityPrefix: identityPrefix, EncryptedTx: encryptedTx}, nil
+}
+
+// SequencerValue is the value which has to be sent along with the encrypted transaction to pay
+// for the gas of tx.
+func SequencerValue(tx *txtypes.Transaction) *big.Int {
+	return big.NewInt(0).Sub(tx.Cost(), tx.Value())
 }
 
-func (s *EthService) WaitTillMined(ctx context.Context, cancelFunc context.CancelFunc, tx *txtypes.Transaction, waitMinedInterval int) {
-	key := tx.Hash().String()
-	value := s.Cache.WaitingForReceiptCache[key]
+// trackReceipt waits in the background for the receipt of txHash to record its inclusion time.
+// While waiting, txHash is recorded as tracked in the database.
+func (s *EthService) trackReceipt(txHash common.Hash) {
+	ctx, cancelFunc := context.WithTimeout(context.Background(), time.Duration(s.Config.WaitMinedInterval)*10*time.Second)
+	s.tracking.Add(1)
+	go func() {
+		defer s.tracking.Done()
+		s.WaitTillMined(ctx, cancelFunc, txHash, s.Config.WaitMinedInterval)
+	}()
+}
+
+// ResumeTracking waits again for the receipts which were still tracked when s last stopped, on
+// shutdown or in a crash. Like Shutdown it is not a method, so that the rpc server does not expose
+// it.
+func ResumeTracking(s *EthService) error {
+	txHashes, err := s.Processor.Db.GetTrackedReceipts()
+	if err != nil {
+		return err
+	}
+	for _, txHash := range txHashes {
+		s.trackReceipt(common.HexToHash(txHash))
+	}
+	if len(txHashes) > 0 {
+		utils.Logger.Info().Int("count", len(txHashes)).Msg("Resumed waiting for receipts")
+	}
+	return nil
+}
 
-	if !value {
+func (s *EthService) WaitTillMined(ctx context.Context, cancelFunc context.CancelFunc, txHash common.Hash, waitMinedInterval int) {
+	key := txHash.String()
+
+	if s.Cache.TrackReceipt(key) {
+		if err := s.Processor.Db.SaveTrackedReceipts([]string{key}); err != nil {
+			utils.Logger.Warn().Err(err).Msgf("failed to record tracked receipt | txHash: %s", key)
+		}
 		queryTicker := time.NewTicker(time.Duration(waitMinedInterval) * time.Second)
 		defer queryTicker.Stop()
-		s.Cache.WaitingForReceiptCache[key] = true
-		utils.Logger.Info().Msgf("New tx recorded to check for inclusion | txHash: %s", tx.Hash().String())
+		utils.Logger.Info().Msgf("New tx recorded to check for inclusion | txHash: %s", key)
 		for {
-			if s.Cache.WaitingForReceiptCache[key] {
-				receipt, err := s.Processor.Client.TransactionReceipt(ctx, tx.Hash())
+			if s.Cache.IsTrackingReceipt(key) {
+				receipt, err := s.receipt(ctx, txHash)
 				if err == nil {
-					delete(s.Cache.WaitingForReceiptCache, key)
+					s.untrackReceipt(key)
 					block, err := s.Processor.Client.BlockByHash(ctx, receipt.BlockHash)
 					if err != nil {
 						utils.Logger.Debug().Msgf("Error getting block | blockHash: %s", receipt.BlockHash.String())
-						cancelFunc()
-
+					} else {
+						s.Processor.Db.FinaliseTx(db.TransactionDetails{
+							TxHash:        key,
+							InclusionTime: block.Time(),
+						})
 					}
-
-					s.Processor.Db.FinaliseTx(db.TransactionDetails{
-						TxHash:        tx.Hash().String(),
-						InclusionTime: block.Time(),
-					})
 					cancelFunc()
-				}
-
-				if errors.Is(err, ethereum.NotFound) {
-					utils.Logger.Debug().Msgf("Transaction not yet mined | txHash: %s", tx.Hash().String())
+				} else if errors.Is(err, ethereum.NotFound) {
+					utils.Logger.Debug().Msgf("Transaction not yet mined | txHash: %s", key)
 				} else {
-					delete(s.Cache.WaitingForReceiptCache, key)
-					utils.Logger.Debug().Msgf("receipt retrieval failed | txHash: %s | err: %W", tx.Hash().String(), err)
+					s.untrackReceipt(key)
+					utils.Logger.Debug().Msgf("receipt retrieval failed | txHash: %s | err: %v", key, err)
 					cancelFunc()
 				}
 
@@ -363,16 +604,82 @@ func (s *EthService) WaitTillMined(ctx context.Context, cancelFunc context.Cance
 			}
 			// Wait for the next round.
 			select {
+			case <-s.stopTracking:
+				// the receipt stays tracked so that it is persisted on shutdown
+				cancelFunc()
+				return
 			case <-ctx.Done():
 				// deleting cache here as we have stopped waiting for tx inclusion
-				delete(s.Cache.WaitingForReceiptCache, key)
+				if s.Cache.IsTrackingReceipt(key) {
+					s.untrackReceipt(key)
+				}
 				return
 			case <-queryTicker.C:
 			}
 		}
 	}
+	cancelFunc()
+}
+
+// untrackReceipt stops waiting for the receipt of key and removes it from the tracked receipts in
+// the database.
+func (s *EthService) untrackReceipt(key string) {
+	s.Cache.UntrackReceipt(key)
+	if err := s.Processor.Db.DeleteTrackedReceipt(key); err != nil {
+		utils.Logger.Warn().Err(err).Msgf("failed to remove tracked receipt | txHash: %s", key)
+	}
+}
+
+// receipt returns the receipt of txHash or, if txHash is a sequencer transaction which the
+// submission monitor replaced because it got stuck, the receipt of its latest replacement. The
+// replacements are looked up in the database, so that they are found after a restart as well.
+func (s *EthService) receipt(ctx context.Context, txHash common.Hash) (*txtypes.Receipt, error) {
+	receipt, err := s.Processor.Client.TransactionReceipt(ctx, txHash)
+	if !errors.Is(err, ethereum.NotFound) {
+		return receipt, err
+	}
+	replacement, dbErr := s.Processor.Db.LatestReplacement(txHash.String())
+	if dbErr != nil {
+		utils.Logger.Debug().Err(dbErr).Msgf("failed to look up replacement | txHash: %s", txHash.String())
+		return receipt, err
+	}
+	if replacement == "" {
+		return receipt, err
+	}
+	return s.Processor.Client.TransactionReceipt(ctx, common.HexToHash(replacement))
+}
+
+// Shutdown sends the delayed transactions of s, stops waiting for receipts and persists the
+// receipts which are still tracked so that ResumeTracking can pick them up on the next start.
+// Submissions must have been stopped before.
+func Shutdown(ctx context.Context, s *EthService) error {
+	s.flushDelayed(ctx)
+
+	if s.stopTracking != nil {
+		close(s.stopTracking)
+	}
+	stopped := make(chan struct{})
+	go func() {
+		s.tracking.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		utils.Logger.Warn().Msg("Timed out waiting for receipt tracking to stop")
+	}
+
+	txHashes := s.Cache.TrackedReceipts()
+	if err := s.Processor.Db.SaveTrackedReceipts(txHashes); err != nil {
+		return err
+	}
+	utils.Logger.Info().Int("count", len(txHashes)).Msg("Persisted tracked receipts")
+	return nil
 }
 
+// MonitorBalance records the balance of the signing addresses every delayInSeconds. The signers
+// of the pool are refreshed, so that their balances and pending transactions are known when
+// picking one.
 func (p *Processor) MonitorBalance(ctx context.Context, delayInSeconds int) {
 	timer := time.NewTicker(time.Duration(delayInSeconds) * time.Second)
 
@@ -383,19 +690,91 @@ func (p *Processor) MonitorBalance(ctx context.Context, delayInSeconds int) {
 			return
 
 		case <-timer.C:
+			if p.Signers != nil {
+				p.refreshSigners(ctx)
+				continue
+			}
 			balance, err := p.Client.BalanceAt(ctx, *p.SigningAddress, nil)
 			if err != nil {
 				utils.Logger.Err(err).Msg("Failed to get balance")
 				continue
 			}
+			metrics.ERPCBalance.Set(toEther(balance))
+		}
+	}
+}
 
-			// Convert balance from Wei to Ether
-			ethValue := new(big.Float).Quo(new(big.Float).SetInt(balance), big.NewFloat(1e18))
-			balanceInFloat, _ := ethValue.Float64()
-
-			metrics.ERPCBalance.Set(balanceInFloat)
+func (p *Processor) refreshSigners(ctx context.Context) {
+	for _, signer := range p.Signers.Signers() {
+		if err := signer.Refresh(ctx, p.Client); err != nil {
+			utils.Logger.Err(err).Str("signer", signer.Address.Hex()).Msg("Failed to refresh signer")
+			continue
 		}
+		balance := toEther(signer.Balance())
+		metrics.SignerBalance.WithLabelValues(signer.Address.Hex()).Set(balance)
+		metrics.SignerPendingTransactions.WithLabelValues(signer.Address.Hex()).Set(float64(signer.Pending()))
+		if signer.Address == *p.SigningAddress {
+			metrics.ERPCBalance.Set(balance)
+		}
+	}
+}
+
+// toEther converts balance from wei to ether.
+func toEther(balance *big.Int) float64 {
+	ethValue := new(big.Float).Quo(new(big.Float).SetInt(balance), big.NewFloat(1e18))
+	balanceInFloat, _ := ethValue.Float64()
+	return balanceInFloat
+}
+
+// CurrentEon returns the eon which transactions submitted at blockNumber are encrypted for.
+func (p *Processor) CurrentEon(ctx context.Context, blockNumber uint64) (uint64, error) {
+	if p.EonKeys != nil {
+		return p.EonKeys.Eon(ctx, blockNumber+uint64(p.KeyperSetChangeLookAhead))
 	}
+	return p.KeyperSetManagerContract.GetKeyperSetIndexByBlock(&bind.CallOpts{Context: ctx}, blockNumber+uint64(p.KeyperSetChangeLookAhead))
+}
+
+// EonKey returns the eon and its key which transactions submitted at blockNumber are encrypted
+// for, KeyperSetChangeLookAhead blocks ahead. Both are taken from EonKeys if it is set.
+func (p *Processor) EonKey(ctx context.Context, blockNumber uint64) (uint64, *shcrypto.EonPublicKey, error) {
+	eon, err := p.CurrentEon(ctx, blockNumber)
+	if err != nil {
+		return 0, nil, err
+	}
+	if p.EonKeys != nil {
+		eonKey, err := p.EonKeys.Key(ctx, eon)
+		return eon, eonKey, err
+	}
+
+	eonKeyBytes, err := p.KeyBroadcastContract.GetEonKey(&bind.CallOpts{Context: ctx}, eon)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	eonKey := &shcrypto.EonPublicKey{}
+	if err := eonKey.Unmarshal(eonKeyBytes); err != nil {
+		return 0, nil, err
+	}
+	return eon, eonKey, nil
+}
+
+// allowSender takes a token from the submission bucket of sender. Transactions sent by the server
+// itself, e.g. after a delay, were already counted when they were submitted.
+func (service *EthService) allowSender(ctx context.Context, sender common.Address) bool {
+	if service.SenderLimiter == nil || utils.IsInternal(ctx) {
+		return true
+	}
+	return service.SenderLimiter.Allow(sender.Hex(), service.Config.SenderRateLimit/60, service.Config.SenderRateBurst)
+}
+
+// submissionError keeps the code of errors like exceeded spending limits which the submission to
+// the sequencer returns for the client, other errors are internal.
+func submissionError(err error) *EncodingError {
+	var encodingErr *EncodingError
+	if errors.As(err, &encodingErr) && encodingErr.StatusCode != -32603 {
+		return encodingErr
+	}
+	return returnError(-32603, err)
 }
 
 func returnError(status int, msg error) *EncodingError {
@@ -404,4 +783,4 @@ func returnError(status int, msg error) *EncodingError {
 		StatusCode: status,
 		Err:        msg,
 	}
-}
\ No newline at end of file
+}
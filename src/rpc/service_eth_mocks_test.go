@@ -4,6 +4,7 @@ import (
 	"context"
 	"math/big"
 
+	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
@@ -58,6 +59,16 @@ func (m *MockEthereumClient) SuggestGasPrice(ctx context.Context) (*big.Int, err
 	return args.Get(0).(*big.Int), args.Error(1)
 }
 
+func (m *MockEthereumClient) SuggestGasTipCap(ctx context.Context) (*big.Int, error) {
+	args := m.Called(ctx)
+	return args.Get(0).(*big.Int), args.Error(1)
+}
+
+func (m *MockEthereumClient) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	args := m.Called(ctx, number)
+	return args.Get(0).(*types.Header), args.Error(1)
+}
+
 func (m *MockEthereumClient) ChainID(ctx context.Context) (*big.Int, error) {
 	args := m.Called(ctx)
 	return args.Get(0).(*big.Int), args.Error(1)
@@ -98,11 +109,26 @@ func (m *MockEthereumClient) BlockByHash(ctx context.Context, hash common.Hash)
 	return args.Get(0).(*types.Block), args.Error(1)
 }
 
+func (m *MockEthereumClient) SyncProgress(ctx context.Context) (*ethereum.SyncProgress, error) {
+	args := m.Called(ctx)
+	return args.Get(0).(*ethereum.SyncProgress), args.Error(1)
+}
+
 func (m *MockKeyperSetManagerContract) GetKeyperSetIndexByBlock(opts *bind.CallOpts, blockNumber uint64) (uint64, error) {
 	args := m.Called(opts, blockNumber)
 	return args.Get(0).(uint64), args.Error(1)
 }
 
+func (m *MockKeyperSetManagerContract) GetKeyperSetActivationBlock(opts *bind.CallOpts, index uint64) (uint64, error) {
+	args := m.Called(opts, index)
+	return args.Get(0).(uint64), args.Error(1)
+}
+
+func (m *MockKeyperSetManagerContract) GetNumKeyperSets(opts *bind.CallOpts) (uint64, error) {
+	args := m.Called(opts)
+	return args.Get(0).(uint64), args.Error(1)
+}
+
 func (m *MockKeyBroadcastContract) GetEonKey(opts *bind.CallOpts, eon uint64) ([]byte, error) {
 	args := m.Called(opts, eon)
 	return args.Get(0).([]byte), args.Error(1)
@@ -111,4 +137,4 @@ func (m *MockKeyBroadcastContract) GetEonKey(opts *bind.CallOpts, eon uint64) ([
 func (m *MockSequencerContract) SubmitEncryptedTransaction(opts *bind.TransactOpts, eon uint64, identityPrefix [32]byte, encryptedTx []byte, gasLimit *big.Int) (*types.Transaction, error) {
 	args := m.Called(opts, eon, identityPrefix, encryptedTx, gasLimit)
 	return args.Get(0).(*types.Transaction), args.Error(1)
-}
\ No newline at end of file
+}
@@ -7,12 +7,16 @@ import (
 	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/shutter-network/encrypting-rpc-server/cache"
+	"github.com/shutter-network/encrypting-rpc-server/ratelimit"
 	"github.com/shutter-network/encrypting-rpc-server/rpc"
 	"github.com/shutter-network/encrypting-rpc-server/test"
 	"github.com/shutter-network/encrypting-rpc-server/testdata"
+	"github.com/shutter-network/encrypting-rpc-server/utils"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
@@ -356,4 +360,182 @@ func TestSendRawTransaction_PriorityGas_Error(t *testing.T) {
 	encodingErr, ok := err.(*rpc.EncodingError)
 	assert.True(t, ok, "Expected error of type *EncodingError")
 	assert.Equal(t, encodingErr.StatusCode, -32602, "Expected specific status code for invalid priority fee")
-}
\ No newline at end of file
+}
+
+func TestSendRawTransaction_SenderRateLimit(t *testing.T) {
+	service, _ := initTest(t)
+	service.SenderLimiter = ratelimit.NewKeyedLimiter()
+	service.Config.SenderRateLimit = 0.001
+	service.Config.SenderRateBurst = 1
+	chainID := big.NewInt(1)
+
+	rawTx1, _, _ := testdata.Tx(service.Processor.SigningKey, 1, chainID)
+	_, err := service.SendRawTransaction(context.Background(), rawTx1)
+	assert.NoError(t, err, "Expected first transaction to be within the limit")
+
+	rawTx2, _, _ := testdata.Tx(service.Processor.SigningKey, 2, chainID)
+	txHash, err := service.SendRawTransaction(context.Background(), rawTx2)
+	assert.Error(t, err, "Expected second transaction to be rate limited")
+	assert.Nil(t, txHash)
+
+	encodingErr, ok := err.(*rpc.EncodingError)
+	assert.True(t, ok, "Expected error of type *EncodingError")
+	assert.Equal(t, encodingErr.StatusCode, -32005, "Expected specific status code for rate limited sender")
+	service.Processor.Client.(*MockEthereumClient).AssertNumberOfCalls(t, "BlockNumber", 1)
+
+	// delayed transactions are sent by the server itself and are not limited again
+	_, err = service.SendRawTransaction(utils.WithInternal(context.Background()), rawTx2)
+	assert.NoError(t, err, "Expected internal resend to bypass the limit")
+}
+
+func TestSendRawTransaction_Delayed_KeepsRequestID(t *testing.T) {
+	service, _ := initTest(t)
+	chainID := big.NewInt(1)
+
+	rawTx1, _, _ := testdata.Tx(service.Processor.SigningKey, 1, chainID)
+	_, err := service.SendRawTransaction(utils.WithRequestID(context.Background(), "first"), rawTx1)
+	assert.NoError(t, err)
+
+	rawTx2, signedTx2, _ := testdata.Tx(service.Processor.SigningKey, 1, chainID)
+	_, err = service.SendRawTransaction(utils.WithRequestID(context.Background(), "second"), rawTx2)
+	assert.NoError(t, err)
+
+	key, err := service.Cache.Key(signedTx2)
+	assert.NoError(t, err)
+	cachedTxInfo := service.Cache.Data[key]
+	assert.True(t, cachedTxInfo.Delayed)
+	assert.Equal(t, "second", cachedTxInfo.RequestID, "the delayed transaction should be sent with the id of its request")
+}
+
+func TestShutdown_FlushesDelayedAndPersistsTrackedReceipts(t *testing.T) {
+	service, mockDb := initTest(t)
+	chainID := big.NewInt(1)
+
+	_, signedTx, _ := testdata.Tx(service.Processor.SigningKey, 1, chainID)
+	key, err := service.Cache.Key(signedTx)
+	assert.NoError(t, err)
+	service.Cache.Data[key] = cache.TransactionInfo{Tx: signedTx, CachedTime: time.Now().Unix(), Delayed: true}
+
+	pending := common.HexToHash("0x01").String()
+	service.Cache.TrackReceipt(pending)
+
+	// the receipt of the flushed transaction is found right away
+	mockDb.ExpectBegin()
+	mockDb.ExpectExec(`INSERT INTO "tracked_receipts"`).WithArgs(signedTx.Hash().String(), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+	mockDb.ExpectCommit()
+	mockDb.ExpectBegin()
+	mockDb.ExpectExec(`DELETE FROM "tracked_receipts"`).WithArgs(signedTx.Hash().String()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mockDb.ExpectCommit()
+	mockDb.ExpectBegin()
+	mockDb.ExpectExec(`INSERT INTO "tracked_receipts"`).WithArgs(pending, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+	mockDb.ExpectCommit()
+
+	err = rpc.Shutdown(context.Background(), service)
+	assert.NoError(t, err)
+
+	assert.Equal(t, mockProcessTransactionCallCount, 1, "Expected the delayed transaction to be sent")
+	assert.False(t, service.Cache.Data[key].Delayed, "Expected the transaction to not be delayed anymore")
+	assert.Equal(t, []string{pending}, service.Cache.TrackedReceipts(), "Expected only the unmined receipt to be tracked")
+	assert.NoError(t, mockDb.ExpectationsWereMet())
+}
+
+func TestResumeTracking(t *testing.T) {
+	service, mockDb := initTest(t)
+	txHash := common.HexToHash("0x02")
+
+	// the row is kept while waiting, so that the receipt is resumed after a crash as well
+	mockDb.ExpectQuery(`SELECT \* FROM "tracked_receipts"`).
+		WillReturnRows(sqlmock.NewRows([]string{"tx_hash", "created_at"}).AddRow(txHash.String(), time.Now()))
+	mockDb.ExpectBegin()
+	mockDb.ExpectExec(`INSERT INTO "tracked_receipts"`).WithArgs(txHash.String(), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+	mockDb.ExpectCommit()
+	mockDb.ExpectBegin()
+	mockDb.ExpectExec(`DELETE FROM "tracked_receipts"`).WithArgs(txHash.String()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mockDb.ExpectCommit()
+
+	err := rpc.ResumeTracking(service)
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		select {
+		case details := <-service.Processor.Db.InclusionCh:
+			return details.TxHash == txHash.String()
+		default:
+			return false
+		}
+	}, time.Second, 10*time.Millisecond, "Expected the inclusion of the resumed receipt to be recorded")
+	assert.Eventually(t, func() bool {
+		return mockDb.ExpectationsWereMet() == nil
+	}, time.Second, 10*time.Millisecond, "Expected the row to be removed once the receipt is found")
+}
+
+func TestResumeTracking_Replaced(t *testing.T) {
+	service, mockDb := initTest(t)
+	client := service.Processor.Client.(*MockEthereumClient)
+	encryptedTxHash := common.HexToHash("0x03")
+	replacementTxHash := common.HexToHash("0x04")
+
+	unsetCalls(&client.Mock, "TransactionReceipt")
+	client.On("TransactionReceipt", mock.Anything, encryptedTxHash).Return((*types.Receipt)(nil), ethereum.NotFound)
+	client.On("TransactionReceipt", mock.Anything, replacementTxHash).Return(&types.Receipt{Status: types.ReceiptStatusSuccessful}, nil)
+
+	mockDb.ExpectQuery(`SELECT \* FROM "tracked_receipts"`).
+		WillReturnRows(sqlmock.NewRows([]string{"tx_hash", "created_at"}).AddRow(encryptedTxHash.String(), time.Now()))
+	mockDb.ExpectBegin()
+	mockDb.ExpectExec(`INSERT INTO "tracked_receipts"`).WillReturnResult(sqlmock.NewResult(1, 1))
+	mockDb.ExpectCommit()
+	mockDb.ExpectQuery(`SELECT \* FROM "submission_replacements" WHERE original_tx_hash = \$1 ORDER BY id desc`).
+		WithArgs(encryptedTxHash.String(), 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "original_tx_hash", "tx_hash"}).
+			AddRow(1, encryptedTxHash.String(), replacementTxHash.String()))
+	mockDb.ExpectBegin()
+	mockDb.ExpectExec(`DELETE FROM "tracked_receipts"`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mockDb.ExpectCommit()
+
+	err := rpc.ResumeTracking(service)
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		select {
+		case details := <-service.Processor.Db.InclusionCh:
+			return details.TxHash == encryptedTxHash.String()
+		default:
+			return false
+		}
+	}, time.Second, 10*time.Millisecond, "Expected the inclusion of the replacement to be recorded for the original transaction")
+	assert.Eventually(t, func() bool {
+		return mockDb.ExpectationsWereMet() == nil
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestSubmitEncrypted_ReservesWorstCaseCost(t *testing.T) {
+	service, _ := initTest(t)
+	sequencer := service.Processor.SequencerContract.(*MockSequencerContract)
+	value := big.NewInt(1000)
+	// 100000 gas at a max fee of 3 wei
+	cost := big.NewInt(301000)
+	service.Processor.Spending = rpc.NewSpendingLimiter(rpc.SpendingLimits{Hourly: cost}, nil)
+
+	sequencer.On("SubmitEncryptedTransaction", mock.MatchedBy(func(opts *bind.TransactOpts) bool {
+		return opts.NoSend
+	}), mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Return(types.NewTx(&types.DynamicFeeTx{Gas: 100000, GasTipCap: big.NewInt(1), GasFeeCap: big.NewInt(3), Value: value}), nil)
+	var sent *bind.TransactOpts
+	submitTx := types.NewTx(&types.DynamicFeeTx{Gas: 100000, GasTipCap: big.NewInt(1), GasFeeCap: big.NewInt(3), Value: value})
+	sequencer.On("SubmitEncryptedTransaction", mock.MatchedBy(func(opts *bind.TransactOpts) bool {
+		return !opts.NoSend
+	}), mock.Anything, mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		sent = args.Get(0).(*bind.TransactOpts)
+	}).Return(submitTx, nil)
+
+	signer := &rpc.Signer{TxSigner: rpc.NewKeySigner(service.Processor.SigningKey), Address: *service.Processor.SigningAddress}
+	_, err := service.Processor.SubmitEncrypted(context.Background(), signer, 3, [32]byte{}, []byte{1}, value, 50000)
+	assert.NoError(t, err)
+	if assert.NotNil(t, sent) {
+		assert.Equal(t, uint64(100000), sent.GasLimit, "the transaction should be sent with the reserved gas limit")
+		assert.Equal(t, big.NewInt(3), sent.GasFeeCap)
+	}
+
+	_, err = service.Processor.SubmitEncrypted(context.Background(), signer, 3, [32]byte{}, []byte{1}, value, 50000)
+	assert.ErrorContains(t, err, "hourly spending limit exceeded, 0 wei")
+}
@@ -0,0 +1,527 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	txtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/shutter-network/encrypting-rpc-server/db"
+	"github.com/shutter-network/encrypting-rpc-server/metrics"
+	"github.com/shutter-network/encrypting-rpc-server/utils"
+	"github.com/shutter-network/shutter/shlib/shcrypto"
+)
+
+// ShutterNamespace is the namespace the ShutterService is registered under.
+const ShutterNamespace = "shutter"
+
+// Lifecycle states of a submitted transaction.
+const (
+	TxStatusUnknown   = "unknown"
+	TxStatusDelayed   = "delayed"
+	TxStatusSubmitted = "submitted"
+	TxStatusIncluded  = "included"
+	TxStatusDropped   = "dropped"
+)
+
+// States of the transaction which submits the encrypted transaction to the sequencer contract.
+const (
+	SequencerStatusPending  = "pending"
+	SequencerStatusIncluded = "included"
+	SequencerStatusFailed   = "failed"
+)
+
+// ShutterService serves the shutter namespace next to the EthService, whose processor and cache it
+// shares. Every exported method is exposed by the rpc server.
+type ShutterService struct {
+	eth *EthService
+
+	mu sync.Mutex
+	// feePayments are the hashes of the fee payments being relayed for right now
+	feePayments map[common.Hash]bool
+}
+
+func NewShutterService(eth *EthService) *ShutterService {
+	return &ShutterService{eth: eth, feePayments: make(map[common.Hash]bool)}
+}
+
+type TransactionStatus struct {
+	TxHash            common.Hash  `json:"txHash"`
+	Status            string       `json:"status"`
+	EncryptedTxHash   *common.Hash `json:"encryptedTxHash,omitempty"`
+	SequencerStatus   string       `json:"sequencerStatus,omitempty"`
+	IsCancellation    bool         `json:"isCancellation"`
+	SendTime          int64        `json:"sendTime,omitempty"`
+	SubmissionTime    int64        `json:"submissionTime,omitempty"`
+	InclusionTime     uint64       `json:"inclusionTime,omitempty"`
+	WaitingForReceipt bool         `json:"waitingForReceipt"`
+}
+
+// GetTransactionStatus reports where a transaction sent with eth_sendRawTransaction is. Delayed
+// transactions are still in the cache and are sent at sendTime. Submitted transactions have been
+// encrypted and sent to the sequencer, or forwarded in case of cancellations, and are waiting to be
+// included. Transactions are dropped if the sequencer transaction failed, their nonce has been used
+// by another transaction or they were replaced while delayed.
+func (s *ShutterService) GetTransactionStatus(ctx context.Context, txHash common.Hash) (*TransactionStatus, error) {
+	status := &TransactionStatus{
+		TxHash:            txHash,
+		Status:            TxStatusUnknown,
+		WaitingForReceipt: s.eth.Cache.IsTrackingReceipt(txHash.String()),
+	}
+
+	if info, found := s.eth.Cache.FindByHash(txHash); found && info.Delayed {
+		status.Status = TxStatusDelayed
+		status.SendTime = info.CachedTime + s.eth.Cache.DelayFactor
+		return status, nil
+	}
+
+	rows, err := s.eth.Processor.Db.GetTransactionDetails(txHash.String())
+	if err != nil {
+		return nil, returnError(-32603, err)
+	}
+	if len(rows) == 0 {
+		return status, nil
+	}
+	submitted, found := submittedRow(rows)
+	if !found {
+		// the transaction was delayed but a replacement has been sent instead
+		status.Status = TxStatusDropped
+		return status, nil
+	}
+
+	status.Status = TxStatusSubmitted
+	status.IsCancellation = submitted.IsCancellation
+	status.SubmissionTime = submitted.SubmissionTime
+	status.InclusionTime = submitted.InclusionTime
+	receiptHash := txHash
+	if submitted.EncryptedTxHash != "" {
+		encryptedTxHash := common.HexToHash(submitted.EncryptedTxHash)
+		encryptedTxHash, status.SequencerStatus, err = s.sequencerStatus(ctx, encryptedTxHash)
+		if err != nil {
+			return nil, returnError(-32603, err)
+		}
+		status.EncryptedTxHash = &encryptedTxHash
+		if submitted.TxHash == submitted.EncryptedTxHash {
+			// pre-encrypted transactions are recorded under their sequencer transaction
+			receiptHash = encryptedTxHash
+		}
+	}
+
+	if status.InclusionTime > 0 {
+		status.Status = TxStatusIncluded
+		return status, nil
+	}
+
+	receipt, err := s.eth.Processor.Client.TransactionReceipt(ctx, receiptHash)
+	switch {
+	case err == nil:
+		status.Status = TxStatusIncluded
+		if block, err := s.eth.Processor.Client.BlockByHash(ctx, receipt.BlockHash); err == nil {
+			status.InclusionTime = block.Time()
+		}
+		return status, nil
+	case !errors.Is(err, ethereum.NotFound):
+		return nil, returnError(-32603, err)
+	}
+
+	if status.SequencerStatus == SequencerStatusFailed {
+		status.Status = TxStatusDropped
+		return status, nil
+	}
+	accountNonce, err := s.eth.Processor.Client.NonceAt(ctx, common.HexToAddress(submitted.Address), nil)
+	if err != nil {
+		return nil, returnError(-32603, err)
+	}
+	if accountNonce > submitted.Nonce {
+		status.Status = TxStatusDropped
+	}
+	return status, nil
+}
+
+// EncryptedTransaction holds the arguments of SubmitEncryptedTransaction of the sequencer contract.
+type EncryptedTransaction struct {
+	Eon            hexutil.Uint64 `json:"eon"`
+	IdentityPrefix hexutil.Bytes  `json:"identityPrefix"`
+	EncryptedTx    hexutil.Bytes  `json:"encryptedTransaction"`
+	Value          *hexutil.Big   `json:"value"`
+	GasLimit       hexutil.Uint64 `json:"gasLimit"`
+}
+
+// EncryptTransaction encrypts the signed transaction rawTx like eth_sendRawTransaction would, but
+// returns the payload instead of submitting it. sender is the address which will submit the payload
+// to the sequencer, as the identity the transaction is encrypted for is derived from it.
+func (s *ShutterService) EncryptTransaction(ctx context.Context, rawTx hexutil.Bytes, sender common.Address) (*EncryptedTransaction, error) {
+	tx := new(txtypes.Transaction)
+	if err := tx.UnmarshalBinary(rawTx); err != nil {
+		return nil, returnError(-32602, err)
+	}
+	fromAddress, err := utils.SenderAddress(tx)
+	if err != nil {
+		return nil, returnError(-32602, err)
+	}
+
+	blockNumber, err := s.eth.Processor.Client.BlockNumber(ctx)
+	if err != nil {
+		return nil, returnError(-32602, err)
+	}
+	if err := s.eth.validateTransaction(ctx, tx, fromAddress); err != nil {
+		return nil, err
+	}
+
+	encrypted, err := s.eth.Processor.Encrypt(ctx, rawTx, blockNumber, sender)
+	if err != nil {
+		return nil, returnError(-32602, err)
+	}
+	return &EncryptedTransaction{
+		Eon:            hexutil.Uint64(encrypted.Eon),
+		IdentityPrefix: encrypted.IdentityPrefix[:],
+		EncryptedTx:    encrypted.EncryptedTx.Marshal(),
+		Value:          (*hexutil.Big)(SequencerValue(tx)),
+		GasLimit:       hexutil.Uint64(tx.Gas()),
+	}, nil
+}
+
+// PreEncryptedTransaction is a transaction encrypted by the client. FeePayment is a signed and
+// already included transaction which paid the signing address at least GasLimit times the gas
+// price for relaying, with the FeePaymentCommitment of the encrypted transaction as data.
+type PreEncryptedTransaction struct {
+	Eon            hexutil.Uint64 `json:"eon"`
+	IdentityPrefix hexutil.Bytes  `json:"identityPrefix"`
+	EncryptedTx    hexutil.Bytes  `json:"encryptedTransaction"`
+	GasLimit       hexutil.Uint64 `json:"gasLimit"`
+	FeePayment     hexutil.Bytes  `json:"feePayment"`
+}
+
+// FeePaymentCommitment is the data a fee payment has to carry, which ties it to the relay of
+// encryptedTx under identityPrefix, so that no other transfer to a signing address pays for it.
+func FeePaymentCommitment(identityPrefix []byte, encryptedTx []byte) common.Hash {
+	return crypto.Keccak256Hash(identityPrefix, encryptedTx)
+}
+
+// SendEncryptedTransaction relays a transaction which the client encrypted itself, so that the
+// server never sees the plaintext. The identity has to be derived from the identity prefix and the
+// signing address, which submits the transaction to the sequencer. The fee payment has to be
+// included successfully already, and every payment is accepted once, so that it cannot pay for
+// several relays. Once it has been claimed, relaying no longer depends on the request, so that a
+// paid relay is not given up when the client disconnects. The returned hash is the one of the
+// sequencer transaction, which is recorded and tracked like submissions of eth_sendRawTransaction.
+func (s *ShutterService) SendEncryptedTransaction(ctx context.Context, args PreEncryptedTransaction) (*common.Hash, error) {
+	logger := utils.ContextLogger(ctx)
+	processor := s.eth.Processor
+
+	feeTx := new(txtypes.Transaction)
+	if err := feeTx.UnmarshalBinary(args.FeePayment); err != nil {
+		return nil, returnError(-32602, fmt.Errorf("invalid fee payment: %w", err))
+	}
+	payer, err := utils.SenderAddress(feeTx)
+	if err != nil {
+		return nil, returnError(-32602, fmt.Errorf("invalid fee payment: %w", err))
+	}
+	if !s.eth.allowSender(ctx, payer) {
+		metrics.RateLimitedRequests.WithLabelValues("sender").Inc()
+		logger.Info().Str("sender", payer.Hex()).Msg("sender rate limit exceeded")
+		return nil, returnError(-32005, errors.New("sender rate limit exceeded"))
+	}
+
+	if len(args.IdentityPrefix) != 32 {
+		return nil, returnError(-32602, errors.New("identity prefix must be 32 bytes"))
+	}
+	if err := new(shcrypto.EncryptedMessage).Unmarshal(args.EncryptedTx); err != nil {
+		return nil, returnError(-32602, fmt.Errorf("invalid encrypted transaction: %w", err))
+	}
+	gasLimit := uint64(args.GasLimit)
+	if gasLimit < params.TxGas {
+		return nil, returnError(-32602, fmt.Errorf("gas limit below the intrinsic gas limit %d", params.TxGas))
+	}
+	if gasLimit > s.eth.Config.EncryptedGasLimit {
+		return nil, returnError(-32000, errors.New("gas limit exceeds encrypted gas limit "+
+			"(max gas limit allowed per shutterized block)"))
+	}
+
+	blockNumber, err := processor.Client.BlockNumber(ctx)
+	if err != nil {
+		return nil, returnError(-32602, err)
+	}
+	eon, err := processor.CurrentEon(ctx, blockNumber)
+	if err != nil {
+		return nil, returnError(-32603, err)
+	}
+	if uint64(args.Eon) != eon {
+		return nil, returnError(-32602, fmt.Errorf("eon %d is not the current eon %d", args.Eon, eon))
+	}
+
+	gasPrice, err := s.eth.adjustedGasPrice(ctx)
+	if err != nil {
+		return nil, returnError(-32603, err)
+	}
+	value := new(big.Int).Mul(gasPrice, new(big.Int).SetUint64(gasLimit))
+	// the identity prefix was derived for the address which submits the transaction, so the fee
+	// recipient submits it
+	if feeTx.To() == nil {
+		return nil, returnError(-32602, errors.New("fee payment must be sent to a signing address"))
+	}
+	signer, ok := processor.signerByAddress(*feeTx.To())
+	if !ok {
+		return nil, returnError(-32602, fmt.Errorf("fee payment must be sent to a signing address, not %s", feeTx.To().Hex()))
+	}
+	if feeTx.Value().Cmp(value) < 0 {
+		return nil, returnError(-32602, fmt.Errorf("fee payment of %s wei is below the required %s wei", feeTx.Value(), value))
+	}
+	if commitment := FeePaymentCommitment(args.IdentityPrefix, args.EncryptedTx); !bytes.Equal(feeTx.Data(), commitment.Bytes()) {
+		return nil, returnError(-32602, fmt.Errorf("fee payment data must be the commitment %s to the encrypted transaction", commitment.Hex()))
+	}
+
+	if !s.lockFeePayment(feeTx.Hash()) {
+		return nil, returnError(-32602, errors.New("fee payment is already being used"))
+	}
+	defer s.unlockFeePayment(feeTx.Hash())
+	if err := s.checkFeePayment(ctx, feeTx); err != nil {
+		return nil, err
+	}
+	claimed, err := processor.Db.ClaimFeePayment(db.FeePayment{
+		TxHash:        feeTx.Hash().String(),
+		PayerAddress:  payer.String(),
+		SignerAddress: signer.Address.String(),
+	})
+	if err != nil {
+		return nil, returnError(-32603, err)
+	}
+	if !claimed {
+		return nil, returnError(-32602, errors.New("fee payment has been used already"))
+	}
+
+	// the fee has been paid, so the relay is finished even if the client goes away
+	ctx = context.WithoutCancel(ctx)
+	sub, err := processor.prepareSubmission(ctx, signer, eon, [32]byte(args.IdentityPrefix), args.EncryptedTx, value, gasLimit)
+	if err != nil {
+		s.unclaimFeePayment(ctx, feeTx.Hash())
+		return nil, submissionError(err)
+	}
+	submitTx, err := processor.sendSubmission(ctx, sub)
+	if err != nil {
+		logger.Err(err).Uint64("eon", eon).Hex("Fee payment tx hash", feeTx.Hash().Bytes()).Msg("Failed to submit pre-encrypted transaction")
+		s.unclaimFeePayment(ctx, feeTx.Hash())
+		return nil, submissionError(err)
+	}
+	submitTxHash := submitTx.Hash()
+	logger.Info().Hex("Fee payment tx hash", feeTx.Hash().Bytes()).Hex("Encrypted tx hash", submitTxHash.Bytes()).Msg("Pre-encrypted transaction sent")
+
+	// the plaintext transaction is unknown, so the sequencer transaction stands in for it, also
+	// for the nonce which tells whether it has been dropped
+	processor.Db.InsertNewTx(db.TransactionDetails{
+		Address:         signer.Address.String(),
+		Nonce:           submitTx.Nonce(),
+		TxHash:          submitTxHash.String(),
+		EncryptedTxHash: submitTxHash.String(),
+		SignerAddress:   signer.Address.String(),
+		SubmissionTime:  time.Now().Unix(),
+		APIKeyID:        utils.APIKeyID(ctx),
+		RequestID:       utils.RequestID(ctx),
+	})
+	s.eth.trackReceipt(submitTxHash)
+
+	metrics.RequestedGasLimit.Observe(float64(gasLimit))
+	return &submitTxHash, nil
+}
+
+// lockFeePayment reports whether the fee payment feeTxHash is not being used by another call, and
+// reserves it for the caller until unlockFeePayment.
+func (s *ShutterService) lockFeePayment(feeTxHash common.Hash) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.feePayments[feeTxHash] {
+		return false
+	}
+	s.feePayments[feeTxHash] = true
+	return true
+}
+
+func (s *ShutterService) unlockFeePayment(feeTxHash common.Hash) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.feePayments, feeTxHash)
+}
+
+// unclaimFeePayment removes the fee payment feeTxHash from the database if nothing has been relayed
+// for it, so that the client can retry with the same payment.
+func (s *ShutterService) unclaimFeePayment(ctx context.Context, feeTxHash common.Hash) {
+	if err := s.eth.Processor.Db.ReleaseFeePayment(feeTxHash.String()); err != nil {
+		logger := utils.ContextLogger(ctx)
+		logger.Err(err).Hex("Fee payment tx hash", feeTxHash.Bytes()).Msg("Failed to release fee payment")
+	}
+}
+
+// checkFeePayment fails unless the fee payment feeTx has been included successfully.
+func (s *ShutterService) checkFeePayment(ctx context.Context, feeTx *txtypes.Transaction) error {
+	receipt, err := s.eth.Processor.Client.TransactionReceipt(ctx, feeTx.Hash())
+	switch {
+	case errors.Is(err, ethereum.NotFound):
+		return returnError(-32602, errors.New("fee payment has not been included yet"))
+	case err != nil:
+		return returnError(-32603, err)
+	case receipt.Status != txtypes.ReceiptStatusSuccessful:
+		return returnError(-32602, errors.New("fee payment failed"))
+	}
+	return nil
+}
+
+// Eon describes a keyper set and its eon key. The key is empty as long as it has not been
+// broadcast.
+type Eon struct {
+	Eon             hexutil.Uint64 `json:"eon"`
+	ActivationBlock hexutil.Uint64 `json:"activationBlock"`
+	EonKey          hexutil.Bytes  `json:"eonKey"`
+}
+
+// CurrentEon is the eon transactions submitted now are encrypted for, which is the one active
+// KeyperSetChangeLookAhead blocks after the latest block. Next is set if another keyper set has
+// been added already.
+type CurrentEon struct {
+	Eon
+	BlockNumber              hexutil.Uint64   `json:"blockNumber"`
+	KeyperSetChangeLookAhead hexutil.Uint64   `json:"keyperSetChangeLookAhead"`
+	SigningAddress           common.Address   `json:"signingAddress"`
+	SigningAddresses         []common.Address `json:"signingAddresses"`
+	Next                     *Eon             `json:"next,omitempty"`
+}
+
+// GetCurrentEon returns the eon and eon key the server encrypts transactions for. Identities are
+// derived from the address which submits a transaction, one of the signing addresses, the primary
+// one being SigningAddress.
+func (s *ShutterService) GetCurrentEon(ctx context.Context) (*CurrentEon, error) {
+	processor := s.eth.Processor
+	blockNumber, err := processor.Client.BlockNumber(ctx)
+	if err != nil {
+		return nil, returnError(-32603, err)
+	}
+	eon, err := processor.CurrentEon(ctx, blockNumber)
+	if err != nil {
+		return nil, returnError(-32603, err)
+	}
+	current, err := s.eon(ctx, eon)
+	if err != nil {
+		return nil, returnError(-32603, err)
+	}
+	if current == nil {
+		return nil, returnError(-32603, fmt.Errorf("eon %d does not exist", eon))
+	}
+
+	result := &CurrentEon{
+		Eon:                      *current,
+		BlockNumber:              hexutil.Uint64(blockNumber),
+		KeyperSetChangeLookAhead: hexutil.Uint64(processor.KeyperSetChangeLookAhead),
+		SigningAddress:           *processor.SigningAddress,
+		SigningAddresses:         processor.SigningAddresses(),
+	}
+	result.Next, err = s.eon(ctx, eon+1)
+	if err != nil {
+		return nil, returnError(-32603, err)
+	}
+	return result, nil
+}
+
+// GetEonKey returns the activation block and key of eon.
+func (s *ShutterService) GetEonKey(ctx context.Context, eon hexutil.Uint64) (*Eon, error) {
+	result, err := s.eon(ctx, uint64(eon))
+	if err != nil {
+		return nil, returnError(-32603, err)
+	}
+	if result == nil {
+		return nil, returnError(-32602, fmt.Errorf("eon %d does not exist", eon))
+	}
+	return result, nil
+}
+
+// eon returns the activation block and key of eon, nil if its keyper set does not exist. Both are
+// taken from EonKeys if it is set.
+func (s *ShutterService) eon(ctx context.Context, eon uint64) (*Eon, error) {
+	processor := s.eth.Processor
+	if processor.EonKeys != nil {
+		activationBlock, found, err := processor.EonKeys.ActivationBlock(ctx, eon)
+		if err != nil || !found {
+			return nil, err
+		}
+		result := &Eon{Eon: hexutil.Uint64(eon), ActivationBlock: hexutil.Uint64(activationBlock)}
+		eonKey, err := processor.EonKeys.Key(ctx, eon)
+		switch {
+		case errors.Is(err, errEonKeyNotBroadcast):
+			result.EonKey = hexutil.Bytes{}
+		case err != nil:
+			return nil, err
+		default:
+			result.EonKey = eonKey.Marshal()
+		}
+		return result, nil
+	}
+
+	opts := &bind.CallOpts{Context: ctx}
+	numKeyperSets, err := processor.KeyperSetManagerContract.GetNumKeyperSets(opts)
+	if err != nil {
+		return nil, err
+	}
+	if eon >= numKeyperSets {
+		return nil, nil
+	}
+	activationBlock, err := processor.KeyperSetManagerContract.GetKeyperSetActivationBlock(opts, eon)
+	if err != nil {
+		return nil, err
+	}
+	eonKey, err := processor.KeyBroadcastContract.GetEonKey(opts, eon)
+	if err != nil {
+		return nil, err
+	}
+	return &Eon{
+		Eon:             hexutil.Uint64(eon),
+		ActivationBlock: hexutil.Uint64(activationBlock),
+		EonKey:          eonKey,
+	}, nil
+}
+
+// sequencerStatus returns the status of the sequencer transaction encryptedTxHash, or of its latest
+// replacement together with its hash.
+func (s *ShutterService) sequencerStatus(ctx context.Context, encryptedTxHash common.Hash) (common.Hash, string, error) {
+	receipt, err := s.eth.Processor.Client.TransactionReceipt(ctx, encryptedTxHash)
+	if errors.Is(err, ethereum.NotFound) {
+		// the sequencer transaction might have been replaced because it got stuck
+		replacement, dbErr := s.eth.Processor.Db.LatestReplacement(encryptedTxHash.String())
+		if dbErr != nil {
+			return common.Hash{}, "", dbErr
+		}
+		if replacement == "" {
+			return encryptedTxHash, SequencerStatusPending, nil
+		}
+		encryptedTxHash = common.HexToHash(replacement)
+		receipt, err = s.eth.Processor.Client.TransactionReceipt(ctx, encryptedTxHash)
+		if errors.Is(err, ethereum.NotFound) {
+			return encryptedTxHash, SequencerStatusPending, nil
+		}
+	}
+	if err != nil {
+		return common.Hash{}, "", err
+	}
+	if receipt.Status != txtypes.ReceiptStatusSuccessful {
+		return encryptedTxHash, SequencerStatusFailed, nil
+	}
+	return encryptedTxHash, SequencerStatusIncluded, nil
+}
+
+// submittedRow returns the latest row of a transaction which has actually been sent. Rows of
+// delayed transactions are recorded without submission time.
+func submittedRow(rows []db.TransactionDetails) (db.TransactionDetails, bool) {
+	for _, row := range rows {
+		if row.EncryptedTxHash != "" || row.IsCancellation {
+			return row, true
+		}
+	}
+	return db.TransactionDetails{}, false
+}
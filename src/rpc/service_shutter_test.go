@@ -0,0 +1,559 @@
+package rpc_test
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/shutter-network/encrypting-rpc-server/rpc"
+	"github.com/shutter-network/encrypting-rpc-server/test"
+	"github.com/shutter-network/encrypting-rpc-server/testdata"
+	"github.com/shutter-network/rolling-shutter/rolling-shutter/medley/identitypreimage"
+	"github.com/shutter-network/shutter/shlib/shcrypto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+var transactionDetailsColumns = []string{
+	"address", "nonce", "tx_hash", "encrypted_tx_hash", "submission_time", "inclusion_time", "is_cancellation", "api_key_id", "request_id",
+}
+
+func TestGetTransactionStatus_Delayed(t *testing.T) {
+	service, _ := initTest(t)
+	shutter := rpc.NewShutterService(service)
+
+	privateKey, _, err := testdata.GenerateKeyPair()
+	require.NoError(t, err)
+	_, tx, err := testdata.Tx(privateKey, 1, big.NewInt(1))
+	require.NoError(t, err)
+	key, err := service.Cache.Key(tx)
+	require.NoError(t, err)
+	service.Cache.UpdateEntry(key, tx, 100, true)
+
+	status, err := shutter.GetTransactionStatus(context.Background(), tx.Hash())
+	require.NoError(t, err)
+	assert.Equal(t, rpc.TxStatusDelayed, status.Status)
+	assert.Equal(t, int64(110), status.SendTime)
+}
+
+func TestGetTransactionStatus_Submitted(t *testing.T) {
+	service, mockDb := initTest(t)
+	shutter := rpc.NewShutterService(service)
+	client := new(MockEthereumClient)
+	service.Processor.Client = client
+
+	sender := common.HexToAddress("0x01")
+	txHash := common.HexToHash("0x02")
+	encryptedTxHash := common.HexToHash("0x03")
+	submissionTime := time.Now().Unix()
+
+	expectRows := func() {
+		mockDb.ExpectQuery(`SELECT \* FROM "transaction_details" WHERE tx_hash = \$1 ORDER BY submission_time desc`).
+			WithArgs(txHash.String()).
+			WillReturnRows(sqlmock.NewRows(transactionDetailsColumns).
+				AddRow(sender.String(), 5, txHash.String(), encryptedTxHash.String(), submissionTime, 0, false, 0, ""))
+	}
+
+	expectRows()
+	client.On("TransactionReceipt", mock.Anything, encryptedTxHash).Return(&types.Receipt{Status: types.ReceiptStatusSuccessful}, nil).Once()
+	client.On("TransactionReceipt", mock.Anything, txHash).Return((*types.Receipt)(nil), ethereum.NotFound).Once()
+	client.On("NonceAt", mock.Anything, sender, (*big.Int)(nil)).Return(uint64(5), nil).Once()
+
+	status, err := shutter.GetTransactionStatus(context.Background(), txHash)
+	require.NoError(t, err)
+	assert.Equal(t, rpc.TxStatusSubmitted, status.Status)
+	assert.Equal(t, rpc.SequencerStatusIncluded, status.SequencerStatus)
+	assert.Equal(t, &encryptedTxHash, status.EncryptedTxHash)
+	assert.Equal(t, submissionTime, status.SubmissionTime)
+
+	expectRows()
+	client.On("TransactionReceipt", mock.Anything, encryptedTxHash).Return(&types.Receipt{Status: types.ReceiptStatusSuccessful}, nil).Once()
+	client.On("TransactionReceipt", mock.Anything, txHash).Return((*types.Receipt)(nil), ethereum.NotFound).Once()
+	client.On("NonceAt", mock.Anything, sender, (*big.Int)(nil)).Return(uint64(6), nil).Once()
+
+	status, err = shutter.GetTransactionStatus(context.Background(), txHash)
+	require.NoError(t, err)
+	assert.Equal(t, rpc.TxStatusDropped, status.Status, "the nonce has been used by another transaction")
+
+	expectRows()
+	block := types.NewBlock(&types.Header{Time: 1234}, nil, nil, nil)
+	client.On("TransactionReceipt", mock.Anything, encryptedTxHash).Return(&types.Receipt{Status: types.ReceiptStatusSuccessful}, nil).Once()
+	client.On("TransactionReceipt", mock.Anything, txHash).Return(&types.Receipt{BlockHash: block.Hash()}, nil).Once()
+	client.On("BlockByHash", mock.Anything, block.Hash()).Return(block, nil).Once()
+
+	status, err = shutter.GetTransactionStatus(context.Background(), txHash)
+	require.NoError(t, err)
+	assert.Equal(t, rpc.TxStatusIncluded, status.Status)
+	assert.Equal(t, uint64(1234), status.InclusionTime)
+
+	assert.NoError(t, mockDb.ExpectationsWereMet())
+	client.AssertExpectations(t)
+}
+
+func TestGetTransactionStatus_Replaced(t *testing.T) {
+	service, mockDb := initTest(t)
+	shutter := rpc.NewShutterService(service)
+	client := new(MockEthereumClient)
+	service.Processor.Client = client
+
+	sender := common.HexToAddress("0x01")
+	txHash := common.HexToHash("0x02")
+	encryptedTxHash := common.HexToHash("0x03")
+	replacementTxHash := common.HexToHash("0x04")
+
+	mockDb.ExpectQuery(`SELECT \* FROM "transaction_details" WHERE tx_hash = \$1 ORDER BY submission_time desc`).
+		WithArgs(txHash.String()).
+		WillReturnRows(sqlmock.NewRows(transactionDetailsColumns).
+			AddRow(sender.String(), 5, txHash.String(), encryptedTxHash.String(), time.Now().Unix(), 0, false, 0, ""))
+	mockDb.ExpectQuery(`SELECT \* FROM "submission_replacements" WHERE original_tx_hash = \$1 ORDER BY id desc`).
+		WithArgs(encryptedTxHash.String(), 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "original_tx_hash", "tx_hash"}).
+			AddRow(1, encryptedTxHash.String(), replacementTxHash.String()))
+	client.On("TransactionReceipt", mock.Anything, encryptedTxHash).Return((*types.Receipt)(nil), ethereum.NotFound).Once()
+	client.On("TransactionReceipt", mock.Anything, replacementTxHash).Return(&types.Receipt{Status: types.ReceiptStatusSuccessful}, nil).Once()
+	client.On("TransactionReceipt", mock.Anything, txHash).Return((*types.Receipt)(nil), ethereum.NotFound).Once()
+	client.On("NonceAt", mock.Anything, sender, (*big.Int)(nil)).Return(uint64(5), nil).Once()
+
+	status, err := shutter.GetTransactionStatus(context.Background(), txHash)
+	require.NoError(t, err)
+	assert.Equal(t, rpc.TxStatusSubmitted, status.Status)
+	assert.Equal(t, rpc.SequencerStatusIncluded, status.SequencerStatus)
+	assert.Equal(t, &replacementTxHash, status.EncryptedTxHash, "the replacement of the stuck sequencer transaction is reported")
+
+	assert.NoError(t, mockDb.ExpectationsWereMet())
+	client.AssertExpectations(t)
+}
+
+func TestGetTransactionStatus_PreEncryptedPending(t *testing.T) {
+	service, mockDb := initTest(t)
+	shutter := rpc.NewShutterService(service)
+	client := new(MockEthereumClient)
+	service.Processor.Client = client
+
+	signer := common.HexToAddress("0x01")
+	encryptedTxHash := common.HexToHash("0x03")
+
+	// pre-encrypted transactions are recorded under their sequencer transaction and its nonce
+	mockDb.ExpectQuery(`SELECT \* FROM "transaction_details" WHERE tx_hash = \$1 ORDER BY submission_time desc`).
+		WithArgs(encryptedTxHash.String()).
+		WillReturnRows(sqlmock.NewRows(transactionDetailsColumns).
+			AddRow(signer.String(), 7, encryptedTxHash.String(), encryptedTxHash.String(), time.Now().Unix(), 0, false, 0, ""))
+	mockDb.ExpectQuery(`SELECT \* FROM "submission_replacements" WHERE original_tx_hash = \$1 ORDER BY id desc`).
+		WithArgs(encryptedTxHash.String(), 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "original_tx_hash", "tx_hash"}))
+	client.On("TransactionReceipt", mock.Anything, encryptedTxHash).Return((*types.Receipt)(nil), ethereum.NotFound).Twice()
+	client.On("NonceAt", mock.Anything, signer, (*big.Int)(nil)).Return(uint64(7), nil).Once()
+
+	status, err := shutter.GetTransactionStatus(context.Background(), encryptedTxHash)
+	require.NoError(t, err)
+	assert.Equal(t, rpc.TxStatusSubmitted, status.Status, "the relay should not be reported as dropped while pending")
+	assert.Equal(t, rpc.SequencerStatusPending, status.SequencerStatus)
+
+	assert.NoError(t, mockDb.ExpectationsWereMet())
+	client.AssertExpectations(t)
+}
+
+func TestGetTransactionStatus_Unknown(t *testing.T) {
+	service, mockDb := initTest(t)
+	shutter := rpc.NewShutterService(service)
+	txHash := common.HexToHash("0x02")
+
+	mockDb.ExpectQuery(`SELECT \* FROM "transaction_details"`).
+		WillReturnRows(sqlmock.NewRows(transactionDetailsColumns))
+
+	status, err := shutter.GetTransactionStatus(context.Background(), txHash)
+	require.NoError(t, err)
+	assert.Equal(t, rpc.TxStatusUnknown, status.Status)
+}
+
+func TestEncryptTransaction(t *testing.T) {
+	service, _ := initTest(t)
+	shutter := rpc.NewShutterService(service)
+
+	service.Processor.KeyperSetManagerContract.(*MockKeyperSetManagerContract).
+		On("GetKeyperSetIndexByBlock", mock.Anything, uint64(1)).Return(uint64(3), nil)
+	service.Processor.KeyBroadcastContract.(*MockKeyBroadcastContract).
+		On("GetEonKey", mock.Anything, uint64(3)).Return(test.TestEonKey.Marshal(), nil)
+
+	rawTx, tx, err := testdata.Tx(service.Processor.SigningKey, 1, big.NewInt(1))
+	require.NoError(t, err)
+	relayer := common.HexToAddress("0x04")
+
+	encrypted, err := shutter.EncryptTransaction(context.Background(), hexutil.MustDecode(rawTx), relayer)
+	require.NoError(t, err)
+	assert.Equal(t, hexutil.Uint64(3), encrypted.Eon)
+	assert.Equal(t, hexutil.Uint64(tx.Gas()), encrypted.GasLimit)
+	assert.Equal(t, new(big.Int).Sub(tx.Cost(), tx.Value()), encrypted.Value.ToInt())
+
+	// the payload can be decrypted with the key of the identity of the relayer
+	preimage := identitypreimage.IdentityPreimage(append(encrypted.IdentityPrefix, relayer.Bytes()...))
+	epochSecretKey, err := test.TestKeygen.EpochSecretKey(preimage)
+	require.NoError(t, err)
+	message := &shcrypto.EncryptedMessage{}
+	require.NoError(t, message.Unmarshal(encrypted.EncryptedTx))
+	decrypted, err := message.Decrypt(epochSecretKey)
+	require.NoError(t, err)
+	assert.Equal(t, hexutil.MustDecode(rawTx), decrypted)
+}
+
+func TestEncryptTransaction_Invalid(t *testing.T) {
+	service, _ := initTest(t)
+	shutter := rpc.NewShutterService(service)
+
+	rawTx, _, err := testdata.Tx(service.Processor.SigningKey, 0, big.NewInt(1))
+	require.NoError(t, err)
+
+	_, err = shutter.EncryptTransaction(context.Background(), hexutil.MustDecode(rawTx), common.HexToAddress("0x04"))
+	assert.Error(t, err, "transactions with a used nonce should be rejected")
+}
+
+func preEncryptedTransaction(t *testing.T, service *rpc.EthService, feeValue *big.Int) rpc.PreEncryptedTransaction {
+	sigma, err := shcrypto.RandomSigma(rand.Reader)
+	require.NoError(t, err)
+	identityPrefix, err := shcrypto.RandomSigma(rand.Reader)
+	require.NoError(t, err)
+	identity := rpc.ComputeIdentity(identityPrefix[:], *service.Processor.SigningAddress)
+	encryptedTx := shcrypto.Encrypt([]byte("plaintext"), test.TestEonKey, identity, sigma).Marshal()
+
+	feeTx, err := types.SignTx(types.NewTx(&types.DynamicFeeTx{
+		ChainID:   big.NewInt(1),
+		Nonce:     1,
+		GasTipCap: big.NewInt(2000000000),
+		GasFeeCap: big.NewInt(2000000000),
+		Gas:       22000,
+		To:        service.Processor.SigningAddress,
+		Value:     feeValue,
+		Data:      rpc.FeePaymentCommitment(identityPrefix[:], encryptedTx).Bytes(),
+	}), types.LatestSignerForChainID(big.NewInt(1)), service.Processor.SigningKey)
+	require.NoError(t, err)
+	feePayment, err := feeTx.MarshalBinary()
+	require.NoError(t, err)
+
+	return rpc.PreEncryptedTransaction{
+		Eon:            3,
+		IdentityPrefix: identityPrefix[:],
+		EncryptedTx:    encryptedTx,
+		GasLimit:       50000,
+		FeePayment:     feePayment,
+	}
+}
+
+// unsetCalls removes the expectations of method, so that the ones of initTest can be replaced.
+func unsetCalls(m *mock.Mock, method string) {
+	for _, call := range append([]*mock.Call{}, m.ExpectedCalls...) {
+		if call.Method == method {
+			call.Unset()
+		}
+	}
+}
+
+// expectFeePaymentClaim expects the fee payment of args to be recorded, as new one if claimed.
+func expectFeePaymentClaim(t *testing.T, mockDb sqlmock.Sqlmock, args rpc.PreEncryptedTransaction, claimed bool) {
+	feeTx := new(types.Transaction)
+	require.NoError(t, feeTx.UnmarshalBinary(args.FeePayment))
+	rowsAffected := int64(0)
+	if claimed {
+		rowsAffected = 1
+	}
+	mockDb.ExpectBegin()
+	mockDb.ExpectExec(`INSERT INTO "fee_payments" .* ON CONFLICT DO NOTHING`).
+		WithArgs(feeTx.Hash().String(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, rowsAffected))
+	mockDb.ExpectCommit()
+}
+
+func TestSendEncryptedTransaction(t *testing.T) {
+	service, mockDb := initTest(t)
+	shutter := rpc.NewShutterService(service)
+	client := service.Processor.Client.(*MockEthereumClient)
+	sequencer := service.Processor.SequencerContract.(*MockSequencerContract)
+
+	service.Processor.KeyperSetManagerContract.(*MockKeyperSetManagerContract).
+		On("GetKeyperSetIndexByBlock", mock.Anything, uint64(1)).Return(uint64(3), nil)
+	client.On("SuggestGasPrice", mock.Anything).Return(big.NewInt(1000000000), nil)
+
+	// 50000 gas at twice the suggested gas price
+	value := big.NewInt(100000000000000)
+	args := preEncryptedTransaction(t, service, value)
+	unsetCalls(&client.Mock, "TransactionReceipt")
+	client.On("TransactionReceipt", mock.Anything, mock.Anything).
+		Return(&types.Receipt{Status: types.ReceiptStatusSuccessful}, nil)
+	submitTx := types.NewTx(&types.LegacyTx{Nonce: 7})
+	sequencer.On("SubmitEncryptedTransaction", mock.MatchedBy(func(opts *bind.TransactOpts) bool {
+		return opts.Value.Cmp(value) == 0
+	}), uint64(3), [32]byte(args.IdentityPrefix), []byte(args.EncryptedTx), big.NewInt(50000)).Return(submitTx, nil).Once()
+
+	expectFeePaymentClaim(t, mockDb, args, true)
+	txHash, err := shutter.SendEncryptedTransaction(context.Background(), args)
+	require.NoError(t, err)
+	assert.Equal(t, submitTx.Hash(), *txHash)
+	client.AssertNotCalled(t, "SendTransaction", mock.Anything, mock.Anything)
+
+	details := <-service.Processor.Db.AddTxCh
+	assert.Equal(t, submitTx.Hash().String(), details.TxHash)
+	assert.Equal(t, submitTx.Hash().String(), details.EncryptedTxHash)
+	assert.Equal(t, service.Processor.SigningAddress.String(), details.Address)
+	assert.Equal(t, submitTx.Nonce(), details.Nonce, "the nonce of the sequencer transaction should be recorded")
+	assert.Equal(t, service.Processor.SigningAddress.String(), details.SignerAddress)
+
+	expectFeePaymentClaim(t, mockDb, args, false)
+	_, err = shutter.SendEncryptedTransaction(context.Background(), args)
+	assert.ErrorContains(t, err, "fee payment has been used already")
+	assert.NoError(t, mockDb.ExpectationsWereMet())
+	sequencer.AssertExpectations(t)
+}
+
+func TestSendEncryptedTransaction_ConcurrentFeePayment(t *testing.T) {
+	service, _ := initTest(t)
+	shutter := rpc.NewShutterService(service)
+	client := service.Processor.Client.(*MockEthereumClient)
+
+	service.Processor.KeyperSetManagerContract.(*MockKeyperSetManagerContract).
+		On("GetKeyperSetIndexByBlock", mock.Anything, uint64(1)).Return(uint64(3), nil)
+	client.On("SuggestGasPrice", mock.Anything).Return(big.NewInt(1000000000), nil)
+	looking, proceed := make(chan struct{}), make(chan struct{})
+	unsetCalls(&client.Mock, "TransactionReceipt")
+	client.On("TransactionReceipt", mock.Anything, mock.Anything).Run(func(mock.Arguments) {
+		close(looking)
+		<-proceed
+	}).Return((*types.Receipt)(nil), ethereum.NotFound).Once()
+
+	args := preEncryptedTransaction(t, service, big.NewInt(100000000000000))
+	done := make(chan error)
+	go func() {
+		_, err := shutter.SendEncryptedTransaction(context.Background(), args)
+		done <- err
+	}()
+	<-looking
+	_, err := shutter.SendEncryptedTransaction(context.Background(), args)
+	assert.ErrorContains(t, err, "fee payment is already being used")
+	close(proceed)
+	assert.ErrorContains(t, <-done, "fee payment has not been included yet")
+}
+
+func TestSendEncryptedTransaction_FeePaymentNotIncluded(t *testing.T) {
+	service, mockDb := initTest(t)
+	shutter := rpc.NewShutterService(service)
+	client := service.Processor.Client.(*MockEthereumClient)
+	sequencer := service.Processor.SequencerContract.(*MockSequencerContract)
+	value := big.NewInt(100000000000000)
+
+	service.Processor.KeyperSetManagerContract.(*MockKeyperSetManagerContract).
+		On("GetKeyperSetIndexByBlock", mock.Anything, uint64(1)).Return(uint64(3), nil)
+	client.On("SuggestGasPrice", mock.Anything).Return(big.NewInt(1000000000), nil)
+
+	// the receipts of initTest are failed ones
+	args := preEncryptedTransaction(t, service, value)
+	_, err := shutter.SendEncryptedTransaction(context.Background(), args)
+	assert.ErrorContains(t, err, "fee payment failed")
+
+	unsetCalls(&client.Mock, "TransactionReceipt")
+	client.On("TransactionReceipt", mock.Anything, mock.Anything).Return((*types.Receipt)(nil), ethereum.NotFound)
+	_, err = shutter.SendEncryptedTransaction(context.Background(), args)
+	assert.ErrorContains(t, err, "fee payment has not been included yet")
+
+	client.AssertNotCalled(t, "SendTransaction", mock.Anything, mock.Anything)
+	sequencer.AssertNotCalled(t, "SubmitEncryptedTransaction", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	assert.NoError(t, mockDb.ExpectationsWereMet(), "unpaid fee payments should not be recorded")
+}
+
+func TestSendEncryptedTransaction_RelayFailed(t *testing.T) {
+	service, mockDb := initTest(t)
+	shutter := rpc.NewShutterService(service)
+	client := service.Processor.Client.(*MockEthereumClient)
+	sequencer := service.Processor.SequencerContract.(*MockSequencerContract)
+	value := big.NewInt(100000000000000)
+	cost := big.NewInt(100000000100000)
+	service.Processor.Spending = rpc.NewSpendingLimiter(rpc.SpendingLimits{Daily: cost}, nil)
+
+	service.Processor.KeyperSetManagerContract.(*MockKeyperSetManagerContract).
+		On("GetKeyperSetIndexByBlock", mock.Anything, uint64(1)).Return(uint64(3), nil)
+	client.On("SuggestGasPrice", mock.Anything).Return(big.NewInt(1000000000), nil)
+	unsetCalls(&client.Mock, "TransactionReceipt")
+	client.On("TransactionReceipt", mock.Anything, mock.Anything).
+		Return(&types.Receipt{Status: types.ReceiptStatusSuccessful}, nil)
+	sequencer.On("SubmitEncryptedTransaction", mock.MatchedBy(func(opts *bind.TransactOpts) bool {
+		return opts.NoSend
+	}), mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Return(types.NewTx(&types.DynamicFeeTx{Gas: 100000, GasFeeCap: big.NewInt(1), Value: value}), nil)
+	sequencer.On("SubmitEncryptedTransaction", mock.MatchedBy(func(opts *bind.TransactOpts) bool {
+		return !opts.NoSend
+	}), mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Return((*types.Transaction)(nil), errors.New("upstream unavailable"))
+
+	args := preEncryptedTransaction(t, service, value)
+	expectFeePaymentClaim(t, mockDb, args, true)
+	mockDb.ExpectBegin()
+	mockDb.ExpectExec(`DELETE FROM "fee_payments"`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mockDb.ExpectCommit()
+	_, err := shutter.SendEncryptedTransaction(context.Background(), args)
+	assert.ErrorContains(t, err, "upstream unavailable")
+	assert.NoError(t, mockDb.ExpectationsWereMet(), "the fee payment should be released for a retry")
+
+	_, err = service.Processor.Spending.Reserve(value, cost)
+	assert.NoError(t, err, "the reservation should have been released")
+}
+
+func TestSendEncryptedTransaction_Invalid(t *testing.T) {
+	service, _ := initTest(t)
+	shutter := rpc.NewShutterService(service)
+	client := service.Processor.Client.(*MockEthereumClient)
+
+	service.Processor.KeyperSetManagerContract.(*MockKeyperSetManagerContract).
+		On("GetKeyperSetIndexByBlock", mock.Anything, uint64(1)).Return(uint64(3), nil)
+	client.On("SuggestGasPrice", mock.Anything).Return(big.NewInt(1000000000), nil)
+
+	args := preEncryptedTransaction(t, service, big.NewInt(1))
+	_, err := shutter.SendEncryptedTransaction(context.Background(), args)
+	assert.ErrorContains(t, err, "fee payment of 1 wei is below the required")
+
+	args = preEncryptedTransaction(t, service, big.NewInt(100000000000000))
+	args.Eon = 2
+	_, err = shutter.SendEncryptedTransaction(context.Background(), args)
+	assert.ErrorContains(t, err, "not the current eon")
+
+	args = preEncryptedTransaction(t, service, big.NewInt(100000000000000))
+	args.EncryptedTx = args.EncryptedTx[:10]
+	_, err = shutter.SendEncryptedTransaction(context.Background(), args)
+	assert.ErrorContains(t, err, "invalid encrypted transaction")
+
+	// a transfer to the signing address which is not tied to this relay, like a top-up
+	args = preEncryptedTransaction(t, service, big.NewInt(100000000000000))
+	args.IdentityPrefix = preEncryptedTransaction(t, service, big.NewInt(100000000000000)).IdentityPrefix
+	_, err = shutter.SendEncryptedTransaction(context.Background(), args)
+	assert.ErrorContains(t, err, "fee payment data must be the commitment")
+
+	client.AssertNotCalled(t, "TransactionReceipt", mock.Anything, mock.Anything)
+}
+
+func TestSendEncryptedTransaction_SpendingLimit(t *testing.T) {
+	service, mockDb := initTest(t)
+	shutter := rpc.NewShutterService(service)
+	client := service.Processor.Client.(*MockEthereumClient)
+	sequencer := service.Processor.SequencerContract.(*MockSequencerContract)
+	// the value fits, but not together with the fees of the sequencer transaction
+	value := big.NewInt(100000000000000)
+	service.Processor.Spending = rpc.NewSpendingLimiter(rpc.SpendingLimits{Daily: value}, nil)
+
+	service.Processor.KeyperSetManagerContract.(*MockKeyperSetManagerContract).
+		On("GetKeyperSetIndexByBlock", mock.Anything, uint64(1)).Return(uint64(3), nil)
+	client.On("SuggestGasPrice", mock.Anything).Return(big.NewInt(1000000000), nil)
+	unsetCalls(&client.Mock, "TransactionReceipt")
+	client.On("TransactionReceipt", mock.Anything, mock.Anything).
+		Return(&types.Receipt{Status: types.ReceiptStatusSuccessful}, nil)
+	sequencer.On("SubmitEncryptedTransaction", mock.MatchedBy(func(opts *bind.TransactOpts) bool {
+		return opts.NoSend
+	}), mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Return(types.NewTx(&types.DynamicFeeTx{Gas: 100000, GasFeeCap: big.NewInt(1), Value: value}), nil)
+
+	args := preEncryptedTransaction(t, service, value)
+	expectFeePaymentClaim(t, mockDb, args, true)
+	mockDb.ExpectBegin()
+	mockDb.ExpectExec(`DELETE FROM "fee_payments"`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mockDb.ExpectCommit()
+	_, err := shutter.SendEncryptedTransaction(context.Background(), args)
+	var encodingErr *rpc.EncodingError
+	require.ErrorAs(t, err, &encodingErr)
+	assert.Equal(t, -32005, encodingErr.StatusCode)
+	assert.ErrorContains(t, err, "daily spending limit exceeded")
+	sequencer.AssertNotCalled(t, "SubmitEncryptedTransaction", mock.MatchedBy(func(opts *bind.TransactOpts) bool {
+		return !opts.NoSend
+	}), mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	assert.NoError(t, mockDb.ExpectationsWereMet())
+}
+
+func TestGetCurrentEon(t *testing.T) {
+	service, _ := initTest(t)
+	service.Processor.KeyperSetChangeLookAhead = 10
+	shutter := rpc.NewShutterService(service)
+	keyperSetManager := service.Processor.KeyperSetManagerContract.(*MockKeyperSetManagerContract)
+	keyBroadcast := service.Processor.KeyBroadcastContract.(*MockKeyBroadcastContract)
+
+	// the latest block is 1, the eon is looked up at 11
+	keyperSetManager.On("GetKeyperSetIndexByBlock", mock.Anything, uint64(11)).Return(uint64(3), nil)
+	keyperSetManager.On("GetKeyperSetActivationBlock", mock.Anything, uint64(3)).Return(uint64(5), nil)
+	keyperSetManager.On("GetKeyperSetActivationBlock", mock.Anything, uint64(4)).Return(uint64(20), nil)
+	keyperSetManager.On("GetNumKeyperSets", mock.Anything).Return(uint64(5), nil)
+	keyBroadcast.On("GetEonKey", mock.Anything, uint64(3)).Return([]byte{1, 2, 3}, nil)
+	keyBroadcast.On("GetEonKey", mock.Anything, uint64(4)).Return([]byte{}, nil)
+
+	current, err := shutter.GetCurrentEon(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, hexutil.Uint64(3), current.Eon.Eon)
+	assert.Equal(t, hexutil.Uint64(5), current.ActivationBlock)
+	assert.Equal(t, hexutil.Bytes{1, 2, 3}, current.EonKey)
+	assert.Equal(t, hexutil.Uint64(1), current.BlockNumber)
+	assert.Equal(t, hexutil.Uint64(10), current.KeyperSetChangeLookAhead)
+	assert.Equal(t, *service.Processor.SigningAddress, current.SigningAddress)
+	assert.Equal(t, []common.Address{*service.Processor.SigningAddress}, current.SigningAddresses)
+	require.NotNil(t, current.Next, "the next keyper set should be reported")
+	assert.Equal(t, hexutil.Uint64(4), current.Next.Eon)
+	assert.Equal(t, hexutil.Uint64(20), current.Next.ActivationBlock)
+	assert.Empty(t, current.Next.EonKey)
+}
+
+func TestGetEonKey(t *testing.T) {
+	service, _ := initTest(t)
+	shutter := rpc.NewShutterService(service)
+	keyperSetManager := service.Processor.KeyperSetManagerContract.(*MockKeyperSetManagerContract)
+
+	keyperSetManager.On("GetNumKeyperSets", mock.Anything).Return(uint64(2), nil)
+	keyperSetManager.On("GetKeyperSetActivationBlock", mock.Anything, uint64(1)).Return(uint64(100), nil)
+	service.Processor.KeyBroadcastContract.(*MockKeyBroadcastContract).
+		On("GetEonKey", mock.Anything, uint64(1)).Return([]byte{1, 2, 3}, nil)
+
+	eon, err := shutter.GetEonKey(context.Background(), 1)
+	require.NoError(t, err)
+	assert.Equal(t, &rpc.Eon{Eon: 1, ActivationBlock: 100, EonKey: hexutil.Bytes{1, 2, 3}}, eon)
+
+	_, err = shutter.GetEonKey(context.Background(), 2)
+	assert.ErrorContains(t, err, "eon 2 does not exist")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	keyperSetManager.ExpectedCalls = nil
+	keyperSetManager.On("GetNumKeyperSets", mock.MatchedBy(func(opts *bind.CallOpts) bool {
+		return opts != nil && opts.Context == ctx
+	})).Return(uint64(0), context.Canceled)
+	_, err = shutter.GetEonKey(ctx, 1)
+	assert.ErrorContains(t, err, "context canceled", "the contract calls should be bound to the request context")
+}
+
+func TestGetEonKey_Cached(t *testing.T) {
+	service, _ := initTest(t)
+	shutter := rpc.NewShutterService(service)
+	keyperSetManager := service.Processor.KeyperSetManagerContract.(*MockKeyperSetManagerContract)
+	keyBroadcast := service.Processor.KeyBroadcastContract.(*MockKeyBroadcastContract)
+	service.Processor.EonKeys = rpc.NewEonKeyCache(keyperSetManager, keyBroadcast)
+
+	keyperSetManager.On("GetNumKeyperSets", mock.Anything).Return(uint64(2), nil)
+	keyperSetManager.On("GetKeyperSetActivationBlock", mock.Anything, uint64(0)).Return(uint64(0), nil).Once()
+	keyperSetManager.On("GetKeyperSetActivationBlock", mock.Anything, uint64(1)).Return(uint64(100), nil).Once()
+	keyBroadcast.On("GetEonKey", mock.Anything, uint64(0)).Return(test.TestEonKey.Marshal(), nil).Once()
+	keyBroadcast.On("GetEonKey", mock.Anything, uint64(1)).Return([]byte{}, nil)
+
+	eon, err := shutter.GetEonKey(context.Background(), 0)
+	require.NoError(t, err)
+	assert.Equal(t, hexutil.Bytes(test.TestEonKey.Marshal()), eon.EonKey)
+	eon, err = shutter.GetEonKey(context.Background(), 0)
+	require.NoError(t, err, "the eon should be served from the cache")
+	assert.Equal(t, hexutil.Bytes(test.TestEonKey.Marshal()), eon.EonKey)
+
+	eon, err = shutter.GetEonKey(context.Background(), 1)
+	require.NoError(t, err)
+	assert.Equal(t, &rpc.Eon{Eon: 1, ActivationBlock: 100, EonKey: hexutil.Bytes{}}, eon, "the key should be empty until it is broadcast")
+
+	_, err = shutter.GetEonKey(context.Background(), 2)
+	assert.ErrorContains(t, err, "eon 2 does not exist")
+	keyperSetManager.AssertExpectations(t)
+}
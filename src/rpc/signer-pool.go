@@ -0,0 +1,145 @@
+package rpc
+
+import (
+	"context"
+	"math/big"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Signer is a signing address which submits encrypted transactions to the sequencer contract, with
+// its own nonces. Its balance and the nonce of its latest mined transaction are updated by Refresh.
+type Signer struct {
+	TxSigner TransactionSigner
+	Address  common.Address
+	Nonces   *NonceManager
+
+	mu          sync.Mutex
+	balance     *big.Int // nil until the first refresh
+	minedNonce  uint64
+	submissions uint64 // submissions since the last refresh, in case the nonce manager is not used
+}
+
+func NewSigner(client EthereumClient, txSigner TransactionSigner) *Signer {
+	address := txSigner.Address()
+	return &Signer{
+		TxSigner: txSigner,
+		Address:  address,
+		Nonces:   NewNonceManager(client, address),
+	}
+}
+
+// Refresh loads the balance and the nonce of the latest mined transaction of the signer.
+func (s *Signer) Refresh(ctx context.Context, client EthereumClient) error {
+	balance, err := client.BalanceAt(ctx, s.Address, nil)
+	if err != nil {
+		return err
+	}
+	minedNonce, err := client.NonceAt(ctx, s.Address, nil)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.balance = balance
+	s.minedNonce = minedNonce
+	s.submissions = 0
+	return nil
+}
+
+// Balance returns the balance of the last refresh, nil if there was none.
+func (s *Signer) Balance() *big.Int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.balance
+}
+
+// Pending returns the number of transactions of the signer which are not mined yet, as far as
+// known since the last refresh.
+func (s *Signer) Pending() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Nonces != nil {
+		if issued := s.Nonces.Issued(); issued > s.minedNonce {
+			return issued - s.minedNonce
+		}
+		return 0
+	}
+	return s.submissions
+}
+
+// submitted records a submission of value wei.
+func (s *Signer) submitted(value *big.Int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.submissions++
+	if s.balance != nil && value != nil {
+		s.balance = new(big.Int).Sub(s.balance, value)
+	}
+}
+
+// SignerPool spreads the submissions to the sequencer contract across several signing keys, so
+// that a single account's nonces do not limit throughput and a stuck transaction only blocks the
+// submissions of its own key.
+type SignerPool struct {
+	signers []*Signer
+
+	mu   sync.Mutex
+	next int // the signer after the last picked one, where the next round robin starts
+}
+
+func NewSignerPool(signers ...*Signer) *SignerPool {
+	return &SignerPool{signers: signers}
+}
+
+// Signers returns all signers of the pool, the primary one first.
+func (p *SignerPool) Signers() []*Signer {
+	return p.signers
+}
+
+// Addresses returns the addresses of all signers of the pool.
+func (p *SignerPool) Addresses() []common.Address {
+	addresses := make([]common.Address, len(p.signers))
+	for i, signer := range p.signers {
+		addresses[i] = signer.Address
+	}
+	return addresses
+}
+
+// ByAddress returns the signer of address.
+func (p *SignerPool) ByAddress(address common.Address) (*Signer, bool) {
+	for _, signer := range p.signers {
+		if signer.Address == address {
+			return signer, true
+		}
+	}
+	return nil, false
+}
+
+// Pick returns the signer with the fewest pending transactions among those whose known balance
+// covers value, taking turns between signers with the same number. If no balance covers value, all
+// signers are considered, since balances are only known as of the last refresh.
+func (p *SignerPool) Pick(value *big.Int) *Signer {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var (
+		picked        int
+		pickedPending uint64
+		pickedFunded  bool
+	)
+	for i := range p.signers {
+		index := (p.next + i) % len(p.signers)
+		signer := p.signers[index]
+		balance := signer.Balance()
+		funded := balance == nil || value == nil || balance.Cmp(value) >= 0
+		pending := signer.Pending()
+		if i == 0 || (funded && !pickedFunded) || (funded == pickedFunded && pending < pickedPending) {
+			picked, pickedPending, pickedFunded = index, pending, funded
+		}
+	}
+	p.next = (picked + 1) % len(p.signers)
+	return p.signers[picked]
+}
@@ -0,0 +1,52 @@
+package rpc_test
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/shutter-network/encrypting-rpc-server/rpc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSigner(t *testing.T, client *MockEthereumClient, balance int64) *rpc.Signer {
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	signer := rpc.NewSigner(client, rpc.NewKeySigner(key))
+	client.On("BalanceAt", mock.Anything, signer.Address, mock.Anything).Return(big.NewInt(balance), nil)
+	client.On("NonceAt", mock.Anything, signer.Address, mock.Anything).Return(uint64(0), nil)
+	client.On("PendingNonceAt", mock.Anything, signer.Address).Return(uint64(0), nil)
+	require.NoError(t, signer.Refresh(context.Background(), client))
+	return signer
+}
+
+func TestSignerPool_Pick(t *testing.T) {
+	client := new(MockEthereumClient)
+	first := newTestSigner(t, client, 10)
+	second := newTestSigner(t, client, 10)
+	empty := newTestSigner(t, client, 0)
+	pool := rpc.NewSignerPool(first, second, empty)
+
+	picked := map[*rpc.Signer]int{}
+	for i := 0; i < 4; i++ {
+		picked[pool.Pick(big.NewInt(5))]++
+	}
+	assert.Equal(t, map[*rpc.Signer]int{first: 2, second: 2}, picked, "funded signers take turns")
+
+	_, err := first.Nonces.Next(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), first.Pending())
+	for i := 0; i < 3; i++ {
+		assert.Same(t, second, pool.Pick(big.NewInt(5)), "the signer with fewer pending transactions is picked")
+	}
+
+	assert.NotNil(t, pool.Pick(big.NewInt(100)), "a signer is picked even if no balance is known to suffice")
+
+	signer, ok := pool.ByAddress(empty.Address)
+	assert.True(t, ok)
+	assert.Same(t, empty, signer)
+	assert.Equal(t, first.Address, pool.Addresses()[0])
+}
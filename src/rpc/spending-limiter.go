@@ -0,0 +1,239 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/shutter-network/encrypting-rpc-server/db"
+	"github.com/shutter-network/encrypting-rpc-server/metrics"
+	"github.com/shutter-network/encrypting-rpc-server/utils"
+)
+
+// SpendingLimits are the budgets of the signing accounts in wei. Nil means unlimited.
+type SpendingLimits struct {
+	Hourly *big.Int
+	Daily  *big.Int
+	// MaxTransactionValue caps the value forwarded to the sequencer for a single transaction.
+	MaxTransactionValue *big.Int
+}
+
+// Spend is an amount spent, or reserved to be spent, by the signing accounts.
+type Spend struct {
+	time   time.Time
+	amount *big.Int
+	signer common.Address
+	txHash common.Hash // zero while reserved
+}
+
+// SpendingLimiter keeps what the signing accounts spent on submissions within the budgets. Before a
+// submission its worst-case cost, the value plus the gas limit times the max fee per gas, is
+// reserved, so that concurrent submissions can not overrun a budget together, and afterwards the
+// reservation is replaced by the cost of the transaction actually sent. Once the submission is
+// mined, this worst case is reconciled with what it actually cost. Spendings are recorded in the
+// database and loaded again on start.
+type SpendingLimiter struct {
+	limits   SpendingLimits
+	database *db.PostgresDb
+
+	mu        sync.Mutex
+	spendings []*Spend // of the last day, oldest first
+}
+
+func NewSpendingLimiter(limits SpendingLimits, database *db.PostgresDb) *SpendingLimiter {
+	return &SpendingLimiter{limits: limits, database: database}
+}
+
+// Load reads the spendings of the last day from the database.
+func (l *SpendingLimiter) Load() error {
+	records, err := l.database.GetSpendingsSince(time.Now().Add(-24 * time.Hour))
+	if err != nil {
+		return err
+	}
+	spendings := make([]*Spend, 0, len(records))
+	for _, record := range records {
+		amount, ok := new(big.Int).SetString(record.Amount, 10)
+		if !ok {
+			return fmt.Errorf("invalid spending amount %q of %s", record.Amount, record.TxHash)
+		}
+		spendings = append(spendings, &Spend{
+			time:   record.CreatedAt,
+			amount: amount,
+			signer: common.HexToAddress(record.SignerAddress),
+			txHash: common.HexToHash(record.TxHash),
+		})
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.spendings = append(spendings, l.spendings...)
+	l.updateMetrics(time.Now())
+	return nil
+}
+
+// Run drops spendings older than a day and updates the remaining budget metrics every interval
+// until ctx is done.
+func (l *SpendingLimiter) Run(ctx context.Context, interval time.Duration) {
+	timer := time.NewTicker(interval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			l.mu.Lock()
+			l.updateMetrics(time.Now())
+			l.mu.Unlock()
+		}
+	}
+}
+
+// Reserve reserves cost, the worst-case cost of a submission which forwards value to the sequencer,
+// if it fits into the budgets. The reservation has to be passed to Settle or Release.
+func (l *SpendingLimiter) Reserve(value *big.Int, cost *big.Int) (*Spend, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if err := l.check(value, cost, now); err != nil {
+		return nil, err
+	}
+
+	reservation := &Spend{time: now, amount: new(big.Int).Set(cost)}
+	l.spendings = append(l.spendings, reservation)
+	l.updateMetrics(now)
+	return reservation, nil
+}
+
+// Settle replaces the reserved amount with the cost of the submission txHash of signer and records
+// it in the database.
+func (l *SpendingLimiter) Settle(reservation *Spend, cost *big.Int, signer common.Address, txHash common.Hash) {
+	l.mu.Lock()
+	reservation.amount = new(big.Int).Set(cost)
+	reservation.signer = signer
+	reservation.txHash = txHash
+	l.updateMetrics(time.Now())
+	l.mu.Unlock()
+
+	if l.database != nil {
+		l.database.InsertSpending(db.Spending{
+			SignerAddress: signer.String(),
+			TxHash:        txHash.String(),
+			Amount:        cost.String(),
+			CreatedAt:     reservation.time,
+		})
+	}
+}
+
+// Reconcile replaces what has been charged for txHashes, a submission and its replacements of
+// which one has been mined, with cost, what the mined one actually cost. The whole cost is
+// charged to the first of them and the others are charged nothing, as only one has been paid for.
+func (l *SpendingLimiter) Reconcile(txHashes []common.Hash, cost *big.Int) {
+	isSubmission := make(map[common.Hash]bool, len(txHashes))
+	for _, txHash := range txHashes {
+		isSubmission[txHash] = true
+	}
+
+	l.mu.Lock()
+	var reconciled []db.Spending
+	for _, s := range l.spendings {
+		if !isSubmission[s.txHash] {
+			continue
+		}
+		if len(reconciled) == 0 {
+			s.amount = new(big.Int).Set(cost)
+		} else {
+			s.amount = new(big.Int)
+		}
+		reconciled = append(reconciled, db.Spending{
+			SignerAddress: s.signer.String(),
+			TxHash:        s.txHash.String(),
+			Amount:        s.amount.String(),
+			CreatedAt:     s.time,
+		})
+	}
+	l.updateMetrics(time.Now())
+	l.mu.Unlock()
+
+	if l.database == nil {
+		return
+	}
+	for _, spending := range reconciled {
+		if err := l.database.ReconcileSpending(spending); err != nil {
+			utils.Logger.Error().Err(err).Str("tx-hash", spending.TxHash).Msg("Failed to reconcile spending")
+		}
+	}
+}
+
+// Release gives back the reservation of a submission which failed.
+func (l *SpendingLimiter) Release(reservation *Spend) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i, s := range l.spendings {
+		if s == reservation {
+			l.spendings = append(l.spendings[:i], l.spendings[i+1:]...)
+			break
+		}
+	}
+	l.updateMetrics(time.Now())
+}
+
+// check must be called with mu held.
+func (l *SpendingLimiter) check(value *big.Int, cost *big.Int, now time.Time) error {
+	if l.limits.MaxTransactionValue != nil && value.Cmp(l.limits.MaxTransactionValue) > 0 {
+		return returnError(-32000, fmt.Errorf("value of %s wei for the sequencer exceeds the maximum of %s wei per transaction", value, l.limits.MaxTransactionValue))
+	}
+	hourly, daily := l.remaining(now)
+	if hourly != nil && cost.Cmp(hourly) > 0 {
+		return returnError(-32005, fmt.Errorf("hourly spending limit exceeded, %s wei of %s wei left", hourly, l.limits.Hourly))
+	}
+	if daily != nil && cost.Cmp(daily) > 0 {
+		return returnError(-32005, fmt.Errorf("daily spending limit exceeded, %s wei of %s wei left", daily, l.limits.Daily))
+	}
+	return nil
+}
+
+// remaining drops spendings older than a day and returns what is left of the budgets at now, nil
+// for unlimited ones. Must be called with mu held.
+func (l *SpendingLimiter) remaining(now time.Time) (hourly *big.Int, daily *big.Int) {
+	dayAgo, hourAgo := now.Add(-24*time.Hour), now.Add(-time.Hour)
+	first := 0
+	for first < len(l.spendings) && !l.spendings[first].time.After(dayAgo) {
+		first++
+	}
+	l.spendings = l.spendings[first:]
+
+	spentHour, spentDay := new(big.Int), new(big.Int)
+	for _, s := range l.spendings {
+		spentDay.Add(spentDay, s.amount)
+		if s.time.After(hourAgo) {
+			spentHour.Add(spentHour, s.amount)
+		}
+	}
+	return leftOf(l.limits.Hourly, spentHour), leftOf(l.limits.Daily, spentDay)
+}
+
+// updateMetrics must be called with mu held.
+func (l *SpendingLimiter) updateMetrics(now time.Time) {
+	hourly, daily := l.remaining(now)
+	if hourly != nil {
+		metrics.SpendingBudgetRemaining.WithLabelValues("hourly").Set(toEther(hourly))
+	}
+	if daily != nil {
+		metrics.SpendingBudgetRemaining.WithLabelValues("daily").Set(toEther(daily))
+	}
+}
+
+func leftOf(limit *big.Int, spent *big.Int) *big.Int {
+	if limit == nil {
+		return nil
+	}
+	left := new(big.Int).Sub(limit, spent)
+	if left.Sign() < 0 {
+		return new(big.Int)
+	}
+	return left
+}
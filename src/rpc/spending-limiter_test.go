@@ -0,0 +1,92 @@
+package rpc_test
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/shutter-network/encrypting-rpc-server/metrics"
+	"github.com/shutter-network/encrypting-rpc-server/rpc"
+	"github.com/shutter-network/encrypting-rpc-server/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSpendingLimiter(t *testing.T) {
+	mockDb, database := test.NewPostgresTestDB(t)
+	mockDb.ExpectQuery(`SELECT \* FROM "spendings" WHERE created_at > \$1 ORDER BY created_at`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "signer_address", "tx_hash", "amount", "created_at"}).
+			AddRow(1, "0x01", "0x02", "40", time.Now().Add(-2*time.Hour)).
+			AddRow(2, "0x01", "0x03", "30", time.Now().Add(-time.Minute)))
+
+	limiter := rpc.NewSpendingLimiter(rpc.SpendingLimits{
+		Hourly:              big.NewInt(50),
+		Daily:               big.NewInt(100),
+		MaxTransactionValue: big.NewInt(25),
+	}, database)
+	require.NoError(t, limiter.Load())
+	assert.NoError(t, mockDb.ExpectationsWereMet())
+	assert.Equal(t, 20e-18, testutil.ToFloat64(metrics.SpendingBudgetRemaining.WithLabelValues("hourly")))
+	assert.Equal(t, 30e-18, testutil.ToFloat64(metrics.SpendingBudgetRemaining.WithLabelValues("daily")))
+
+	_, err := limiter.Reserve(big.NewInt(26), big.NewInt(26))
+	var encodingErr *rpc.EncodingError
+	require.ErrorAs(t, err, &encodingErr)
+	assert.Equal(t, -32000, encodingErr.StatusCode)
+	assert.ErrorContains(t, err, "exceeds the maximum")
+	_, err = limiter.Reserve(big.NewInt(20), big.NewInt(21))
+	assert.ErrorContains(t, err, "hourly spending limit exceeded, 20 wei of 50 wei left", "the fees count towards the budgets")
+
+	reservation, err := limiter.Reserve(big.NewInt(15), big.NewInt(15))
+	require.NoError(t, err)
+	_, err = limiter.Reserve(big.NewInt(10), big.NewInt(10))
+	require.ErrorAs(t, err, &encodingErr)
+	assert.Equal(t, -32005, encodingErr.StatusCode)
+	assert.ErrorContains(t, err, "hourly spending limit exceeded, 5 wei of 50 wei left")
+
+	limiter.Release(reservation)
+	reservation, err = limiter.Reserve(big.NewInt(20), big.NewInt(20))
+	require.NoError(t, err, "released reservations do not count")
+	limiter.Release(reservation)
+
+	reservation, err = limiter.Reserve(big.NewInt(10), big.NewInt(10))
+	require.NoError(t, err)
+	txHash := common.HexToHash("0x04")
+	limiter.Settle(reservation, big.NewInt(12), common.HexToAddress("0x01"), txHash)
+	spending := <-database.SpendingCh
+	assert.Equal(t, "12", spending.Amount)
+	assert.Equal(t, txHash.String(), spending.TxHash)
+	_, err = limiter.Reserve(big.NewInt(9), big.NewInt(9))
+	assert.ErrorContains(t, err, "hourly spending limit exceeded, 8 wei of 50 wei left")
+}
+
+func TestSpendingLimiter_Reconcile(t *testing.T) {
+	mockDb, database := test.NewPostgresTestDB(t)
+	limiter := rpc.NewSpendingLimiter(rpc.SpendingLimits{Hourly: big.NewInt(100)}, database)
+	original := common.HexToHash("0x01")
+	replacement := common.HexToHash("0x02")
+	reservation, err := limiter.Reserve(big.NewInt(10), big.NewInt(60))
+	require.NoError(t, err)
+	limiter.Settle(reservation, big.NewInt(60), common.HexToAddress("0x03"), original)
+	<-database.SpendingCh
+	reservation, err = limiter.Reserve(big.NewInt(0), big.NewInt(30))
+	require.NoError(t, err)
+	limiter.Settle(reservation, big.NewInt(30), common.HexToAddress("0x03"), replacement)
+	<-database.SpendingCh
+
+	// the queued inserts have not been written, so the reconciled amounts are upserted
+	for _, expected := range []struct{ txHash, amount string }{{original.String(), "25"}, {replacement.String(), "0"}} {
+		mockDb.ExpectBegin()
+		mockDb.ExpectQuery(`INSERT INTO "spendings" .* ON CONFLICT \("tx_hash"\) DO UPDATE SET "amount"="excluded"."amount"`).
+			WithArgs(common.HexToAddress("0x03").String(), expected.txHash, expected.amount, sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mockDb.ExpectCommit()
+	}
+	limiter.Reconcile([]common.Hash{original, replacement}, big.NewInt(25))
+	assert.NoError(t, mockDb.ExpectationsWereMet())
+	_, err = limiter.Reserve(big.NewInt(0), big.NewInt(76))
+	assert.ErrorContains(t, err, "hourly spending limit exceeded, 75 wei of 100 wei left", "only the actual cost counts")
+}
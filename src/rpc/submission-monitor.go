@@ -0,0 +1,369 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	txtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/shutter-network/encrypting-rpc-server/db"
+	"github.com/shutter-network/encrypting-rpc-server/metrics"
+	"github.com/shutter-network/encrypting-rpc-server/utils"
+)
+
+// trackedSubmission is only modified by Check, after Track added it, while holding the lock of
+// the monitor.
+type trackedSubmission struct {
+	signer    *Signer
+	tx        *txtypes.Transaction // the latest replacement
+	original  common.Hash
+	hashes    []common.Hash // of the original and the replacements, oldest first
+	sentBlock uint64        // 0 until the first check after sending
+	saved     bool          // whether the database has the current state
+}
+
+// SubmissionMonitor watches the transactions of the signers to the sequencer contract. A
+// transaction which is not mined stuckBlocks blocks after it was sent is replaced with one with the
+// same nonce and fees bumped by the fee strategy. Replacements are recorded in the database and
+// charged to the spending budgets with the increase of their worst-case cost, as only one of the
+// transactions with the nonce can be mined. Once mined, the spending of a submission is reconciled
+// with what it actually cost. With stuckBlocks 0 submissions are never replaced. The watched
+// submissions are saved in the database by
+// every check which changed them and on shutdown, and resumed on start.
+type SubmissionMonitor struct {
+	client      EthereumClient
+	signers     *SignerPool
+	fees        *FeeStrategy
+	database    *db.PostgresDb
+	spending    *SpendingLimiter
+	stuckBlocks uint64
+
+	mu          sync.Mutex
+	submissions map[common.Hash]*trackedSubmission // by original hash
+}
+
+func NewSubmissionMonitor(processor Processor, stuckBlocks uint64) *SubmissionMonitor {
+	fees := processor.Fees
+	if fees == nil {
+		fees = &FeeStrategy{}
+	}
+	return &SubmissionMonitor{
+		client:      processor.Client,
+		signers:     processor.Signers,
+		fees:        fees,
+		database:    processor.Db,
+		spending:    processor.Spending,
+		stuckBlocks: stuckBlocks,
+		submissions: make(map[common.Hash]*trackedSubmission),
+	}
+}
+
+// Track starts watching tx, which signer has just sent.
+func (m *SubmissionMonitor) Track(signer *Signer, tx *txtypes.Transaction) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.submissions[tx.Hash()] = &trackedSubmission{signer: signer, tx: tx, original: tx.Hash(), hashes: []common.Hash{tx.Hash()}}
+}
+
+// Save persists the submissions which are still watched, so that Resume picks them up on the next
+// start.
+func (m *SubmissionMonitor) Save() error {
+	count, err := m.save(true)
+	if err != nil {
+		return err
+	}
+	utils.Logger.Info().Int("count", count).Msg("Persisted watched submissions")
+	return nil
+}
+
+// save persists all watched submissions, or only the ones which changed since they were saved
+// last, and returns how many it saved.
+func (m *SubmissionMonitor) save(all bool) (int, error) {
+	m.mu.Lock()
+	var saved []*trackedSubmission
+	submissions := make([]db.TrackedSubmission, 0, len(m.submissions))
+	for _, submission := range m.submissions {
+		if submission.saved && !all {
+			continue
+		}
+		rawTx, err := submission.tx.MarshalBinary()
+		if err != nil {
+			m.mu.Unlock()
+			return 0, err
+		}
+		submissions = append(submissions, db.TrackedSubmission{
+			OriginalTxHash: submission.original.String(),
+			SignerAddress:  submission.signer.Address.String(),
+			RawTx:          rawTx,
+			SentBlock:      submission.sentBlock,
+		})
+		submission.saved = true
+		saved = append(saved, submission)
+	}
+	m.mu.Unlock()
+
+	if err := m.database.SaveTrackedSubmissions(submissions); err != nil {
+		m.mu.Lock()
+		for _, submission := range saved {
+			submission.saved = false
+		}
+		m.mu.Unlock()
+		return 0, err
+	}
+	return len(submissions), nil
+}
+
+// Resume watches the submissions again which were still watched when the server stopped.
+// Submissions of signers which are no longer configured are dropped.
+func (m *SubmissionMonitor) Resume() error {
+	saved, err := m.database.GetTrackedSubmissions()
+	if err != nil {
+		return err
+	}
+
+	var dropped []string
+	m.mu.Lock()
+	for _, submission := range saved {
+		var signer *Signer
+		if m.signers != nil {
+			signer, _ = m.signers.ByAddress(common.HexToAddress(submission.SignerAddress))
+		}
+		if signer == nil {
+			utils.Logger.Warn().Str("signer", submission.SignerAddress).Str("tx-hash", submission.OriginalTxHash).
+				Msg("Dropping watched submission of unknown signer")
+			dropped = append(dropped, submission.OriginalTxHash)
+			continue
+		}
+		tx := new(txtypes.Transaction)
+		if err := tx.UnmarshalBinary(submission.RawTx); err != nil {
+			utils.Logger.Warn().Err(err).Str("tx-hash", submission.OriginalTxHash).Msg("Dropping invalid watched submission")
+			dropped = append(dropped, submission.OriginalTxHash)
+			continue
+		}
+		original := common.HexToHash(submission.OriginalTxHash)
+		// replacements in between are not saved, their spending stays the worst case
+		hashes := []common.Hash{original}
+		if tx.Hash() != original {
+			hashes = append(hashes, tx.Hash())
+		}
+		m.submissions[original] = &trackedSubmission{
+			signer:    signer,
+			tx:        tx,
+			original:  original,
+			hashes:    hashes,
+			sentBlock: submission.SentBlock,
+			saved:     true,
+		}
+	}
+	m.mu.Unlock()
+
+	if len(saved) > 0 {
+		utils.Logger.Info().Int("count", len(saved)-len(dropped)).Msg("Resumed watching submissions")
+	}
+	return m.database.DeleteTrackedSubmissions(dropped)
+}
+
+// Run checks the submissions every interval until ctx is done.
+func (m *SubmissionMonitor) Run(ctx context.Context, interval time.Duration) {
+	timer := time.NewTicker(interval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			m.Check(ctx)
+		}
+	}
+}
+
+// Check stops watching mined submissions and replaces the stuck ones. The changes are saved in the
+// database.
+func (m *SubmissionMonitor) Check(ctx context.Context) {
+	m.mu.Lock()
+	submissions := make([]*trackedSubmission, 0, len(m.submissions))
+	for _, submission := range m.submissions {
+		submissions = append(submissions, submission)
+	}
+	m.mu.Unlock()
+	if len(submissions) == 0 {
+		return
+	}
+
+	blockNumber, err := m.client.BlockNumber(ctx)
+	if err != nil {
+		utils.Logger.Error().Err(err).Msg("failed to get block number for submission monitor")
+		return
+	}
+	var mined []string
+	defer func() {
+		m.persist(mined)
+	}()
+	minedNonces := make(map[common.Address]uint64)
+	for _, submission := range submissions {
+		address := submission.signer.Address
+		minedNonce, ok := minedNonces[address]
+		if !ok {
+			minedNonce, err = m.client.NonceAt(ctx, address, nil)
+			if err != nil {
+				utils.Logger.Error().Err(err).Str("signer", address.Hex()).Msg("failed to get nonce for submission monitor")
+				continue
+			}
+			minedNonces[address] = minedNonce
+		}
+
+		if submission.tx.Nonce() < minedNonce {
+			// the transaction or one of its replacements has been mined
+			m.mu.Lock()
+			delete(m.submissions, submission.original)
+			m.mu.Unlock()
+			mined = append(mined, submission.original.String())
+			m.reconcile(ctx, submission)
+			continue
+		}
+		if submission.sentBlock == 0 {
+			m.mu.Lock()
+			submission.sentBlock = blockNumber
+			submission.saved = false
+			m.mu.Unlock()
+			continue
+		}
+		if m.stuckBlocks == 0 || blockNumber-submission.sentBlock < m.stuckBlocks {
+			continue
+		}
+		if err := m.replace(ctx, submission, blockNumber); err != nil {
+			utils.Logger.Warn().Err(err).
+				Hex("tx-hash", submission.tx.Hash().Bytes()).
+				Uint64("nonce", submission.tx.Nonce()).
+				Str("signer", address.Hex()).
+				Msg("Failed to replace stuck submission")
+		}
+	}
+}
+
+// reconcile charges the spending budgets with what the mined transaction of submission actually
+// cost, the gas used times the effective gas price plus the value, instead of the worst case. If
+// none of its transactions has a receipt, another transaction took the nonce, and the worst case
+// is kept.
+func (m *SubmissionMonitor) reconcile(ctx context.Context, submission *trackedSubmission) {
+	if m.spending == nil {
+		return
+	}
+	for i := len(submission.hashes) - 1; i >= 0; i-- {
+		receipt, err := m.client.TransactionReceipt(ctx, submission.hashes[i])
+		if errors.Is(err, ethereum.NotFound) {
+			continue
+		}
+		if err != nil {
+			utils.Logger.Warn().Err(err).Hex("tx-hash", submission.hashes[i].Bytes()).Msg("Failed to get receipt to reconcile spending")
+			return
+		}
+		if receipt.EffectiveGasPrice == nil {
+			return
+		}
+		cost := new(big.Int).Mul(receipt.EffectiveGasPrice, new(big.Int).SetUint64(receipt.GasUsed))
+		cost.Add(cost, submission.tx.Value())
+		m.spending.Reconcile(submission.hashes, cost)
+		return
+	}
+}
+
+// persist removes the mined submissions from the database and saves the ones which changed.
+func (m *SubmissionMonitor) persist(mined []string) {
+	if m.database == nil {
+		return
+	}
+	if err := m.database.DeleteTrackedSubmissions(mined); err != nil {
+		utils.Logger.Error().Err(err).Msg("failed to remove mined submissions")
+	}
+	if _, err := m.save(false); err != nil {
+		utils.Logger.Error().Err(err).Msg("failed to save watched submissions")
+	}
+}
+
+// replace sends submission again with bumped fees, at least the ones a new transaction would get.
+func (m *SubmissionMonitor) replace(ctx context.Context, submission *trackedSubmission, blockNumber uint64) error {
+	tx := submission.tx
+	tip, feeCap, ok := m.fees.Bump(tx.GasTipCap(), tx.GasFeeCap())
+	if !ok {
+		return errors.New("the fee caps leave no room for a replacement")
+	}
+	if currentTip, currentFeeCap, err := m.fees.Fees(ctx, m.client); err == nil {
+		if currentTip.Cmp(tip) > 0 {
+			tip = currentTip
+		}
+		if currentFeeCap.Cmp(feeCap) > 0 {
+			feeCap = currentFeeCap
+		}
+	}
+
+	chainID, err := m.client.ChainID(ctx)
+	if err != nil {
+		return err
+	}
+	unsigned := txtypes.NewTx(&txtypes.DynamicFeeTx{
+		ChainID:   chainID,
+		Nonce:     tx.Nonce(),
+		GasTipCap: tip,
+		GasFeeCap: feeCap,
+		Gas:       tx.Gas(),
+		To:        tx.To(),
+		Value:     tx.Value(),
+		Data:      tx.Data(),
+	})
+	var reservation *Spend
+	extraCost := new(big.Int).Sub(unsigned.Cost(), tx.Cost())
+	if m.spending != nil && extraCost.Sign() > 0 {
+		reservation, err = m.spending.Reserve(tx.Value(), extraCost)
+		if err != nil {
+			return err
+		}
+	}
+	replacement, err := submission.signer.TxSigner.SignTx(ctx, unsigned, chainID)
+	if err == nil {
+		err = m.client.SendTransaction(ctx, replacement)
+	}
+	if err != nil {
+		if reservation != nil {
+			m.spending.Release(reservation)
+		}
+		return err
+	}
+	if reservation != nil {
+		m.spending.Settle(reservation, extraCost, submission.signer.Address, replacement.Hash())
+	}
+
+	m.mu.Lock()
+	submission.tx = replacement
+	submission.hashes = append(submission.hashes, replacement.Hash())
+	submission.sentBlock = blockNumber
+	submission.saved = false
+	m.mu.Unlock()
+
+	metrics.SubmissionReplacements.Inc()
+	utils.Logger.Info().
+		Hex("original-tx-hash", submission.original.Bytes()).
+		Hex("tx-hash", replacement.Hash().Bytes()).
+		Uint64("nonce", replacement.Nonce()).
+		Str("tip", tip.String()).
+		Str("fee-cap", feeCap.String()).
+		Msg("Replaced stuck submission")
+	if m.database != nil {
+		if err := m.database.InsertReplacement(db.SubmissionReplacement{
+			OriginalTxHash: submission.original.String(),
+			TxHash:         replacement.Hash().String(),
+			SignerAddress:  submission.signer.Address.String(),
+			Nonce:          replacement.Nonce(),
+			GasTipCap:      tip.String(),
+			GasFeeCap:      feeCap.String(),
+		}); err != nil {
+			utils.Logger.Error().Err(err).Hex("tx-hash", replacement.Hash().Bytes()).Msg("Failed to record replacement")
+		}
+	}
+	return nil
+}
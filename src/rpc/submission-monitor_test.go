@@ -0,0 +1,219 @@
+package rpc_test
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/shutter-network/encrypting-rpc-server/metrics"
+	"github.com/shutter-network/encrypting-rpc-server/rpc"
+	"github.com/shutter-network/encrypting-rpc-server/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubmissionMonitor_ReplacesStuckSubmission(t *testing.T) {
+	ctx := context.Background()
+	mockDb, database := test.NewPostgresTestDB(t)
+	client := new(MockEthereumClient)
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	signer := rpc.NewSigner(client, rpc.NewKeySigner(key))
+	// exactly the extra cost of the replacement, 100000 gas at 300 more wei
+	spending := rpc.NewSpendingLimiter(rpc.SpendingLimits{Daily: big.NewInt(30000000)}, nil)
+	monitor := rpc.NewSubmissionMonitor(rpc.Processor{Client: client, Db: database, Spending: spending}, 3)
+
+	chainID := big.NewInt(100)
+	sequencer := common.HexToAddress("0x5e")
+	tx, err := signer.TxSigner.SignTx(ctx, types.NewTx(&types.DynamicFeeTx{
+		ChainID:   chainID,
+		Nonce:     7,
+		GasTipCap: big.NewInt(1000),
+		GasFeeCap: big.NewInt(3000),
+		Gas:       100000,
+		To:        &sequencer,
+		Value:     big.NewInt(42),
+		Data:      []byte{1, 2, 3},
+	}), chainID)
+	require.NoError(t, err)
+	monitor.Track(signer, tx)
+
+	client.On("ChainID", mock.Anything).Return(chainID, nil)
+	client.On("SuggestGasTipCap", mock.Anything).Return(big.NewInt(500), nil)
+	client.On("HeaderByNumber", mock.Anything, (*big.Int)(nil)).Return(&types.Header{BaseFee: big.NewInt(1000)}, nil)
+	client.On("NonceAt", mock.Anything, signer.Address, (*big.Int)(nil)).Return(uint64(7), nil).Times(3)
+
+	expectSave := func(sentBlock uint64) {
+		mockDb.ExpectBegin()
+		mockDb.ExpectExec(`INSERT INTO "tracked_submissions"`).
+			WithArgs(tx.Hash().String(), signer.Address.String(), sqlmock.AnyArg(), sentBlock, sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mockDb.ExpectCommit()
+	}
+
+	// sent at block 10, not stuck before block 13
+	expectSave(10)
+	client.On("BlockNumber", mock.Anything).Return(uint64(10), nil).Once()
+	monitor.Check(ctx)
+	client.On("BlockNumber", mock.Anything).Return(uint64(12), nil).Once()
+	monitor.Check(ctx)
+	client.AssertNotCalled(t, "SendTransaction", mock.Anything, mock.Anything)
+
+	var replacement *types.Transaction
+	client.On("SendTransaction", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		replacement = args.Get(1).(*types.Transaction)
+	}).Return(nil).Once()
+	mockDb.ExpectBegin()
+	mockDb.ExpectQuery(`INSERT INTO "submission_replacements"`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mockDb.ExpectCommit()
+	expectSave(13)
+	replacements := testutil.ToFloat64(metrics.SubmissionReplacements)
+
+	client.On("BlockNumber", mock.Anything).Return(uint64(13), nil).Once()
+	monitor.Check(ctx)
+	require.NotNil(t, replacement, "the stuck submission should be replaced")
+	assert.Equal(t, tx.Nonce(), replacement.Nonce())
+	assert.Equal(t, tx.Data(), replacement.Data())
+	assert.Equal(t, tx.Value(), replacement.Value())
+	assert.Equal(t, big.NewInt(1100), replacement.GasTipCap())
+	assert.Equal(t, big.NewInt(3300), replacement.GasFeeCap())
+	sender, err := types.Sender(types.LatestSignerForChainID(chainID), replacement)
+	require.NoError(t, err)
+	assert.Equal(t, signer.Address, sender)
+	assert.Equal(t, replacements+1, testutil.ToFloat64(metrics.SubmissionReplacements))
+	assert.NoError(t, mockDb.ExpectationsWereMet())
+	_, err = spending.Reserve(big.NewInt(0), big.NewInt(1))
+	assert.ErrorContains(t, err, "daily spending limit exceeded", "the replacement should be charged")
+
+	// mined, no longer watched and charged with what the replacement cost
+	client.On("TransactionReceipt", mock.Anything, replacement.Hash()).
+		Return(&types.Receipt{GasUsed: 10000, EffectiveGasPrice: big.NewInt(2000)}, nil).Once()
+	mockDb.ExpectBegin()
+	mockDb.ExpectExec(`DELETE FROM "tracked_submissions"`).WithArgs(tx.Hash().String()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mockDb.ExpectCommit()
+	client.On("NonceAt", mock.Anything, signer.Address, (*big.Int)(nil)).Return(uint64(8), nil).Once()
+	client.On("BlockNumber", mock.Anything).Return(uint64(20), nil).Once()
+	monitor.Check(ctx)
+	monitor.Check(ctx)
+	client.AssertExpectations(t)
+	assert.NoError(t, mockDb.ExpectationsWereMet())
+	_, err = spending.Reserve(big.NewInt(0), big.NewInt(9999959))
+	assert.ErrorContains(t, err, "daily spending limit exceeded, 9999958 wei")
+}
+
+func TestSubmissionMonitor_ReplacementOverBudget(t *testing.T) {
+	ctx := context.Background()
+	client := new(MockEthereumClient)
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	signer := rpc.NewSigner(client, rpc.NewKeySigner(key))
+	spending := rpc.NewSpendingLimiter(rpc.SpendingLimits{Daily: big.NewInt(1000)}, nil)
+	monitor := rpc.NewSubmissionMonitor(rpc.Processor{Client: client, Spending: spending}, 1)
+
+	chainID := big.NewInt(100)
+	sequencer := common.HexToAddress("0x5e")
+	tx, err := signer.TxSigner.SignTx(ctx, types.NewTx(&types.DynamicFeeTx{
+		ChainID:   chainID,
+		Nonce:     7,
+		GasTipCap: big.NewInt(1000),
+		GasFeeCap: big.NewInt(3000),
+		Gas:       100000,
+		To:        &sequencer,
+	}), chainID)
+	require.NoError(t, err)
+	monitor.Track(signer, tx)
+
+	client.On("ChainID", mock.Anything).Return(chainID, nil)
+	client.On("SuggestGasTipCap", mock.Anything).Return(big.NewInt(500), nil)
+	client.On("HeaderByNumber", mock.Anything, (*big.Int)(nil)).Return(&types.Header{BaseFee: big.NewInt(1000)}, nil)
+	client.On("NonceAt", mock.Anything, signer.Address, (*big.Int)(nil)).Return(uint64(7), nil)
+	client.On("BlockNumber", mock.Anything).Return(uint64(10), nil).Once()
+	monitor.Check(ctx)
+	client.On("BlockNumber", mock.Anything).Return(uint64(11), nil).Once()
+	monitor.Check(ctx)
+	client.AssertNotCalled(t, "SendTransaction", mock.Anything, mock.Anything)
+}
+
+func TestSubmissionMonitor_SaveAndResume(t *testing.T) {
+	ctx := context.Background()
+	mockDb, database := test.NewPostgresTestDB(t)
+	client := new(MockEthereumClient)
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	signer := rpc.NewSigner(client, rpc.NewKeySigner(key))
+	processor := rpc.Processor{Client: client, Db: database, Signers: rpc.NewSignerPool(signer)}
+
+	chainID := big.NewInt(100)
+	sequencer := common.HexToAddress("0x5e")
+	tx, err := signer.TxSigner.SignTx(ctx, types.NewTx(&types.DynamicFeeTx{
+		ChainID:   chainID,
+		Nonce:     7,
+		GasTipCap: big.NewInt(1000),
+		GasFeeCap: big.NewInt(3000),
+		Gas:       100000,
+		To:        &sequencer,
+	}), chainID)
+	require.NoError(t, err)
+	rawTx, err := tx.MarshalBinary()
+	require.NoError(t, err)
+
+	monitor := rpc.NewSubmissionMonitor(processor, 3)
+	monitor.Track(signer, tx)
+	mockDb.ExpectBegin()
+	mockDb.ExpectExec(`INSERT INTO "tracked_submissions"`).
+		WithArgs(tx.Hash().String(), signer.Address.String(), rawTx, uint64(0), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mockDb.ExpectCommit()
+	require.NoError(t, monitor.Save())
+
+	// after a restart
+	monitor = rpc.NewSubmissionMonitor(processor, 3)
+	// the rows are kept, so that the submissions are resumed after a crash as well
+	mockDb.ExpectQuery(`SELECT \* FROM "tracked_submissions"`).
+		WillReturnRows(sqlmock.NewRows([]string{"original_tx_hash", "signer_address", "raw_tx", "sent_block"}).
+			AddRow(tx.Hash().String(), signer.Address.String(), rawTx, 10))
+	require.NoError(t, monitor.Resume())
+
+	client.On("ChainID", mock.Anything).Return(chainID, nil)
+	client.On("SuggestGasTipCap", mock.Anything).Return(big.NewInt(500), nil)
+	client.On("HeaderByNumber", mock.Anything, (*big.Int)(nil)).Return(&types.Header{BaseFee: big.NewInt(1000)}, nil)
+	client.On("NonceAt", mock.Anything, signer.Address, (*big.Int)(nil)).Return(uint64(7), nil)
+	client.On("BlockNumber", mock.Anything).Return(uint64(13), nil)
+	var replacement *types.Transaction
+	client.On("SendTransaction", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		replacement = args.Get(1).(*types.Transaction)
+	}).Return(nil).Once()
+	mockDb.ExpectBegin()
+	mockDb.ExpectQuery(`INSERT INTO "submission_replacements"`).
+		WithArgs(tx.Hash().String(), sqlmock.AnyArg(), signer.Address.String(), uint64(7), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mockDb.ExpectCommit()
+	// the replacement is saved right away
+	mockDb.ExpectBegin()
+	mockDb.ExpectExec(`INSERT INTO "tracked_submissions"`).
+		WithArgs(tx.Hash().String(), signer.Address.String(), sqlmock.AnyArg(), uint64(13), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mockDb.ExpectCommit()
+
+	monitor.Check(ctx)
+	require.NotNil(t, replacement, "the resumed submission should still be replaced once stuck")
+	assert.Equal(t, tx.Nonce(), replacement.Nonce())
+	assert.NoError(t, mockDb.ExpectationsWereMet())
+
+	// mined, the row is removed
+	unsetCalls(&client.Mock, "NonceAt")
+	client.On("NonceAt", mock.Anything, signer.Address, (*big.Int)(nil)).Return(uint64(8), nil)
+	mockDb.ExpectBegin()
+	mockDb.ExpectExec(`DELETE FROM "tracked_submissions" WHERE original_tx_hash IN \(\$1\)`).
+		WithArgs(tx.Hash().String()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mockDb.ExpectCommit()
+	monitor.Check(ctx)
+	assert.NoError(t, mockDb.ExpectationsWereMet())
+}
@@ -0,0 +1,35 @@
+package rpc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	txtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// TransactionSigner signs the transactions of one signing address.
+type TransactionSigner interface {
+	Address() common.Address
+	SignTx(ctx context.Context, tx *txtypes.Transaction, chainID *big.Int) (*txtypes.Transaction, error)
+}
+
+// KeySigner signs with a private key held by the server.
+type KeySigner struct {
+	key     *ecdsa.PrivateKey
+	address common.Address
+}
+
+func NewKeySigner(key *ecdsa.PrivateKey) *KeySigner {
+	return &KeySigner{key: key, address: crypto.PubkeyToAddress(key.PublicKey)}
+}
+
+func (s *KeySigner) Address() common.Address {
+	return s.address
+}
+
+func (s *KeySigner) SignTx(ctx context.Context, tx *txtypes.Transaction, chainID *big.Int) (*txtypes.Transaction, error) {
+	return txtypes.SignTx(tx, txtypes.LatestSignerForChainID(chainID), s.key)
+}
@@ -0,0 +1,182 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shutter-network/encrypting-rpc-server/db"
+	"github.com/shutter-network/encrypting-rpc-server/ratelimit"
+	"github.com/shutter-network/encrypting-rpc-server/utils"
+)
+
+const APIKeyHeader = "X-API-Key"
+
+// submissionMethods are the methods counted towards the submission quota of an API key.
+var submissionMethods = map[string]bool{
+	"eth_sendTransaction":    true,
+	"eth_sendRawTransaction": true,
+
+	"shutter_sendEncryptedTransaction": true,
+}
+
+type APIKeySource interface {
+	GetAPIKeys() ([]db.APIKey, error)
+}
+
+// APIKeyStore keeps the API keys from the database in memory and enforces their quotas.
+type APIKeyStore struct {
+	source   APIKeySource
+	required bool
+
+	mu   sync.RWMutex
+	keys map[string]db.APIKey
+	ids  map[uint]db.APIKey
+
+	requests    *ratelimit.KeyedLimiter
+	submissions *ratelimit.KeyedLimiter
+}
+
+func NewAPIKeyStore(source APIKeySource, required bool) *APIKeyStore {
+	return &APIKeyStore{
+		source:      source,
+		required:    required,
+		keys:        make(map[string]db.APIKey),
+		ids:         make(map[uint]db.APIKey),
+		requests:    ratelimit.NewKeyedLimiter(),
+		submissions: ratelimit.NewKeyedLimiter(),
+	}
+}
+
+func (s *APIKeyStore) Reload() error {
+	apiKeys, err := s.source.GetAPIKeys()
+	if err != nil {
+		return err
+	}
+
+	keys := make(map[string]db.APIKey, len(apiKeys))
+	ids := make(map[uint]db.APIKey, len(apiKeys))
+	for _, apiKey := range apiKeys {
+		keys[apiKey.Key] = apiKey
+		ids[apiKey.ID] = apiKey
+	}
+
+	s.mu.Lock()
+	s.keys, s.ids = keys, ids
+	s.mu.Unlock()
+	return nil
+}
+
+// Run reloads the keys periodically so that changes in the database are picked up.
+func (s *APIKeyStore) Run(ctx context.Context, interval time.Duration) {
+	timer := time.NewTicker(interval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			if err := s.Reload(); err != nil {
+				utils.Logger.Error().Err(err).Msg("failed to reload api keys")
+			}
+			s.requests.Cleanup(interval)
+			s.submissions.Cleanup(interval)
+		}
+	}
+}
+
+func (s *APIKeyStore) Lookup(key string) (db.APIKey, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	apiKey, ok := s.keys[key]
+	return apiKey, ok
+}
+
+// CheckQuota reports whether a call to method is within the quotas of the API key of the request.
+func (s *APIKeyStore) CheckQuota(ctx context.Context, method string) (bool, string) {
+	id := utils.APIKeyID(ctx)
+	if id == 0 {
+		return true, ""
+	}
+
+	s.mu.RLock()
+	apiKey, ok := s.ids[id]
+	s.mu.RUnlock()
+	if !ok {
+		return true, ""
+	}
+
+	limiterKey := strconv.FormatUint(uint64(id), 10)
+	if !s.requests.Allow(limiterKey, apiKey.RequestsPerSecond, apiKey.RequestBurst) {
+		return false, "request rate limit exceeded"
+	}
+	if submissionMethods[method] && !s.submissions.Allow(limiterKey, apiKey.SubmissionsPerMinute/60, apiKey.SubmissionBurst) {
+		return false, "submission limit exceeded"
+	}
+	return true, ""
+}
+
+// Middleware authenticates requests by the API key given in the X-API-Key header or as first path
+// segment. The path segment is removed so that it is not forwarded upstream.
+func (s *APIKeyStore) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, path := s.requestKey(r)
+		if path != r.URL.Path {
+			r.URL.Path = path
+			r.URL.RawPath = ""
+		}
+		r.Header.Del(APIKeyHeader)
+
+		if key == "" {
+			if s.required {
+				writeUnauthorized(w, "api key required")
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		apiKey, ok := s.Lookup(key)
+		if !ok {
+			writeUnauthorized(w, "invalid api key")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(utils.WithAPIKeyID(r.Context(), apiKey.ID)))
+	})
+}
+
+// requestKey returns the API key of r and the path to forward. The key is taken from the X-API-Key
+// header, otherwise from the first path segment, which is then removed from the path. If keys are
+// optional, a segment which is no known key is part of the path and the request has no key.
+func (s *APIKeyStore) requestKey(r *http.Request) (string, string) {
+	if key := r.Header.Get(APIKeyHeader); key != "" {
+		return key, r.URL.Path
+	}
+	segment, rest := splitFirstSegment(r.URL.Path)
+	if segment == "" {
+		return "", r.URL.Path
+	}
+	if _, known := s.Lookup(segment); !known && !s.required {
+		return "", r.URL.Path
+	}
+	return segment, rest
+}
+
+func splitFirstSegment(path string) (string, string) {
+	trimmed := strings.TrimPrefix(path, "/")
+	if trimmed == "" {
+		return "", path
+	}
+	segment, rest, _ := strings.Cut(trimmed, "/")
+	return segment, "/" + rest
+}
+
+func writeUnauthorized(w http.ResponseWriter, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnauthorized)
+	_, _ = w.Write(errorResponse(nil, errCodeServer, message))
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/shutter-network/encrypting-rpc-server/db"
+	"github.com/shutter-network/encrypting-rpc-server/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type staticAPIKeys []db.APIKey
+
+func (k staticAPIKeys) GetAPIKeys() ([]db.APIKey, error) {
+	return k, nil
+}
+
+func newTestAPIKeyStore(t *testing.T, required bool) *APIKeyStore {
+	store := NewAPIKeyStore(staticAPIKeys{
+		{ID: 1, Key: "unlimited"},
+		{ID: 2, Key: "limited", RequestsPerSecond: 0.001, RequestBurst: 2, SubmissionsPerMinute: 0.001, SubmissionBurst: 1},
+	}, required)
+	require.NoError(t, store.Reload())
+	return store
+}
+
+func authenticate(store *APIKeyStore, path string, header string) (*httptest.ResponseRecorder, *http.Request) {
+	var seen *http.Request
+	handler := store.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r
+	}))
+	req := httptest.NewRequest(http.MethodPost, path, nil)
+	if header != "" {
+		req.Header.Set(APIKeyHeader, header)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec, seen
+}
+
+func TestAPIKeyMiddleware_PathSegment(t *testing.T) {
+	store := newTestAPIKeyStore(t, true)
+
+	rec, seen := authenticate(store, "/limited", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	require.NotNil(t, seen)
+	assert.Equal(t, "/", seen.URL.Path, "the key should not be forwarded")
+	assert.Equal(t, uint(2), utils.APIKeyID(seen.Context()))
+}
+
+func TestAPIKeyMiddleware_Header(t *testing.T) {
+	store := newTestAPIKeyStore(t, true)
+
+	rec, seen := authenticate(store, "/", "unlimited")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	require.NotNil(t, seen)
+	assert.Empty(t, seen.Header.Get(APIKeyHeader), "the key should not be forwarded")
+	assert.Equal(t, uint(1), utils.APIKeyID(seen.Context()))
+}
+
+func TestAPIKeyMiddleware_Rejected(t *testing.T) {
+	store := newTestAPIKeyStore(t, true)
+
+	rec, seen := authenticate(store, "/unknown", "")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Nil(t, seen)
+
+	rec, seen = authenticate(store, "/", "")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code, "a key should be required")
+	assert.Nil(t, seen)
+
+	optional := newTestAPIKeyStore(t, false)
+	rec, seen = authenticate(optional, "/", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	require.NotNil(t, seen)
+	assert.Equal(t, uint(0), utils.APIKeyID(seen.Context()))
+}
+
+func TestAPIKeyMiddleware_OptionalKeepsPath(t *testing.T) {
+	store := newTestAPIKeyStore(t, false)
+
+	rec, seen := authenticate(store, "/rpc", "")
+	assert.Equal(t, http.StatusOK, rec.Code, "an unknown segment should not be taken as key")
+	require.NotNil(t, seen)
+	assert.Equal(t, "/rpc", seen.URL.Path)
+	assert.Equal(t, uint(0), utils.APIKeyID(seen.Context()))
+
+	rec, seen = authenticate(store, "/rpc", "unlimited")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	require.NotNil(t, seen)
+	assert.Equal(t, "/rpc", seen.URL.Path, "the path should be kept if the key is given in the header")
+	assert.Equal(t, uint(1), utils.APIKeyID(seen.Context()))
+
+	rec, seen = authenticate(store, "/limited/rpc", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	require.NotNil(t, seen)
+	assert.Equal(t, "/rpc", seen.URL.Path)
+	assert.Equal(t, uint(2), utils.APIKeyID(seen.Context()))
+}
+
+func TestJSONRPCProxy_APIKeyQuota(t *testing.T) {
+	store := newTestAPIKeyStore(t, true)
+	p := &JSONRPCProxy{backend: &stubHandler{name: "backend"}, processor: &stubHandler{name: "processor"}, apiKeys: store}
+	handler := store.Middleware(p)
+
+	call := func(key string, method string) batchResponse {
+		body := `{"jsonrpc":"2.0","id":1,"method":"` + method + `","params":[]}`
+		req := httptest.NewRequest(http.MethodPost, "/"+key, bytes.NewBufferString(body))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		var resp batchResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		return resp
+	}
+
+	assert.Nil(t, call("limited", "eth_sendRawTransaction").Error)
+	resp := call("limited", "eth_sendRawTransaction")
+	require.NotNil(t, resp.Error, "submission quota should be used up")
+	assert.Equal(t, -32005, resp.Error.Code)
+
+	resp = call("limited", "eth_chainId")
+	require.NotNil(t, resp.Error, "request quota should be used up")
+	assert.Equal(t, -32005, resp.Error.Code)
+
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, call("unlimited", "eth_sendRawTransaction").Error)
+	}
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/shutter-network/encrypting-rpc-server/utils"
+	"github.com/shutter-network/rolling-shutter/rolling-shutter/medley"
+)
+
+// requestID extracts the raw id of a JSON-RPC request so that it can be echoed back unchanged.
+func requestID(msg []byte) json.RawMessage {
+	var req struct {
+		ID json.RawMessage `json:"id"`
+	}
+	if err := json.Unmarshal(msg, &req); err != nil {
+		return nil
+	}
+	return req.ID
+}
+
+func isBatch(body []byte) bool {
+	trimmed := bytes.TrimLeft(body, " \t\r\n")
+	return len(trimmed) > 0 && trimmed[0] == '['
+}
+
+// responseRecorder buffers the response of a single call of a batch.
+type responseRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newResponseRecorder() *responseRecorder {
+	return &responseRecorder{header: make(http.Header), status: http.StatusOK}
+}
+
+func (rec *responseRecorder) Header() http.Header {
+	return rec.header
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	return rec.body.Write(b)
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	rec.status = status
+}
+
+// serveBatch splits a batch into its calls, routes each of them through SelectHandler and
+// writes the responses back as a single array in the order of the requests.
+func (p *JSONRPCProxy) serveBatch(w http.ResponseWriter, r *http.Request, body []byte) {
+	var msgs []json.RawMessage
+	if err := json.Unmarshal(body, &msgs); err != nil {
+		code, message := decodeError(body)
+		writeError(w, nil, code, message)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if len(msgs) == 0 {
+		_, _ = w.Write(errorResponse(nil, errCodeInvalidRequest, "empty batch"))
+		return
+	}
+	// every call may be relayed upstream, so a small body must not fan out into many calls
+	if p.maxBatchSize > 0 && len(msgs) > p.maxBatchSize {
+		_, _ = w.Write(errorResponse(nil, errCodeInvalidRequest, fmt.Sprintf("batch of %d calls exceeds the maximum of %d", len(msgs), p.maxBatchSize)))
+		return
+	}
+
+	responses := make([]json.RawMessage, 0, len(msgs))
+	for _, msg := range msgs {
+		id := requestID(msg)
+
+		rpcreq := medley.RPCRequest{}
+		if err := json.Unmarshal(msg, &rpcreq); err != nil || rpcreq.Method == "" {
+			responses = append(responses, errorResponse(id, errCodeInvalidRequest, "invalid request"))
+			continue
+		}
+
+		req := r.Clone(r.Context())
+		// the response is decoded and embedded into the batch, so it must not be compressed
+		req.Header.Del("Accept-Encoding")
+		req.ContentLength = int64(len(msg))
+
+		rec := newResponseRecorder()
+		p.serveRequest(rec, req, rpcreq, msg)
+
+		// notifications don't get a response
+		if id == nil {
+			continue
+		}
+
+		resp := bytes.TrimSpace(rec.body.Bytes())
+		if rec.status != http.StatusOK || !json.Valid(resp) {
+			utils.Logger.Info().Str("method", rpcreq.Method).Int("status", rec.status).Msg("invalid response for batch call")
+			responses = append(responses, errorResponse(id, errCodeInternal, "internal error"))
+			continue
+		}
+		responses = append(responses, resp)
+	}
+
+	if len(responses) == 0 {
+		return
+	}
+
+	out, err := json.Marshal(responses)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	_, _ = w.Write(out)
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type stubHandler struct {
+	name string
+}
+
+func (h *stubHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID     json.RawMessage `json:"id"`
+		Method string          `json:"method"`
+	}
+	body, _ := io.ReadAll(r.Body)
+	_ = json.Unmarshal(body, &req)
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":` + string(req.ID) + `,"result":"` + h.name + `:` + req.Method + `"}`))
+}
+
+type batchResponse struct {
+	ID     json.RawMessage `json:"id"`
+	Result string          `json:"result"`
+	Error  *rpcError       `json:"error"`
+}
+
+func serveBody(p *JSONRPCProxy, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestServeBatch_MixedMethods(t *testing.T) {
+	p := &JSONRPCProxy{backend: &stubHandler{name: "backend"}, processor: &stubHandler{name: "processor"}}
+
+	rec := serveBody(p, `[
+		{"jsonrpc":"2.0","id":1,"method":"eth_chainId","params":[]},
+		{"jsonrpc":"2.0","id":"abc","method":"eth_sendRawTransaction","params":["0x00"]},
+		{"jsonrpc":"2.0","method":"eth_blockNumber","params":[]},
+		{"jsonrpc":"2.0","id":18446744073709551615,"method":"eth_gasPrice","params":[]}
+	]`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var responses []batchResponse
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &responses))
+	assert.Len(t, responses, 3, "notifications should not get a response")
+
+	assert.Equal(t, "1", string(responses[0].ID))
+	assert.Equal(t, "backend:eth_chainId", responses[0].Result)
+	assert.Equal(t, `"abc"`, string(responses[1].ID))
+	assert.Equal(t, "processor:eth_sendRawTransaction", responses[1].Result)
+	assert.Equal(t, "18446744073709551615", string(responses[2].ID))
+	assert.Equal(t, "processor:eth_gasPrice", responses[2].Result)
+}
+
+func TestServeBatch_InvalidElement(t *testing.T) {
+	p := &JSONRPCProxy{backend: &stubHandler{name: "backend"}, processor: &stubHandler{name: "processor"}}
+
+	rec := serveBody(p, `[{"jsonrpc":"2.0","id":7},{"jsonrpc":"2.0","id":8,"method":"eth_chainId"}]`)
+
+	var responses []batchResponse
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &responses))
+	assert.Len(t, responses, 2)
+	assert.Equal(t, "7", string(responses[0].ID))
+	assert.NotNil(t, responses[0].Error)
+	assert.Equal(t, -32600, responses[0].Error.Code)
+	assert.Equal(t, "backend:eth_chainId", responses[1].Result)
+}
+
+func TestServeBatch_Empty(t *testing.T) {
+	p := &JSONRPCProxy{backend: &stubHandler{name: "backend"}, processor: &stubHandler{name: "processor"}}
+
+	rec := serveBody(p, `[]`)
+
+	var response batchResponse
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+	assert.NotNil(t, response.Error)
+	assert.Equal(t, -32600, response.Error.Code)
+}
+
+func TestServeBatch_TooLarge(t *testing.T) {
+	backend := &countingBackend{result: `"0x1"`}
+	p := &JSONRPCProxy{backend: backend, processor: &stubHandler{name: "processor"}, maxBatchSize: 2}
+
+	rec := serveBody(p, `[
+		{"jsonrpc":"2.0","id":1,"method":"eth_chainId","params":[]},
+		{"jsonrpc":"2.0","id":2,"method":"eth_chainId","params":[]},
+		{"jsonrpc":"2.0","id":3,"method":"eth_chainId","params":[]}
+	]`)
+
+	var response batchResponse
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+	assert.NotNil(t, response.Error)
+	assert.Equal(t, -32600, response.Error.Code)
+	assert.Equal(t, "batch of 3 calls exceeds the maximum of 2", response.Error.Message)
+	assert.Zero(t, backend.calls, "no call of an oversized batch should be relayed")
+
+	rec = serveBody(p, `[{"jsonrpc":"2.0","id":1,"method":"eth_chainId","params":[]},{"jsonrpc":"2.0","id":2,"method":"eth_chainId","params":[]}]`)
+	var responses []batchResponse
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &responses))
+	assert.Len(t, responses, 2)
+}
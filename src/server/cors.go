@@ -0,0 +1,231 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/shutter-network/encrypting-rpc-server/utils"
+)
+
+// CORSPolicy describes the CORS headers sent to browsers. Empty lists are taken from the default
+// policy.
+type CORSPolicy struct {
+	AllowedOrigins   []string `json:"allowedOrigins"`
+	AllowedMethods   []string `json:"allowedMethods"`
+	AllowedHeaders   []string `json:"allowedHeaders"`
+	ExposedHeaders   []string `json:"exposedHeaders"`
+	AllowCredentials bool     `json:"allowCredentials"`
+	MaxAge           int      `json:"maxAge"`
+}
+
+// CORSConfig is the format of the file passed with cors-config. Policies for API keys are keyed by
+// the id of the key, policies for routes by path prefix.
+type CORSConfig struct {
+	Default *CORSPolicy           `json:"default"`
+	APIKeys map[string]CORSPolicy `json:"apiKeys"`
+	Routes  map[string]CORSPolicy `json:"routes"`
+}
+
+var defaultCORSPolicy = CORSPolicy{
+	AllowedOrigins: []string{"*"},
+	AllowedMethods: []string{http.MethodPost, http.MethodGet, http.MethodOptions},
+	AllowedHeaders: []string{"Content-Type", "Authorization", APIKeyHeader},
+}
+
+func LoadCORSConfig(path string) (*CORSConfig, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	config := &CORSConfig{}
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(config); err != nil {
+		return nil, fmt.Errorf("failed to parse cors config | err: %w", err)
+	}
+	return config, nil
+}
+
+type routeCORSPolicy struct {
+	prefix string
+	policy CORSPolicy
+}
+
+// CORSPolicies selects the CORS policy of a request. The policy of the API key takes precedence
+// over the policy of the longest matching route, which takes precedence over the default.
+type CORSPolicies struct {
+	def     CORSPolicy
+	apiKeys map[uint]CORSPolicy
+	routes  []routeCORSPolicy
+	keys    *APIKeyStore
+}
+
+// NewCORSPolicies builds the policies from config, which may be nil. keys is used to identify the
+// API key of a request and may be nil if API keys are disabled.
+func NewCORSPolicies(config *CORSConfig, keys *APIKeyStore) (*CORSPolicies, error) {
+	c := &CORSPolicies{
+		def:     defaultCORSPolicy,
+		apiKeys: make(map[uint]CORSPolicy),
+		keys:    keys,
+	}
+	if config == nil {
+		return c, nil
+	}
+
+	if config.Default != nil {
+		c.def = config.Default.withDefaults(defaultCORSPolicy)
+	}
+	for id, policy := range config.APIKeys {
+		apiKeyID, err := strconv.ParseUint(id, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid api key id %q in cors config", id)
+		}
+		c.apiKeys[uint(apiKeyID)] = policy.withDefaults(c.def)
+	}
+	for prefix, policy := range config.Routes {
+		if !strings.HasPrefix(prefix, "/") {
+			return nil, fmt.Errorf("route %q in cors config must start with /", prefix)
+		}
+		c.routes = append(c.routes, routeCORSPolicy{prefix: prefix, policy: policy.withDefaults(c.def)})
+	}
+	sort.Slice(c.routes, func(i, j int) bool {
+		return len(c.routes[i].prefix) > len(c.routes[j].prefix)
+	})
+	return c, nil
+}
+
+func (p CORSPolicy) withDefaults(def CORSPolicy) CORSPolicy {
+	if len(p.AllowedOrigins) == 0 {
+		p.AllowedOrigins = def.AllowedOrigins
+	}
+	if len(p.AllowedMethods) == 0 {
+		p.AllowedMethods = def.AllowedMethods
+	}
+	if len(p.AllowedHeaders) == 0 {
+		p.AllowedHeaders = def.AllowedHeaders
+	}
+	if len(p.ExposedHeaders) == 0 {
+		p.ExposedHeaders = def.ExposedHeaders
+	}
+	return p
+}
+
+// Policy returns the policy which applies to r, before or after the API key middleware.
+func (c *CORSPolicies) Policy(r *http.Request) CORSPolicy {
+	path := r.URL.Path
+	if c.keys != nil {
+		// the API key middleware has already removed the key from the request
+		apiKeyID := utils.APIKeyID(r.Context())
+		if apiKeyID == 0 {
+			// only a path segment which is a key is not part of the route
+			if key := r.Header.Get(APIKeyHeader); key != "" {
+				if apiKey, ok := c.keys.Lookup(key); ok {
+					apiKeyID = apiKey.ID
+				}
+			} else if segment, rest := splitFirstSegment(path); segment != "" {
+				if apiKey, ok := c.keys.Lookup(segment); ok {
+					apiKeyID = apiKey.ID
+					path = rest
+				}
+			}
+		}
+		if policy, ok := c.apiKeys[apiKeyID]; ok && apiKeyID != 0 {
+			return policy
+		}
+	}
+
+	for _, route := range c.routes {
+		if strings.HasPrefix(path, route.prefix) {
+			return route.policy
+		}
+	}
+	return c.def
+}
+
+// Middleware sets the CORS headers of the policy of the request and answers preflight requests.
+// It has to run before the API key middleware, as browsers send preflight requests without the
+// X-API-Key header.
+func (c *CORSPolicies) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		policy := c.Policy(r)
+		header := w.Header()
+
+		if origin, ok := policy.allowOrigin(r.Header.Get("Origin")); ok {
+			header.Set("Access-Control-Allow-Origin", origin)
+			if origin != "*" {
+				header.Add("Vary", "Origin")
+			}
+			header.Set("Access-Control-Allow-Methods", strings.Join(policy.AllowedMethods, ", "))
+			header.Set("Access-Control-Allow-Headers", strings.Join(policy.AllowedHeaders, ", "))
+			if len(policy.ExposedHeaders) > 0 {
+				header.Set("Access-Control-Expose-Headers", strings.Join(policy.ExposedHeaders, ", "))
+			}
+			if policy.AllowCredentials {
+				header.Set("Access-Control-Allow-Credentials", "true")
+			}
+			if r.Method == http.MethodOptions && policy.MaxAge > 0 {
+				header.Set("Access-Control-Max-Age", strconv.Itoa(policy.MaxAge))
+			}
+		}
+
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// AllowsOrigin reports whether the policy of r allows its Origin header. Requests without one do
+// not come from a browser and are allowed.
+func (c *CORSPolicies) AllowsOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	_, ok := c.Policy(r).allowOrigin(origin)
+	return ok
+}
+
+// allowOrigin returns the value of the Access-Control-Allow-Origin header for origin. Browsers
+// reject a wildcard together with credentials, so the origin is echoed in that case.
+func (p CORSPolicy) allowOrigin(origin string) (string, bool) {
+	for _, allowed := range p.AllowedOrigins {
+		if allowed == "*" {
+			if !p.AllowCredentials {
+				return "*", true
+			}
+			if origin != "" {
+				return origin, true
+			}
+			continue
+		}
+		if origin != "" && matchOrigin(allowed, origin) {
+			return origin, true
+		}
+	}
+	return "", false
+}
+
+// matchOrigin compares origin to allowed, which may contain a wildcard for subdomains like
+// https://*.example.com.
+func matchOrigin(allowed string, origin string) bool {
+	if strings.EqualFold(allowed, origin) {
+		return true
+	}
+	prefix, suffix, ok := strings.Cut(allowed, "*")
+	if !ok {
+		return false
+	}
+	return len(origin) > len(prefix)+len(suffix) &&
+		strings.HasPrefix(strings.ToLower(origin), strings.ToLower(prefix)) &&
+		strings.HasSuffix(strings.ToLower(origin), strings.ToLower(suffix))
+}
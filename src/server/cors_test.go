@@ -0,0 +1,92 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func corsHeaders(t *testing.T, c *CORSPolicies, method string, path string, origin string) (http.Header, bool) {
+	called := false
+	handler := c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	req := httptest.NewRequest(method, path, nil)
+	if origin != "" {
+		req.Header.Set("Origin", origin)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	return rec.Header(), called
+}
+
+func TestCORSPolicies_Default(t *testing.T) {
+	c, err := NewCORSPolicies(nil, nil)
+	require.NoError(t, err)
+
+	header, called := corsHeaders(t, c, http.MethodPost, "/", "https://app.example.com")
+	assert.True(t, called)
+	assert.Equal(t, "*", header.Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "POST, GET, OPTIONS", header.Get("Access-Control-Allow-Methods"))
+
+	header, called = corsHeaders(t, c, http.MethodOptions, "/", "https://app.example.com")
+	assert.False(t, called, "preflight requests should be answered directly")
+	assert.Equal(t, "*", header.Get("Access-Control-Allow-Origin"))
+}
+
+func TestCORSPolicies_PerAPIKeyAndRoute(t *testing.T) {
+	config := &CORSConfig{
+		Default: &CORSPolicy{
+			AllowedOrigins: []string{"https://*.example.com"},
+			MaxAge:         600,
+		},
+		APIKeys: map[string]CORSPolicy{
+			"2": {
+				AllowedOrigins:   []string{"https://integrator.org"},
+				AllowedHeaders:   []string{"Content-Type"},
+				AllowCredentials: true,
+			},
+		},
+		Routes: map[string]CORSPolicy{
+			"/public": {AllowedOrigins: []string{"*"}},
+		},
+	}
+	c, err := NewCORSPolicies(config, newTestAPIKeyStore(t, false))
+	require.NoError(t, err)
+
+	header, _ := corsHeaders(t, c, http.MethodOptions, "/", "https://app.example.com")
+	assert.Equal(t, "https://app.example.com", header.Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "Origin", header.Get("Vary"))
+	assert.Equal(t, "600", header.Get("Access-Control-Max-Age"))
+	assert.Equal(t, "POST, GET, OPTIONS", header.Get("Access-Control-Allow-Methods"), "methods should be inherited")
+
+	header, _ = corsHeaders(t, c, http.MethodOptions, "/", "https://example.org")
+	assert.Empty(t, header.Get("Access-Control-Allow-Origin"), "origin should not be allowed")
+
+	header, _ = corsHeaders(t, c, http.MethodOptions, "/limited", "https://integrator.org")
+	assert.Equal(t, "https://integrator.org", header.Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", header.Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, "Content-Type", header.Get("Access-Control-Allow-Headers"))
+
+	header, _ = corsHeaders(t, c, http.MethodOptions, "/limited", "https://app.example.com")
+	assert.Empty(t, header.Get("Access-Control-Allow-Origin"), "the policy of the key should replace the default")
+
+	header, _ = corsHeaders(t, c, http.MethodPost, "/unlimited/public", "https://example.org")
+	assert.Equal(t, "*", header.Get("Access-Control-Allow-Origin"), "routes should match without the key segment")
+
+	req := httptest.NewRequest(http.MethodPost, "/public", nil)
+	req.Header.Set(APIKeyHeader, "unlimited")
+	assert.Equal(t, []string{"*"}, c.Policy(req).AllowedOrigins, "the path should be kept with the key in the header")
+}
+
+func TestNewCORSPolicies_Invalid(t *testing.T) {
+	_, err := NewCORSPolicies(&CORSConfig{APIKeys: map[string]CORSPolicy{"abc": {}}}, nil)
+	assert.Error(t, err)
+
+	_, err = NewCORSPolicies(&CORSConfig{Routes: map[string]CORSPolicy{"public": {}}}, nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/shutter-network/encrypting-rpc-server/utils"
+)
+
+// JSON-RPC 2.0 error codes, -32005 is the limit exceeded code of EIP-1474.
+const (
+	errCodeParse          = -32700
+	errCodeInvalidRequest = -32600
+	errCodeMethodNotFound = -32601
+	errCodeInternal       = -32603
+	errCodeServer         = -32000
+	errCodeLimitExceeded  = -32005
+)
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type rpcErrorResponse struct {
+	Version string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Error   rpcError        `json:"error"`
+}
+
+// errorResponse builds a JSON-RPC error object. A nil id is encoded as null.
+func errorResponse(id json.RawMessage, code int, message string) json.RawMessage {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	resp, _ := json.Marshal(rpcErrorResponse{
+		Version: "2.0",
+		ID:      id,
+		Error:   rpcError{Code: code, Message: message},
+	})
+	return resp
+}
+
+func writeError(w http.ResponseWriter, id json.RawMessage, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(errorResponse(id, code, message))
+}
+
+// readError returns the JSON-RPC error for a body which could not be read.
+func readError(err error) (int, string) {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return errCodeInvalidRequest, "request body too large"
+	}
+	return errCodeInvalidRequest, "failed to read request body"
+}
+
+// decodeError returns the JSON-RPC error for a body which is not a valid request. Bodies which
+// are not JSON at all are parse errors.
+func decodeError(body []byte) (int, string) {
+	if !json.Valid(body) {
+		return errCodeParse, "parse error"
+	}
+	return errCodeInvalidRequest, "invalid request"
+}
+
+type rpcIDContextKey struct{}
+
+// withRPCID keeps the id of the call in the request context so that errors raised after the body
+// has been consumed, e.g. by the reverse proxy, can still be answered with the id.
+func withRPCID(ctx context.Context, id json.RawMessage) context.Context {
+	return context.WithValue(ctx, rpcIDContextKey{}, id)
+}
+
+func rpcID(ctx context.Context) json.RawMessage {
+	id, _ := ctx.Value(rpcIDContextKey{}).(json.RawMessage)
+	return id
+}
+
+// upstreamErrorHandler answers calls which could not be forwarded to any upstream with a JSON-RPC
+// error instead of an empty 502 response.
+func upstreamErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, context.Canceled) {
+		// the client is gone, there is no one to answer
+		return
+	}
+	utils.Logger.Error().Err(err).Str("url", r.URL.Redacted()).Msg("upstream request failed")
+	writeError(w, rpcID(r.Context()), errCodeInternal, "upstream unavailable")
+}
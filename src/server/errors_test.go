@@ -0,0 +1,82 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func decodeErrorResponse(t *testing.T, rec *httptest.ResponseRecorder) batchResponse {
+	var resp batchResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp), "expected a JSON-RPC response, got %q", rec.Body.String())
+	require.NotNil(t, resp.Error)
+	return resp
+}
+
+func TestJSONRPCProxy_MalformedRequests(t *testing.T) {
+	p := &JSONRPCProxy{backend: &stubHandler{name: "backend"}, processor: &stubHandler{name: "processor"}}
+
+	tests := []struct {
+		name string
+		body string
+		id   string
+		code int
+	}{
+		{"not json", `{"jsonrpc":"2.0","id":1,"method":`, "null", errCodeParse},
+		{"empty body", ``, "null", errCodeParse},
+		{"method not a string", `{"jsonrpc":"2.0","id":3,"method":5}`, "3", errCodeInvalidRequest},
+		{"missing method", `{"jsonrpc":"2.0","id":"x"}`, `"x"`, errCodeInvalidRequest},
+		{"broken batch", `[{"jsonrpc":"2.0","id":1,`, "null", errCodeParse},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rec := serveBody(p, test.body)
+			resp := decodeErrorResponse(t, rec)
+			assert.Equal(t, test.id, string(resp.ID))
+			assert.Equal(t, test.code, resp.Error.Code)
+		})
+	}
+}
+
+func TestJSONRPCProxy_MaxBodySize(t *testing.T) {
+	p := &JSONRPCProxy{backend: &stubHandler{name: "backend"}, processor: &stubHandler{name: "processor"}, maxBodySize: 100}
+
+	rec := serveBody(p, `{"jsonrpc":"2.0","id":1,"method":"eth_chainId","params":[]}`)
+	var resp batchResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Nil(t, resp.Error)
+
+	rec = serveBody(p, `{"jsonrpc":"2.0","id":1,"method":"eth_call","params":["`+strings.Repeat("0", 200)+`"]}`)
+	resp = decodeErrorResponse(t, rec)
+	assert.Equal(t, errCodeInvalidRequest, resp.Error.Code)
+	assert.Equal(t, "request body too large", resp.Error.Message)
+}
+
+func TestJSONRPCProxy_UpstreamUnavailable(t *testing.T) {
+	upstream := httptest.NewServer(http.NotFoundHandler())
+	target, err := url.Parse(upstream.URL)
+	require.NoError(t, err)
+	upstream.Close()
+
+	p := &JSONRPCProxy{backend: NewReverseProxy(target), processor: &stubHandler{name: "processor"}}
+
+	rec := serveBody(p, `{"jsonrpc":"2.0","id":42,"method":"eth_chainId","params":[]}`)
+	resp := decodeErrorResponse(t, rec)
+	assert.Equal(t, "42", string(resp.ID))
+	assert.Equal(t, errCodeInternal, resp.Error.Code)
+
+	rec = serveBody(p, `[{"jsonrpc":"2.0","id":1,"method":"eth_chainId"},{"jsonrpc":"2.0","id":2,"method":"eth_gasPrice"}]`)
+	var responses []batchResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &responses))
+	require.Len(t, responses, 2)
+	assert.Equal(t, "1", string(responses[0].ID))
+	require.NotNil(t, responses[0].Error)
+	assert.Equal(t, "upstream unavailable", responses[0].Error.Message)
+	assert.Nil(t, responses[1].Error)
+}
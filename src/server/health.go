@@ -0,0 +1,148 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/shutter-network/encrypting-rpc-server/db"
+	"github.com/shutter-network/encrypting-rpc-server/rpc"
+	"github.com/shutter-network/encrypting-rpc-server/utils"
+)
+
+// healthCheckTimeout bounds the time all readiness checks together may take.
+const healthCheckTimeout = 5 * time.Second
+
+const (
+	healthStatusOK   = "ok"
+	healthStatusFail = "fail"
+)
+
+type healthCheckResult struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type healthReport struct {
+	Status string                       `json:"status"`
+	Checks map[string]healthCheckResult `json:"checks,omitempty"`
+}
+
+// healthCheck is a readiness check. Only reason is reported to clients when it fails, the error
+// is logged.
+type healthCheck struct {
+	run    func(ctx context.Context) error
+	reason string
+}
+
+// HealthChecker answers the liveness and readiness probes. The server is ready if the upstream is
+// reachable and synced, the database is reachable, the current eon key can be fetched and a
+// signer has a balance above minBalance.
+type HealthChecker struct {
+	checks  map[string]healthCheck
+	timeout time.Duration
+}
+
+// NewHealthChecker creates the checks for processor. database may be nil if there is none.
+func NewHealthChecker(processor rpc.Processor, database *db.PostgresDb, minBalance *big.Int) *HealthChecker {
+	if minBalance == nil {
+		minBalance = big.NewInt(0)
+	}
+	checks := map[string]healthCheck{
+		"upstream": {reason: "upstream unreachable or syncing", run: func(ctx context.Context) error {
+			progress, err := processor.Client.SyncProgress(ctx)
+			if err != nil {
+				return err
+			}
+			if progress != nil {
+				return fmt.Errorf("upstream is syncing, at block %d of %d", progress.CurrentBlock, progress.HighestBlock)
+			}
+			return nil
+		}},
+		"eonKey": {reason: "eon key unavailable", run: func(ctx context.Context) error {
+			blockNumber, err := processor.Client.BlockNumber(ctx)
+			if err != nil {
+				return err
+			}
+			_, _, err = processor.EonKey(ctx, blockNumber)
+			return err
+		}},
+		// ready as long as one signer can submit, the pool picks funded signers
+		"signerBalance": {reason: "signer balance unknown or too low", run: func(ctx context.Context) error {
+			var balance *big.Int
+			for _, address := range processor.SigningAddresses() {
+				b, err := processor.Client.BalanceAt(ctx, address, nil)
+				if err != nil {
+					return err
+				}
+				if balance == nil || b.Cmp(balance) > 0 {
+					balance = b
+				}
+			}
+			if balance.Cmp(minBalance) <= 0 {
+				return fmt.Errorf("balance of %s wei is not above %s wei", balance, minBalance)
+			}
+			return nil
+		}},
+	}
+	if database != nil {
+		checks["database"] = healthCheck{reason: "database unreachable", run: database.Ping}
+	}
+	return &HealthChecker{checks: checks, timeout: healthCheckTimeout}
+}
+
+// run runs all checks concurrently.
+func (h *HealthChecker) run(ctx context.Context) healthReport {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	report := healthReport{Status: healthStatusOK, Checks: make(map[string]healthCheckResult)}
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	for name, check := range h.checks {
+		wg.Add(1)
+		go func(name string, check healthCheck) {
+			defer wg.Done()
+			result := healthCheckResult{Status: healthStatusOK}
+			if err := check.run(ctx); err != nil {
+				utils.Logger.Warn().Err(err).Str("check", name).Msg("readiness check failed")
+				result = healthCheckResult{Status: healthStatusFail, Error: check.reason}
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[name] = result
+			if result.Status != healthStatusOK {
+				report.Status = healthStatusFail
+			}
+		}(name, check)
+	}
+	wg.Wait()
+	return report
+}
+
+// Live answers the liveness probe. It only shows that the process is able to serve requests.
+func (h *HealthChecker) Live(w http.ResponseWriter, r *http.Request) {
+	writeHealthReport(w, healthReport{Status: healthStatusOK})
+}
+
+// Ready answers the readiness probe with the result of every check, with status 503 if one failed.
+// Failed checks only carry a short reason, the details are logged.
+func (h *HealthChecker) Ready(w http.ResponseWriter, r *http.Request) {
+	writeHealthReport(w, h.run(r.Context()))
+}
+
+func writeHealthReport(w http.ResponseWriter, report healthReport) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if report.Status != healthStatusOK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/shutter-network/encrypting-rpc-server/rpc"
+	medleyKeygen "github.com/shutter-network/rolling-shutter/rolling-shutter/medley/testkeygen"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// healthClient implements the calls of rpc.EthereumClient used by the readiness checks.
+type healthClient struct {
+	rpc.EthereumClient
+	progress *ethereum.SyncProgress
+	balance  *big.Int
+	err      error
+}
+
+func (c *healthClient) SyncProgress(ctx context.Context) (*ethereum.SyncProgress, error) {
+	return c.progress, c.err
+}
+
+func (c *healthClient) BlockNumber(ctx context.Context) (uint64, error) {
+	return 100, c.err
+}
+
+func (c *healthClient) BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error) {
+	return c.balance, c.err
+}
+
+type healthContracts struct {
+	eonKey []byte
+}
+
+func (c *healthContracts) GetKeyperSetIndexByBlock(opts *bind.CallOpts, blockNumber uint64) (uint64, error) {
+	return 1, nil
+}
+
+func (c *healthContracts) GetKeyperSetActivationBlock(opts *bind.CallOpts, index uint64) (uint64, error) {
+	return 0, nil
+}
+
+func (c *healthContracts) GetNumKeyperSets(opts *bind.CallOpts) (uint64, error) {
+	return 2, nil
+}
+
+func (c *healthContracts) GetEonKey(opts *bind.CallOpts, eon uint64) ([]byte, error) {
+	if opts == nil || opts.Context == nil {
+		return nil, errors.New("the call should be bound to the check timeout")
+	}
+	return c.eonKey, nil
+}
+
+func newHealthProcessor(t *testing.T, client *healthClient) rpc.Processor {
+	keys, err := medleyKeygen.NewEonKeys(rand.Reader, 3, 2)
+	require.NoError(t, err)
+	contracts := &healthContracts{eonKey: keys.EonPublicKey().Marshal()}
+	return rpc.Processor{
+		SigningAddress:           &common.Address{},
+		Client:                   client,
+		KeyBroadcastContract:     contracts,
+		KeyperSetManagerContract: contracts,
+	}
+}
+
+func getReady(h *HealthChecker) (*httptest.ResponseRecorder, healthReport) {
+	rec := httptest.NewRecorder()
+	h.Ready(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	report := healthReport{}
+	_ = json.Unmarshal(rec.Body.Bytes(), &report)
+	return rec, report
+}
+
+func TestHealthChecker_Ready(t *testing.T) {
+	client := &healthClient{balance: big.NewInt(10)}
+	h := NewHealthChecker(newHealthProcessor(t, client), nil, big.NewInt(1))
+
+	rec, report := getReady(h)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, healthStatusOK, report.Status)
+	for _, name := range []string{"upstream", "eonKey", "signerBalance"} {
+		assert.Equal(t, healthStatusOK, report.Checks[name].Status, name)
+	}
+}
+
+func TestHealthChecker_NotReady(t *testing.T) {
+	client := &healthClient{balance: big.NewInt(1), progress: &ethereum.SyncProgress{CurrentBlock: 5, HighestBlock: 10}}
+	h := NewHealthChecker(newHealthProcessor(t, client), nil, big.NewInt(1))
+
+	rec, report := getReady(h)
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, healthStatusFail, report.Status)
+	assert.Equal(t, healthStatusFail, report.Checks["upstream"].Status, "a syncing upstream is not ready")
+	assert.Equal(t, healthStatusFail, report.Checks["signerBalance"].Status, "the balance has to be above the threshold")
+	assert.Equal(t, healthStatusOK, report.Checks["eonKey"].Status)
+
+	client.err = errors.New("connection refused")
+	rec, report = getReady(h)
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "eon key unavailable", report.Checks["eonKey"].Error, "the error details should not be exposed")
+}
+
+func TestHealthChecker_Live(t *testing.T) {
+	client := &healthClient{err: errors.New("connection refused")}
+	h := NewHealthChecker(newHealthProcessor(t, client), nil, nil)
+
+	rec := httptest.NewRecorder()
+	h.Live(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusOK, rec.Code, "liveness does not depend on the upstream")
+}
@@ -1,9 +1,16 @@
 package server
 
 import (
+	"bytes"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"strings"
+
+	"github.com/shutter-network/encrypting-rpc-server/upstream"
+	"github.com/shutter-network/encrypting-rpc-server/utils"
 )
 
 type StripCORSHeaders struct {
@@ -19,10 +26,20 @@ func (s *StripCORSHeaders) RoundTrip(req *http.Request) (*http.Response, error)
 	resp.Header.Del("Access-Control-Allow-Origin")
 	resp.Header.Del("Access-Control-Allow-Methods")
 	resp.Header.Del("Access-Control-Allow-Headers")
+	resp.Header.Del("Access-Control-Allow-Credentials")
+	resp.Header.Del("Access-Control-Expose-Headers")
+	resp.Header.Del("Access-Control-Max-Age")
 
 	return resp, nil
 }
 
+// setRequestIDHeader passes the id of the incoming request upstream.
+func setRequestIDHeader(r *httputil.ProxyRequest) {
+	if id := utils.RequestID(r.In.Context()); id != "" {
+		r.Out.Header.Set(utils.RequestIDHeader, id)
+	}
+}
+
 func NewReverseProxy(target *url.URL) *httputil.ReverseProxy {
 	transport := &http.Transport{}
 
@@ -32,12 +49,112 @@ func NewReverseProxy(target *url.URL) *httputil.ReverseProxy {
 
 	rewriteFunc := func(r *httputil.ProxyRequest) {
 		r.SetURL(target)
+		setRequestIDHeader(r)
 	}
 
 	proxy := &httputil.ReverseProxy{
-		Rewrite:   rewriteFunc,
-		Transport: stripTransport,
+		Rewrite:      rewriteFunc,
+		Transport:    stripTransport,
+		ErrorHandler: upstreamErrorHandler,
 	}
 
 	return proxy
-}
\ No newline at end of file
+}
+
+// FailoverTransport sends a request to the preferred upstream of the pool and retries it with the
+// next upstream if the upstream can not be reached or responds with a server error. If all of them
+// fail, the error is returned so that the proxy answers with a JSON-RPC error.
+type FailoverTransport struct {
+	Pool      *upstream.Pool
+	Transport http.RoundTripper
+}
+
+func (f *FailoverTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+	}
+
+	candidates := f.Pool.Candidates()
+	var lastErr error
+	for _, u := range candidates {
+		out := req.Clone(req.Context())
+		out.URL = targetURL(u.URL, req.URL)
+		out.Host = ""
+		out.Body = io.NopCloser(bytes.NewReader(body))
+
+		resp, err := f.Transport.RoundTrip(out)
+		if err == nil && resp.StatusCode < http.StatusInternalServerError && resp.StatusCode != http.StatusTooManyRequests {
+			u.RecordResult(nil)
+			return resp, nil
+		}
+
+		if err == nil {
+			// the error body of the last upstream is not passed on, the client gets a JSON-RPC error
+			err = fmt.Errorf("upstream responded with status %s", resp.Status)
+			resp.Body.Close()
+		}
+		u.RecordResult(err)
+		lastErr = err
+		if req.Context().Err() != nil {
+			break
+		}
+		utils.Logger.Info().Err(err).Str("upstream", u.Name).Msg("upstream request failed, trying next upstream")
+	}
+	return nil, lastErr
+}
+
+// targetURL rewrites the url of an incoming request to point to target, the same way as
+// httputil.ProxyRequest.SetURL does.
+func targetURL(target, in *url.URL) *url.URL {
+	out := *in
+	out.Scheme = target.Scheme
+	out.Host = target.Host
+	out.Path = singleJoiningSlash(target.Path, in.Path)
+	out.RawPath = ""
+	if target.RawQuery == "" || in.RawQuery == "" {
+		out.RawQuery = target.RawQuery + in.RawQuery
+	} else {
+		out.RawQuery = target.RawQuery + "&" + in.RawQuery
+	}
+	return &out
+}
+
+func singleJoiningSlash(a, b string) string {
+	aslash := strings.HasSuffix(a, "/")
+	bslash := strings.HasPrefix(b, "/")
+	switch {
+	case aslash && bslash:
+		return a + b[1:]
+	case !aslash && !bslash:
+		return a + "/" + b
+	}
+	return a + b
+}
+
+func NewPoolReverseProxy(pool *upstream.Pool) *httputil.ReverseProxy {
+	stripTransport := &StripCORSHeaders{
+		Transport: &FailoverTransport{
+			Pool:      pool,
+			Transport: &http.Transport{},
+		},
+	}
+
+	// the outgoing url is set by the transport once the upstream is selected
+	rewriteFunc := func(r *httputil.ProxyRequest) {
+		setRequestIDHeader(r)
+	}
+
+	proxy := &httputil.ReverseProxy{
+		Rewrite:      rewriteFunc,
+		Transport:    stripTransport,
+		ErrorHandler: upstreamErrorHandler,
+	}
+
+	return proxy
+}
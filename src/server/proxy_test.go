@@ -0,0 +1,112 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shutter-network/encrypting-rpc-server/upstream"
+	"github.com/shutter-network/encrypting-rpc-server/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPoolReverseProxy_Failover(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Bad gateway", http.StatusBadGateway)
+	}))
+	defer failing.Close()
+
+	var receivedPath string
+	var receivedBody []byte
+	working := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		receivedPath = r.URL.Path
+		receivedBody, _ = io.ReadAll(r.Body)
+		w.Header().Set("Access-Control-Allow-Origin", "https://example.com")
+		_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`))
+	}))
+	defer working.Close()
+
+	pool, err := upstream.NewPool([]string{failing.URL, working.URL + "/key"}, upstream.Config{HealthCheckInterval: time.Second})
+	require.NoError(t, err)
+
+	body := `{"jsonrpc":"2.0","id":1,"method":"eth_chainId","params":[]}`
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(body))
+	rec := httptest.NewRecorder()
+	NewPoolReverseProxy(pool).ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, `{"jsonrpc":"2.0","id":1,"result":"0x1"}`, rec.Body.String())
+	assert.Equal(t, "/key/", receivedPath)
+	assert.Equal(t, body, string(receivedBody))
+	assert.Empty(t, rec.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func TestPoolReverseProxy_AllUpstreamsFail(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "<html>Bad gateway</html>", http.StatusBadGateway)
+	}))
+	defer failing.Close()
+
+	// the same host twice, with different keys in the path
+	pool, err := upstream.NewPool([]string{failing.URL + "/first", failing.URL + "/second"}, upstream.Config{HealthCheckInterval: time.Second})
+	require.NoError(t, err)
+	upstreams := pool.Upstreams()
+	assert.NotEqual(t, upstreams[0].Name, upstreams[1].Name, "upstreams on the same host should be told apart")
+	assert.NotContains(t, upstreams[1].Name, "second", "the path might contain a key")
+
+	body := `{"jsonrpc":"2.0","id":1,"method":"eth_chainId","params":[]}`
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(body))
+	rec := httptest.NewRecorder()
+	NewPoolReverseProxy(pool).ServeHTTP(rec, req)
+
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.Contains(t, rec.Body.String(), `"upstream unavailable"`)
+	assert.NotContains(t, rec.Body.String(), "html")
+}
+
+func TestReverseProxy_RequestID(t *testing.T) {
+	var received []string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = append(received, r.Header.Get(utils.RequestIDHeader))
+		_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`))
+	}))
+	defer backend.Close()
+	target, err := url.Parse(backend.URL)
+	require.NoError(t, err)
+
+	p := &JSONRPCProxy{backend: NewReverseProxy(target), processor: &stubHandler{name: "processor"}}
+	handler := RequestID(p)
+
+	send := func(header string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(`{"jsonrpc":"2.0","id":1,"method":"eth_chainId"}`))
+		if header != "" {
+			req.Header.Set(utils.RequestIDHeader, header)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := send("")
+	generated := rec.Header().Get(utils.RequestIDHeader)
+	assert.NotEmpty(t, generated, "an id should be generated")
+
+	rec = send("client-id")
+	assert.Equal(t, "client-id", rec.Header().Get(utils.RequestIDHeader), "the id of the client should be kept")
+
+	assert.Equal(t, []string{generated, "client-id"}, received, "the id should be sent upstream")
+
+	for _, invalid := range []string{strings.Repeat("a", 65), "id with spaces", "id\"quoted"} {
+		rec = send(invalid)
+		replaced := rec.Header().Get(utils.RequestIDHeader)
+		assert.NotEmpty(t, replaced, "an id should be generated for %q", invalid)
+		assert.NotEqual(t, invalid, replaced)
+		assert.Equal(t, replaced, received[len(received)-1], "the generated id should be sent upstream")
+	}
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/shutter-network/encrypting-rpc-server/ratelimit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJSONRPCProxy_IPRateLimit(t *testing.T) {
+	p := &JSONRPCProxy{
+		backend:   &stubHandler{name: "backend"},
+		processor: &stubHandler{name: "processor"},
+		ipLimiter: ratelimit.NewKeyedLimiter(),
+		ipLimit:   0.001,
+		ipBurst:   1,
+	}
+
+	call := func(remoteAddr string, method string) batchResponse {
+		body := `{"jsonrpc":"2.0","id":1,"method":"` + method + `","params":[]}`
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(body))
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, req)
+		var resp batchResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		return resp
+	}
+
+	assert.Nil(t, call("10.0.0.1:1234", "eth_sendRawTransaction").Error)
+	resp := call("10.0.0.1:5678", "eth_sendRawTransaction")
+	require.NotNil(t, resp.Error, "the ip should be limited regardless of the port")
+	assert.Equal(t, -32005, resp.Error.Code)
+
+	assert.Nil(t, call("10.0.0.1:1234", "eth_chainId").Error, "only submissions should be limited")
+	assert.Nil(t, call("10.0.0.2:1234", "eth_sendRawTransaction").Error)
+}
+
+func TestJSONRPCProxy_Draining(t *testing.T) {
+	p := &JSONRPCProxy{backend: &stubHandler{name: "backend"}, processor: &stubHandler{name: "processor"}}
+	p.draining.Store(true)
+
+	rec := serveBody(p, `{"jsonrpc":"2.0","id":1,"method":"eth_sendRawTransaction","params":["0x00"]}`)
+	var resp batchResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	require.NotNil(t, resp.Error, "submissions should be rejected during shutdown")
+	assert.Equal(t, errCodeServer, resp.Error.Code)
+
+	rec = serveBody(p, `{"jsonrpc":"2.0","id":1,"method":"eth_chainId","params":[]}`)
+	resp = batchResponse{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Nil(t, resp.Error, "other calls should still be served")
+}
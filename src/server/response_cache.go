@@ -0,0 +1,262 @@
+package server
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/shutter-network/encrypting-rpc-server/metrics"
+	"github.com/shutter-network/encrypting-rpc-server/utils"
+)
+
+type HeadSource interface {
+	BlockNumber(ctx context.Context) (uint64, error)
+}
+
+type responseCacheEntry struct {
+	key     string
+	result  json.RawMessage
+	expires time.Time
+	latest  bool
+}
+
+// ResponseCache caches results of upstream calls. Only methods with a configured TTL are cached.
+// Entries which depend on the latest block are dropped on every new block, entries for blocks and
+// receipts are only stored once they are at least finalityDepth blocks deep. At most maxEntries are
+// kept, the least recently used entry is dropped for a new one.
+type ResponseCache struct {
+	mu            sync.Mutex
+	entries       map[string]*list.Element
+	lru           *list.List // of *responseCacheEntry, most recently used first
+	maxEntries    int
+	ttls          map[string]time.Duration
+	finalityDepth uint64
+	head          uint64
+}
+
+func NewResponseCache(ttls map[string]time.Duration, finalityDepth uint64, maxEntries int) *ResponseCache {
+	return &ResponseCache{
+		entries:       make(map[string]*list.Element),
+		lru:           list.New(),
+		maxEntries:    maxEntries,
+		ttls:          ttls,
+		finalityDepth: finalityDepth,
+	}
+}
+
+// NewHead drops all entries that depend on the latest block if the head advanced.
+func (c *ResponseCache) NewHead(blockNumber uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if blockNumber <= c.head {
+		return
+	}
+	c.head = blockNumber
+	for _, elem := range c.entries {
+		entry := elem.Value.(*responseCacheEntry)
+		if entry.latest || time.Now().After(entry.expires) {
+			c.remove(elem)
+		}
+	}
+}
+
+// remove must be called with mu held.
+func (c *ResponseCache) remove(elem *list.Element) {
+	c.lru.Remove(elem)
+	delete(c.entries, elem.Value.(*responseCacheEntry).key)
+}
+
+func (c *ResponseCache) Head() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.head
+}
+
+// WatchHead polls the block number and invalidates the latest entries on every new block.
+func (c *ResponseCache) WatchHead(ctx context.Context, source HeadSource, interval time.Duration) {
+	timer := time.NewTicker(interval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			blockNumber, err := source.BlockNumber(ctx)
+			if err != nil {
+				utils.Logger.Debug().Err(err).Msg("failed to fetch block number for response cache")
+				continue
+			}
+			c.NewHead(blockNumber)
+		}
+	}
+}
+
+type cacheableCall struct {
+	key    string
+	method string
+	latest bool
+}
+
+// cacheable decides if a call can be served from the cache. For calls whose result only becomes
+// immutable once the block is final, the result is checked again in store.
+func (c *ResponseCache) cacheable(method string, body []byte) (cacheableCall, bool) {
+	if _, ok := c.ttls[method]; !ok {
+		return cacheableCall{}, false
+	}
+
+	var req struct {
+		Params []json.RawMessage `json:"params"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return cacheableCall{}, false
+	}
+
+	call := cacheableCall{method: method}
+	switch method {
+	case "eth_blockNumber":
+		call.latest = true
+	case "eth_getBlockByNumber":
+		if len(req.Params) == 0 {
+			return cacheableCall{}, false
+		}
+		var tag string
+		if err := json.Unmarshal(req.Params[0], &tag); err != nil {
+			return cacheableCall{}, false
+		}
+		switch tag {
+		case "latest":
+			call.latest = true
+		case "pending", "safe", "finalized", "earliest":
+			return cacheableCall{}, false
+		}
+	}
+
+	params, err := json.Marshal(req.Params)
+	if err != nil {
+		return cacheableCall{}, false
+	}
+	call.key = method + string(params)
+	return call, true
+}
+
+func (c *ResponseCache) get(call cacheableCall) (json.RawMessage, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[call.key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*responseCacheEntry)
+	if time.Now().After(entry.expires) {
+		c.remove(elem)
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	return entry.result, true
+}
+
+// final reports whether a block is deep enough to not be affected by reorgs.
+func (c *ResponseCache) final(blockNumber uint64) bool {
+	return c.head > 0 && blockNumber+c.finalityDepth <= c.head
+}
+
+func (c *ResponseCache) store(call cacheableCall, result json.RawMessage) {
+	if len(result) == 0 || bytes.Equal(result, []byte("null")) {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !call.latest {
+		switch call.method {
+		case "eth_getBlockByNumber", "eth_getBlockByHash", "eth_getTransactionReceipt":
+			var block struct {
+				Number      *hexutil.Uint64 `json:"number"`
+				BlockNumber *hexutil.Uint64 `json:"blockNumber"`
+			}
+			if err := json.Unmarshal(result, &block); err != nil {
+				return
+			}
+			number := block.Number
+			if number == nil {
+				number = block.BlockNumber
+			}
+			if number == nil || !c.final(uint64(*number)) {
+				return
+			}
+		}
+	}
+
+	entry := &responseCacheEntry{
+		key:     call.key,
+		result:  result,
+		expires: time.Now().Add(c.ttls[call.method]),
+		latest:  call.latest,
+	}
+	if elem, ok := c.entries[call.key]; ok {
+		elem.Value = entry
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.entries[call.key] = c.lru.PushFront(entry)
+	for c.maxEntries > 0 && c.lru.Len() > c.maxEntries {
+		c.remove(c.lru.Back())
+	}
+}
+
+// serve answers a call from the cache or forwards it to the backend and caches the result.
+func (c *ResponseCache) serve(w http.ResponseWriter, r *http.Request, call cacheableCall, body []byte, backend http.Handler) {
+	id := requestID(body)
+	if result, ok := c.get(call); ok {
+		metrics.ResponseCacheHits.WithLabelValues(call.method).Inc()
+		resp, _ := json.Marshal(struct {
+			Version string          `json:"jsonrpc"`
+			ID      json.RawMessage `json:"id"`
+			Result  json.RawMessage `json:"result"`
+		}{Version: "2.0", ID: id, Result: result})
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(resp)
+		return
+	}
+	metrics.ResponseCacheMisses.WithLabelValues(call.method).Inc()
+
+	// the response has to be readable to be cached
+	r.Header.Del("Accept-Encoding")
+	rec := newResponseRecorder()
+	backend.ServeHTTP(rec, r)
+
+	if rec.status == http.StatusOK && rec.header.Get("Content-Encoding") == "" {
+		var resp struct {
+			Result json.RawMessage `json:"result"`
+			Error  json.RawMessage `json:"error"`
+		}
+		if err := json.Unmarshal(rec.body.Bytes(), &resp); err == nil && resp.Error == nil {
+			c.store(call, resp.Result)
+		}
+	}
+
+	for key, values := range rec.header {
+		w.Header()[key] = values
+	}
+	w.WriteHeader(rec.status)
+	_, _ = w.Write(rec.body.Bytes())
+}
+
+// ParseCacheTTLs converts a map of method names to TTLs in seconds.
+func ParseCacheTTLs(ttls map[string]int) map[string]time.Duration {
+	durations := make(map[string]time.Duration, len(ttls))
+	for method, seconds := range ttls {
+		if seconds > 0 {
+			durations[strings.TrimSpace(method)] = time.Duration(seconds) * time.Second
+		}
+	}
+	return durations
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingBackend answers calls with a fixed result and counts how often it was called.
+type countingBackend struct {
+	result string
+	calls  int
+}
+
+func (b *countingBackend) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.calls++
+	var req struct {
+		ID json.RawMessage `json:"id"`
+	}
+	body, _ := io.ReadAll(r.Body)
+	_ = json.Unmarshal(body, &req)
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":` + string(req.ID) + `,"result":` + b.result + `}`))
+}
+
+func newCachingProxy(backend http.Handler) *JSONRPCProxy {
+	return &JSONRPCProxy{
+		backend:   backend,
+		processor: &stubHandler{name: "processor"},
+		cache: NewResponseCache(map[string]time.Duration{
+			"eth_chainId":               time.Hour,
+			"eth_blockNumber":           time.Hour,
+			"eth_getBlockByNumber":      time.Hour,
+			"eth_getBlockByHash":        time.Hour,
+			"eth_getTransactionReceipt": time.Hour,
+		}, 10, 3),
+	}
+}
+
+func TestResponseCache_Hit(t *testing.T) {
+	backend := &countingBackend{result: `"0x64"`}
+	p := newCachingProxy(backend)
+
+	serveBody(p, `{"jsonrpc":"2.0","id":1,"method":"eth_chainId","params":[]}`)
+	rec := serveBody(p, `{"jsonrpc":"2.0","id":"second","method":"eth_chainId","params":[]}`)
+
+	assert.Equal(t, 1, backend.calls)
+	var resp batchResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Equal(t, `"second"`, string(resp.ID), "cached response should carry the id of the request")
+	assert.Equal(t, "0x64", resp.Result)
+}
+
+func TestResponseCache_UncachedMethod(t *testing.T) {
+	backend := &countingBackend{result: `"0x1"`}
+	p := newCachingProxy(backend)
+
+	serveBody(p, `{"jsonrpc":"2.0","id":1,"method":"eth_getBalance","params":["0x0","latest"]}`)
+	serveBody(p, `{"jsonrpc":"2.0","id":1,"method":"eth_getBalance","params":["0x0","latest"]}`)
+
+	assert.Equal(t, 2, backend.calls)
+}
+
+func TestResponseCache_LatestInvalidatedOnNewBlock(t *testing.T) {
+	backend := &countingBackend{result: `"0x64"`}
+	p := newCachingProxy(backend)
+	p.cache.NewHead(100)
+
+	serveBody(p, `{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber","params":[]}`)
+	serveBody(p, `{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber","params":[]}`)
+	assert.Equal(t, 1, backend.calls)
+
+	p.cache.NewHead(101)
+	serveBody(p, `{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber","params":[]}`)
+	assert.Equal(t, 2, backend.calls)
+}
+
+func TestResponseCache_OnlyFinalBlocks(t *testing.T) {
+	backend := &countingBackend{result: `{"number":"0x5a","hash":"0x01"}`}
+	p := newCachingProxy(backend)
+	p.cache.NewHead(95)
+
+	// block 90 is less than 10 blocks deep
+	serveBody(p, `{"jsonrpc":"2.0","id":1,"method":"eth_getBlockByNumber","params":["0x5a",false]}`)
+	serveBody(p, `{"jsonrpc":"2.0","id":1,"method":"eth_getBlockByNumber","params":["0x5a",false]}`)
+	assert.Equal(t, 2, backend.calls)
+
+	p.cache.NewHead(100)
+	serveBody(p, `{"jsonrpc":"2.0","id":1,"method":"eth_getBlockByNumber","params":["0x5a",false]}`)
+	serveBody(p, `{"jsonrpc":"2.0","id":1,"method":"eth_getBlockByNumber","params":["0x5a",false]}`)
+	assert.Equal(t, 3, backend.calls)
+}
+
+func TestResponseCache_NullNotCached(t *testing.T) {
+	backend := &countingBackend{result: `null`}
+	p := newCachingProxy(backend)
+	p.cache.NewHead(100)
+
+	serveBody(p, `{"jsonrpc":"2.0","id":1,"method":"eth_getTransactionReceipt","params":["0x01"]}`)
+	serveBody(p, `{"jsonrpc":"2.0","id":1,"method":"eth_getTransactionReceipt","params":["0x01"]}`)
+	assert.Equal(t, 2, backend.calls)
+}
+
+func TestResponseCache_OnlyFinalBlocksByHash(t *testing.T) {
+	backend := &countingBackend{result: `{"number":"0x5a","hash":"0x01"}`}
+	p := newCachingProxy(backend)
+	p.cache.NewHead(95)
+
+	serveBody(p, `{"jsonrpc":"2.0","id":1,"method":"eth_getBlockByHash","params":["0x01",false]}`)
+	serveBody(p, `{"jsonrpc":"2.0","id":1,"method":"eth_getBlockByHash","params":["0x01",false]}`)
+	assert.Equal(t, 2, backend.calls, "a block which is not final might be reorged")
+
+	p.cache.NewHead(100)
+	serveBody(p, `{"jsonrpc":"2.0","id":1,"method":"eth_getBlockByHash","params":["0x01",false]}`)
+	serveBody(p, `{"jsonrpc":"2.0","id":1,"method":"eth_getBlockByHash","params":["0x01",false]}`)
+	assert.Equal(t, 3, backend.calls)
+}
+
+func TestResponseCache_EvictsLeastRecentlyUsed(t *testing.T) {
+	backend := &countingBackend{result: `{"number":"0x5a","hash":"0x01"}`}
+	p := newCachingProxy(backend)
+	p.cache.NewHead(100)
+	call := func(hash string) {
+		serveBody(p, `{"jsonrpc":"2.0","id":1,"method":"eth_getBlockByHash","params":["`+hash+`",false]}`)
+	}
+
+	// the cache holds 3 entries
+	call("0x01")
+	call("0x02")
+	call("0x03")
+	call("0x01")
+	call("0x04")
+	assert.Equal(t, 4, backend.calls)
+
+	call("0x01")
+	assert.Equal(t, 4, backend.calls, "recently used entries should be kept")
+	call("0x02")
+	assert.Equal(t, 5, backend.calls, "the least recently used entry should be dropped")
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+)
+
+const (
+	RouteToProcessor = "processor"
+	RouteToBackend   = "backend"
+	RouteReject      = "reject"
+
+	DefaultBackend = "default"
+)
+
+// Route decides where calls to a method go. Method is either a full method name or a prefix
+// ending with "*", a single "*" matches every method.
+type Route struct {
+	Method  string `json:"method"`
+	Action  string `json:"action"`
+	Backend string `json:"backend,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// RoutingConfig is the content of the routing config file. Backends maps names to a list of
+// upstream urls which can be referenced by routes.
+type RoutingConfig struct {
+	Backends map[string][]string `json:"backends"`
+	Routes   []Route             `json:"routes"`
+}
+
+var defaultRoutes = []Route{
+	{Method: "eth_sendTransaction", Action: RouteToProcessor},
+	{Method: "eth_sendRawTransaction", Action: RouteToProcessor},
+	{Method: "eth_gasPrice", Action: RouteToProcessor},
+	{Method: "shutter_*", Action: RouteToProcessor},
+	{Method: "*", Action: RouteToBackend, Backend: DefaultBackend},
+}
+
+type RoutingTable struct {
+	exact    map[string]Route
+	prefixes []Route // sorted by descending prefix length
+}
+
+func LoadRoutingConfig(path string) (*RoutingConfig, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	config := &RoutingConfig{}
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(config); err != nil {
+		return nil, fmt.Errorf("failed to parse routing config | err: %w", err)
+	}
+	return config, nil
+}
+
+// NewRoutingTable builds a routing table from the default routes and the given routes. Later
+// routes replace earlier ones for the same method pattern.
+func NewRoutingTable(routes []Route, backends map[string][]string) (*RoutingTable, error) {
+	table := &RoutingTable{exact: make(map[string]Route)}
+	prefixes := make(map[string]Route)
+
+	for _, route := range append(append([]Route{}, defaultRoutes...), routes...) {
+		switch route.Action {
+		case RouteToProcessor, RouteReject:
+		case RouteToBackend:
+			if route.Backend == "" {
+				route.Backend = DefaultBackend
+			}
+			if _, ok := backends[route.Backend]; !ok && route.Backend != DefaultBackend {
+				return nil, fmt.Errorf("route for %s references unknown backend %s", route.Method, route.Backend)
+			}
+		default:
+			return nil, fmt.Errorf("route for %s has unknown action %q", route.Method, route.Action)
+		}
+
+		if prefix, ok := strings.CutSuffix(route.Method, "*"); ok {
+			prefixes[prefix] = route
+		} else {
+			table.exact[route.Method] = route
+		}
+	}
+
+	for _, route := range prefixes {
+		table.prefixes = append(table.prefixes, route)
+	}
+	sort.Slice(table.prefixes, func(i, j int) bool {
+		return len(table.prefixes[i].Method) > len(table.prefixes[j].Method)
+	})
+	return table, nil
+}
+
+// Lookup returns the route for a method. Exact matches take precedence over the longest
+// matching prefix.
+func (t *RoutingTable) Lookup(method string) Route {
+	if route, ok := t.exact[method]; ok {
+		return route
+	}
+	for _, route := range t.prefixes {
+		if strings.HasPrefix(method, strings.TrimSuffix(route.Method, "*")) {
+			return route
+		}
+	}
+	// unreachable as the default routes contain a catch-all
+	return Route{Method: "*", Action: RouteToBackend, Backend: DefaultBackend}
+}
+
+var defaultRoutingTable, _ = NewRoutingTable(nil, nil)
+
+// rejectHandler answers every call with a JSON-RPC error without forwarding it.
+type rejectHandler struct {
+	message string
+}
+
+func (h *rejectHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	writeError(w, requestID(body), errCodeMethodNotFound, h.message)
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoutingTable_Lookup(t *testing.T) {
+	table, err := NewRoutingTable([]Route{
+		{Method: "debug_*", Action: RouteReject},
+		{Method: "debug_traceTransaction", Action: RouteToBackend, Backend: "archive"},
+		{Method: "trace_*", Action: RouteToBackend, Backend: "archive"},
+		{Method: "trace_block*", Action: RouteReject},
+	}, map[string][]string{"archive": {"http://localhost:8545"}})
+	require.NoError(t, err)
+
+	assert.Equal(t, RouteToProcessor, table.Lookup("eth_sendRawTransaction").Action)
+	assert.Equal(t, RouteToProcessor, table.Lookup("shutter_getTransactionStatus").Action)
+	assert.Equal(t, RouteReject, table.Lookup("debug_getRawBlock").Action)
+	assert.Equal(t, "archive", table.Lookup("debug_traceTransaction").Backend)
+	assert.Equal(t, "archive", table.Lookup("trace_call").Backend)
+	assert.Equal(t, RouteReject, table.Lookup("trace_blockNumber").Action, "longest prefix should win")
+
+	route := table.Lookup("eth_chainId")
+	assert.Equal(t, RouteToBackend, route.Action)
+	assert.Equal(t, DefaultBackend, route.Backend)
+}
+
+func TestRoutingTable_Invalid(t *testing.T) {
+	_, err := NewRoutingTable([]Route{{Method: "trace_*", Action: RouteToBackend, Backend: "archive"}}, nil)
+	assert.Error(t, err, "unknown backend should be rejected")
+
+	_, err = NewRoutingTable([]Route{{Method: "trace_*", Action: "drop"}}, nil)
+	assert.Error(t, err, "unknown action should be rejected")
+}
+
+func TestLoadRoutingConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "routing.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{
+		"backends": {"archive": ["http://localhost:8545"]},
+		"routes": [{"method": "admin_*", "action": "reject", "message": "not allowed"}]
+	}`), 0o600))
+
+	config, err := LoadRoutingConfig(path)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"http://localhost:8545"}, config.Backends["archive"])
+	assert.Equal(t, Route{Method: "admin_*", Action: RouteReject, Message: "not allowed"}, config.Routes[0])
+}
+
+func TestJSONRPCProxy_Routes(t *testing.T) {
+	table, err := NewRoutingTable([]Route{
+		{Method: "admin_*", Action: RouteReject},
+		{Method: "trace_*", Action: RouteToBackend, Backend: "archive"},
+	}, map[string][]string{"archive": {"http://localhost:8545"}})
+	require.NoError(t, err)
+
+	p := &JSONRPCProxy{
+		backend:   &stubHandler{name: "backend"},
+		backends:  map[string]http.Handler{"archive": &stubHandler{name: "archive"}},
+		processor: &stubHandler{name: "processor"},
+		routes:    table,
+	}
+
+	rec := serveBody(p, `[
+		{"jsonrpc":"2.0","id":1,"method":"admin_peers","params":[]},
+		{"jsonrpc":"2.0","id":2,"method":"trace_block","params":["latest"]},
+		{"jsonrpc":"2.0","id":3,"method":"eth_chainId","params":[]}
+	]`)
+
+	var responses []batchResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &responses))
+	require.Len(t, responses, 3)
+	require.NotNil(t, responses[0].Error)
+	assert.Equal(t, -32601, responses[0].Error.Code)
+	assert.Equal(t, "1", string(responses[0].ID))
+	assert.Equal(t, "archive:trace_block", responses[1].Result)
+	assert.Equal(t, "backend:eth_chainId", responses[2].Result)
+}
@@ -5,11 +5,15 @@ import (
 	"context"
 	"encoding/json"
 	"io"
+	"net"
 	"net/http"
+	"sync/atomic"
 	"time"
 
 	"github.com/shutter-network/encrypting-rpc-server/db"
 	"github.com/shutter-network/encrypting-rpc-server/metrics"
+	"github.com/shutter-network/encrypting-rpc-server/ratelimit"
+	"github.com/shutter-network/encrypting-rpc-server/upstream"
 	"github.com/shutter-network/encrypting-rpc-server/utils"
 
 	ethrpc "github.com/ethereum/go-ethereum/rpc"
@@ -23,63 +27,177 @@ import (
 	medleyService "github.com/shutter-network/rolling-shutter/rolling-shutter/medley/service"
 )
 
+// rateLimiterIdleTimeout is how long the bucket of an IP or sender is kept after its last request.
+const rateLimiterIdleTimeout = 10 * time.Minute
+
+// submissionCheckInterval is how often submissions to the sequencer are checked for being stuck.
+const submissionCheckInterval = 5 * time.Second
+
+// spendingMetricsInterval is how often the remaining spending budgets are updated as spendings age.
+const spendingMetricsInterval = time.Minute
+
 type JSONRPCProxy struct {
 	backend   http.Handler
+	backends  map[string]http.Handler
 	processor http.Handler
+	routes    *RoutingTable
+	cache     *ResponseCache
+	apiKeys   *APIKeyStore
+	ipLimiter *ratelimit.KeyedLimiter
+	ipLimit   float64
+	ipBurst   int
+	cors      *CORSPolicies
+	draining  atomic.Bool
+
+	// maxBodySize limits the size of request bodies in bytes, zero means unlimited
+	maxBodySize int64
+	// maxBatchSize limits the number of calls in a batch, zero means unlimited
+	maxBatchSize int
+}
+
+func (p *JSONRPCProxy) Route(method string) Route {
+	if p.routes == nil {
+		return defaultRoutingTable.Lookup(method)
+	}
+	return p.routes.Lookup(method)
 }
 
 func (p *JSONRPCProxy) SelectHandler(method string) http.Handler {
-	// route the eth_namespace to the l2-backend
-	switch method {
-	case "eth_sendTransaction":
-		return p.processor
-	case "eth_sendRawTransaction":
-		return p.processor
-	case "eth_gasPrice":
+	route := p.Route(method)
+	switch route.Action {
+	case RouteToProcessor:
 		return p.processor
+	case RouteReject:
+		message := route.Message
+		if message == "" {
+			message = "the method " + method + " is not available"
+		}
+		return &rejectHandler{message: message}
 	default:
+		if backend, ok := p.backends[route.Backend]; ok {
+			return backend
+		}
 		return p.backend
 	}
 }
 
 func (p *JSONRPCProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if p.maxBodySize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, p.maxBodySize)
+	}
 	body, err := io.ReadAll(r.Body)
 
 	if err != nil {
-		http.Error(w, "Bad request", http.StatusBadRequest)
+		code, message := readError(err)
+		writeError(w, nil, code, message)
+		return
+	}
+
+	if isBatch(body) {
+		p.serveBatch(w, r, body)
 		return
 	}
 
 	rpcreq := medley.RPCRequest{}
 	err = json.Unmarshal(body, &rpcreq)
 	if err != nil {
-		http.Error(w, "Bad request", http.StatusBadRequest)
+		code, message := decodeError(body)
+		writeError(w, requestID(body), code, message)
+		return
+	}
+	if rpcreq.Method == "" {
+		writeError(w, requestID(body), errCodeInvalidRequest, "invalid request")
+		return
+	}
+
+	p.serveRequest(w, r, rpcreq, body)
+}
+
+// allowIP takes a token from the submission bucket of the client IP of r.
+func (p *JSONRPCProxy) allowIP(r *http.Request) bool {
+	if p.ipLimiter == nil {
+		return true
+	}
+	return p.ipLimiter.Allow(clientIP(r), p.ipLimit/60, p.ipBurst)
+}
+
+// clientIP returns the IP address of the client without the port. With trusted proxy headers
+// RemoteAddr has already been replaced by the address from X-Forwarded-For or X-Real-IP.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// serveRequest dispatches a single JSON-RPC call to the processor or the backend.
+func (p *JSONRPCProxy) serveRequest(w http.ResponseWriter, r *http.Request, rpcreq medley.RPCRequest, body []byte) {
+	logger := utils.ContextLogger(r.Context())
+	if p.apiKeys != nil {
+		if ok, message := p.apiKeys.CheckQuota(r.Context(), rpcreq.Method); !ok {
+			logger.Info().Str("method", rpcreq.Method).Uint("api-key-id", utils.APIKeyID(r.Context())).Msg(message)
+			metrics.RateLimitedRequests.WithLabelValues("api_key").Inc()
+			writeError(w, requestID(body), errCodeLimitExceeded, message)
+			return
+		}
+	}
+
+	if submissionMethods[rpcreq.Method] && p.draining.Load() {
+		logger.Info().Str("method", rpcreq.Method).Msg("rejecting submission during shutdown")
+		writeError(w, requestID(body), errCodeServer, "server is shutting down")
+		return
+	}
+
+	if submissionMethods[rpcreq.Method] && !p.allowIP(r) {
+		logger.Info().Str("method", rpcreq.Method).Str("ip", clientIP(r)).Msg("ip rate limit exceeded")
+		metrics.RateLimitedRequests.WithLabelValues("ip").Inc()
+		writeError(w, requestID(body), errCodeLimitExceeded, "ip rate limit exceeded")
 		return
 	}
 
+	route := p.Route(rpcreq.Method)
 	selectedHandler := p.SelectHandler(rpcreq.Method)
 
-	if selectedHandler == p.processor {
-		utils.Logger.Info().Str("method", rpcreq.Method).Msg("dispatching to processor")
-	} else {
-		utils.Logger.Info().Str("method", rpcreq.Method).Msg("dispatching to backend")
+	switch route.Action {
+	case RouteToProcessor:
+		logger.Info().Str("method", rpcreq.Method).Msg("dispatching to processor")
+	case RouteReject:
+		logger.Info().Str("method", rpcreq.Method).Msg("rejecting call")
+	default:
+		logger.Info().Str("method", rpcreq.Method).Str("backend", route.Backend).Msg("dispatching to backend")
 	}
 
 	// make the body available again before letting reverse proxy handle the rest
+	r = r.WithContext(withRPCID(r.Context(), requestID(body)))
 	r.Body = io.NopCloser(bytes.NewBuffer(body))
 	startTime := time.Now()
 
-	selectedHandler.ServeHTTP(w, r)
+	if call, ok := p.cacheable(route, rpcreq.Method, body); ok {
+		p.cache.serve(w, r, call, body, selectedHandler)
+	} else {
+		selectedHandler.ServeHTTP(w, r)
+	}
 
-	if selectedHandler == p.backend {
+	if route.Action == RouteToBackend {
 		metrics.UpstreamRequestDuration.WithLabelValues(rpcreq.Method).Observe(time.Since(startTime).Seconds())
 	}
 }
 
+// cacheable reports whether the response of a call can be served from the response cache. Only calls
+// for the default backend are cached.
+func (p *JSONRPCProxy) cacheable(route Route, method string, body []byte) (cacheableCall, bool) {
+	if p.cache == nil || route.Action != RouteToBackend || route.Backend != DefaultBackend {
+		return cacheableCall{}, false
+	}
+	return p.cache.cacheable(method, body)
+}
+
 type server struct {
 	processor        rpc.Processor
 	config           rpc.Config
 	postgresDatabase *db.PostgresDb
+	ethService       *rpc.EthService
 }
 
 func NewRPCService(processor rpc.Processor, config rpc.Config, pgDb *db.PostgresDb) medleyService.Service {
@@ -90,16 +208,18 @@ func NewRPCService(processor rpc.Processor, config rpc.Config, pgDb *db.Postgres
 	}
 }
 
-func (srv *server) rpcHandler(ctx context.Context) (http.Handler, error) {
+func (srv *server) rpcHandler(ctx context.Context) (*JSONRPCProxy, error) {
+	ethService := &rpc.EthService{}
+	srv.ethService = ethService
 	rpcServices := []rpc.RPCService{
-		&rpc.EthService{},
+		ethService,
 	}
 
 	rpcServer := ethrpc.NewServer()
 	for _, service := range rpcServices {
 		service.Init(srv.processor, srv.config)
 		go service.SendTimeEvents(ctx, srv.config.DelayInSeconds)
-		if srv.processor.MetricsConfig.Enabled {
+		if srv.processor.MetricsConfig.Enabled || srv.processor.Signers != nil {
 			go srv.processor.MonitorBalance(ctx, srv.config.FetchBalanceDelay)
 		}
 		err := rpcServer.RegisterName(service.Name(), service)
@@ -108,65 +228,303 @@ func (srv *server) rpcHandler(ctx context.Context) (http.Handler, error) {
 		}
 	}
 
+	if err := rpcServer.RegisterName(rpc.ShutterNamespace, rpc.NewShutterService(ethService)); err != nil {
+		return nil, errors.Wrap(err, "error while trying to register ShutterService")
+	}
+
+	var backend http.Handler
+	if srv.config.Upstreams != nil {
+		backend = NewPoolReverseProxy(srv.config.Upstreams)
+	} else {
+		backend = NewReverseProxy(srv.config.BackendURL.URL)
+	}
+
+	go ethService.SenderLimiter.RunCleanup(ctx, rateLimiterIdleTimeout)
+	if err := rpc.ResumeTracking(ethService); err != nil {
+		utils.Logger.Error().Err(err).Msg("failed to resume waiting for tracked receipts")
+	}
+	if srv.processor.Submissions != nil {
+		if err := srv.processor.Submissions.Resume(); err != nil {
+			utils.Logger.Error().Err(err).Msg("failed to resume watching submissions")
+		}
+	}
+
 	p := &JSONRPCProxy{
-		backend:   NewReverseProxy(srv.config.BackendURL.URL),
+		backend:   backend,
+		backends:  make(map[string]http.Handler),
 		processor: rpcServer,
+		ipLimiter: ratelimit.NewKeyedLimiter(),
+		ipLimit:   srv.config.IPRateLimit,
+		ipBurst:   srv.config.IPRateBurst,
+
+		maxBodySize:  srv.config.MaxRequestBodySize,
+		maxBatchSize: srv.config.MaxBatchSize,
+	}
+	go p.ipLimiter.RunCleanup(ctx, rateLimiterIdleTimeout)
+
+	if srv.config.APIKeysEnabled {
+		p.apiKeys = NewAPIKeyStore(srv.postgresDatabase, srv.config.APIKeyRequired)
+		if err := p.apiKeys.Reload(); err != nil {
+			return nil, errors.Wrap(err, "error while loading api keys")
+		}
+		go p.apiKeys.Run(ctx, time.Duration(srv.config.APIKeyRefreshInterval)*time.Second)
+	}
+
+	var corsConfig *CORSConfig
+	var err error
+	if srv.config.CORSConfigPath != "" {
+		corsConfig, err = LoadCORSConfig(srv.config.CORSConfigPath)
+		if err != nil {
+			return nil, errors.Wrap(err, "error while loading cors config")
+		}
+	}
+	p.cors, err = NewCORSPolicies(corsConfig, p.apiKeys)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid cors config")
+	}
+
+	cacheTTLs := ParseCacheTTLs(srv.config.ResponseCacheTTLs)
+	if len(cacheTTLs) > 0 {
+		headPollInterval := time.Duration(srv.config.HeadPollInterval) * time.Second
+		if headPollInterval <= 0 {
+			headPollInterval = time.Second
+		}
+		p.cache = NewResponseCache(cacheTTLs, srv.config.FinalityDepth, srv.config.ResponseCacheSize)
+		go p.cache.WatchHead(ctx, srv.processor.Client, headPollInterval)
+	}
+
+	if srv.config.RoutingConfigPath != "" {
+		routingConfig, err := LoadRoutingConfig(srv.config.RoutingConfigPath)
+		if err != nil {
+			return nil, errors.Wrap(err, "error while loading routing config")
+		}
+		p.routes, err = NewRoutingTable(routingConfig.Routes, routingConfig.Backends)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid routing config")
+		}
+
+		for name, urls := range routingConfig.Backends {
+			pool, err := upstream.NewPool(urls, srv.upstreamConfig())
+			if err != nil {
+				return nil, errors.Wrapf(err, "error while setting up backend %s", name)
+			}
+			go pool.Run(ctx)
+			p.backends[name] = NewPoolReverseProxy(pool)
+		}
 	}
 	return p, nil
 }
 
-func CORSHandler(next http.Handler) http.Handler {
+// upstreamConfig returns the health check settings used for the named backends of the routing table.
+func (srv *server) upstreamConfig() upstream.Config {
+	if srv.config.Upstreams != nil {
+		return srv.config.Upstreams.Config()
+	}
+	return upstream.Config{
+		HealthCheckInterval: 10 * time.Second,
+		MaxBlockLag:         5,
+		MaxErrorRate:        0.5,
+	}
+}
+
+// maxRequestIDLength is the length of the longest X-Request-Id header taken from a client.
+const maxRequestIDLength = 64
+
+// RequestID gives every request an id, taken from the X-Request-Id header if the client sent a
+// valid one. The id is logged by middleware.Logger and the rpc services and returned in the
+// response header.
+func RequestID(next http.Handler) http.Handler {
+	withID := middleware.RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := middleware.GetReqID(r.Context())
+		w.Header().Set(utils.RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(utils.WithRequestID(r.Context(), id)))
+	}))
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		if !validRequestID(r.Header.Get(utils.RequestIDHeader)) {
+			// a new id is generated instead
+			r.Header.Del(utils.RequestIDHeader)
+		}
+		withID.ServeHTTP(w, r)
+	})
+}
 
-		if r.Method == http.MethodOptions {
-			w.WriteHeader(http.StatusOK)
-			return
+// validRequestID reports whether id is short and only consists of characters which are safe to
+// log and pass on in headers.
+func validRequestID(id string) bool {
+	if len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':', c == '/':
+		default:
+			return false
 		}
+	}
+	return true
+}
+
+func (srv *server) setupRouter(proxy *JSONRPCProxy) *chi.Mux {
+	router := chi.NewRouter()
+	router.Use(RequestID)
+	router.Use(middleware.Logger)
+	router.Use(middleware.Recoverer)
+	if srv.config.TrustProxyHeaders {
+		router.Use(middleware.RealIP)
+	}
+	// the probes are not subject to CORS and API keys
+	health := NewHealthChecker(srv.processor, srv.postgresDatabase, srv.config.MinSignerBalance)
+	router.Get("/healthz", health.Live)
+	router.Get("/readyz", health.Ready)
 
-		next.ServeHTTP(w, r)
+	router.Group(func(router chi.Router) {
+		router.Use(proxy.cors.Middleware)
+		if proxy.apiKeys != nil {
+			router.Use(proxy.apiKeys.Middleware)
+		}
+		router.Mount("/", proxy)
 	})
+	return router
 }
 
-func (srv *server) setupRouter(ctx context.Context) (*chi.Mux, error) {
+func (srv *server) setupWSRouter(proxy *JSONRPCProxy) *chi.Mux {
 	router := chi.NewRouter()
+	router.Use(RequestID)
 	router.Use(middleware.Logger)
 	router.Use(middleware.Recoverer)
-	router.Use(CORSHandler)
-	handler, err := srv.rpcHandler(ctx)
-	if err != nil {
-		return nil, err
+	if srv.config.TrustProxyHeaders {
+		router.Use(middleware.RealIP)
 	}
-	router.Mount("/", handler)
-	return router, nil
+	if proxy.apiKeys != nil {
+		router.Use(proxy.apiKeys.Middleware)
+	}
+	router.Mount("/", NewWSProxy(proxy, srv.config.WSBackendURL.String()))
+	return router
 }
 
 func (srv *server) Start(ctx context.Context, runner medleyService.Runner) error {
-	handler, err := srv.setupRouter(ctx)
-
+	proxy, err := srv.rpcHandler(ctx)
 	if err != nil {
 		return err
 	}
-	httpServer := &http.Server{
+
+	var certs *CertReloader
+	if srv.config.TLSCertFile != "" {
+		certs, err = NewCertReloader(srv.config.TLSCertFile, srv.config.TLSKeyFile, srv.config.TLSClientCAFile)
+		if err != nil {
+			return errors.Wrap(err, "error while loading tls certificate")
+		}
+		go certs.Run(ctx, time.Duration(srv.config.TLSReloadInterval)*time.Second)
+	}
+
+	httpServers := []*http.Server{{
 		Addr:              srv.config.HTTPListenAddress,
-		Handler:           handler,
+		Handler:           srv.setupRouter(proxy),
 		ReadHeaderTimeout: 5 * time.Second,
+	}}
+	if srv.config.WSListenAddress != "" {
+		httpServers = append(httpServers, &http.Server{
+			Addr:              srv.config.WSListenAddress,
+			Handler:           srv.setupWSRouter(proxy),
+			ReadHeaderTimeout: 5 * time.Second,
+		})
 	}
 
-	go srv.postgresDatabase.Start(ctx)
+	go srv.postgresDatabase.Start()
+	if srv.processor.EonKeys != nil {
+		eonRefreshInterval := time.Duration(srv.config.EonRefreshInterval) * time.Second
+		if eonRefreshInterval <= 0 {
+			eonRefreshInterval = 5 * time.Second
+		}
+		go rpc.NewEonWatcher(srv.processor, srv.config.EonKeyAlertBlocks).Run(ctx, eonRefreshInterval)
+	}
+	nonceResyncInterval := time.Duration(srv.config.NonceResyncInterval) * time.Second
+	if nonceResyncInterval <= 0 {
+		nonceResyncInterval = 30 * time.Second
+	}
+	if srv.processor.Submissions != nil {
+		go srv.processor.Submissions.Run(ctx, submissionCheckInterval)
+	}
+	if srv.processor.Spending != nil {
+		go srv.processor.Spending.Run(ctx, spendingMetricsInterval)
+	}
+	if srv.processor.Signers != nil {
+		for _, signer := range srv.processor.Signers.Signers() {
+			go signer.Nonces.Run(ctx, nonceResyncInterval)
+		}
+	}
+	if srv.config.Upstreams != nil {
+		go srv.config.Upstreams.Run(ctx)
+	}
 	if srv.processor.MetricsConfig.Enabled {
 		if err := runner.StartService(srv.processor.MetricsServer); err != nil {
 			return err
 		}
 	}
-	runner.Go(httpServer.ListenAndServe)
+	for _, httpServer := range httpServers {
+		serve(runner, httpServer, certs)
+	}
 	runner.Go(func() error {
 		<-ctx.Done()
-		shutdownCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
-		defer cancel()
-		return httpServer.Shutdown(shutdownCtx)
+		srv.shutdown(proxy, httpServers)
+		return nil
 	})
 	return nil
-}
\ No newline at end of file
+}
+
+// serve runs httpServer until it is shut down. With certs the server only accepts TLS connections.
+func serve(runner medleyService.Runner, httpServer *http.Server, certs *CertReloader) {
+	runner.Go(func() error {
+		var err error
+		if certs != nil {
+			httpServer.TLSConfig = certs.TLSConfig()
+			err = httpServer.ListenAndServeTLS("", "")
+		} else {
+			err = httpServer.ListenAndServe()
+		}
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+		return err
+	})
+}
+
+// shutdown stops the server in order, so that nothing a client has submitted is lost: new
+// submissions are rejected, requests in flight are finished, delayed transactions are sent, the
+// receipts still being waited for and the watched sequencer submissions are persisted and at last
+// the queued database writes are done. Each of these phases gets its own shutdown timeout, so that
+// slow requests do not use up the time needed to send the delayed transactions and write the rows.
+func (srv *server) shutdown(proxy *JSONRPCProxy, httpServers []*http.Server) {
+	timeout := time.Duration(srv.config.ShutdownTimeout) * time.Second
+	utils.Logger.Info().Dur("timeout", timeout).Msg("shutting down")
+
+	proxy.draining.Store(true)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	for _, httpServer := range httpServers {
+		if err := httpServer.Shutdown(ctx); err != nil {
+			utils.Logger.Error().Err(err).Str("address", httpServer.Addr).Msg("failed to shut down http server")
+		}
+	}
+	cancel()
+
+	if srv.ethService != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		if err := rpc.Shutdown(ctx, srv.ethService); err != nil {
+			utils.Logger.Error().Err(err).Msg("failed to persist tracked receipts")
+		}
+		cancel()
+	}
+	if srv.processor.Submissions != nil {
+		if err := srv.processor.Submissions.Save(); err != nil {
+			utils.Logger.Error().Err(err).Msg("failed to persist watched submissions")
+		}
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := srv.postgresDatabase.Close(ctx); err != nil {
+		utils.Logger.Error().Err(err).Msg("failed to finish database writes")
+	}
+	utils.Logger.Info().Msg("shutdown complete")
+}
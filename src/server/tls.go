@@ -0,0 +1,158 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/shutter-network/encrypting-rpc-server/utils"
+)
+
+// nextProtos are the protocols offered for ALPN, the same http.Server offers by default.
+var nextProtos = []string{"h2", "http/1.1"}
+
+// CertReloader serves the certificate from a cert and key file and reloads it, as well as the CA
+// used to verify client certificates, when one of the files changes on disk.
+type CertReloader struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string
+
+	mu       sync.RWMutex
+	config   *tls.Config
+	modTimes map[string]time.Time
+}
+
+// NewCertReloader loads the certificate. If clientCAFile is set, clients have to present a
+// certificate signed by one of the CAs in it.
+func NewCertReloader(certFile string, keyFile string, clientCAFile string) (*CertReloader, error) {
+	c := &CertReloader{
+		certFile:     certFile,
+		keyFile:      keyFile,
+		clientCAFile: clientCAFile,
+	}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *CertReloader) files() []string {
+	files := []string{c.certFile, c.keyFile}
+	if c.clientCAFile != "" {
+		files = append(files, c.clientCAFile)
+	}
+	return files
+}
+
+func (c *CertReloader) load() error {
+	modTimes := make(map[string]time.Time)
+	for _, file := range c.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		modTimes[file] = info.ModTime()
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load tls certificate | err: %w", err)
+	}
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		// this config replaces the one of the server for the connection, so the protocols have to
+		// be set again
+		NextProtos: nextProtos,
+	}
+
+	if c.clientCAFile != "" {
+		pem, err := os.ReadFile(c.clientCAFile)
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in %s", c.clientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	c.mu.Lock()
+	c.config, c.modTimes = config, modTimes
+	c.mu.Unlock()
+	return nil
+}
+
+// changed reports whether one of the files has been modified since it was loaded.
+func (c *CertReloader) changed() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, file := range c.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			// the file might be in the middle of being replaced
+			return false
+		}
+		if !info.ModTime().Equal(c.modTimes[file]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reload loads the files again if they have changed. The previous certificate stays in use if
+// the new one can not be loaded.
+func (c *CertReloader) Reload() error {
+	if !c.changed() {
+		return nil
+	}
+	if err := c.load(); err != nil {
+		return err
+	}
+	utils.Logger.Info().Str("cert", c.certFile).Msg("reloaded tls certificate")
+	return nil
+}
+
+// Run checks the files for changes periodically.
+func (c *CertReloader) Run(ctx context.Context, interval time.Duration) {
+	timer := time.NewTicker(interval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			if err := c.Reload(); err != nil {
+				utils.Logger.Error().Err(err).Msg("failed to reload tls certificate")
+			}
+		}
+	}
+}
+
+func (c *CertReloader) current() *tls.Config {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.config
+}
+
+// TLSConfig returns a config for http.Server which always uses the latest loaded files.
+func (c *CertReloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: nextProtos,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return &c.current().Certificates[0], nil
+		},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return c.current(), nil
+		},
+	}
+}
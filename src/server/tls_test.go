@@ -0,0 +1,180 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCert(t *testing.T, serial int64, parent *testCert, isCA bool) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: "test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return &testCert{
+		cert: cert,
+		key:  key,
+		pem:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+}
+
+func (c *testCert) keyPEM(t *testing.T) []byte {
+	der, err := x509.MarshalECPrivateKey(c.key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+}
+
+func (c *testCert) tlsCertificate(t *testing.T) tls.Certificate {
+	cert, err := tls.X509KeyPair(c.pem, c.keyPEM(t))
+	require.NoError(t, err)
+	return cert
+}
+
+func writeCert(t *testing.T, dir string, cert *testCert, modTime time.Time) (string, string) {
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(certFile, cert.pem, 0o600))
+	require.NoError(t, os.WriteFile(keyFile, cert.keyPEM(t), 0o600))
+	require.NoError(t, os.Chtimes(certFile, modTime, modTime))
+	require.NoError(t, os.Chtimes(keyFile, modTime, modTime))
+	return certFile, keyFile
+}
+
+func startTLSServer(t *testing.T, certs *CertReloader) *httptest.Server {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.TLS = certs.TLSConfig()
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func tlsGet(srv *httptest.Server, roots *x509.CertPool, clientCerts []tls.Certificate) (*http.Response, error) {
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: clientCerts},
+	}}
+	return client.Get(srv.URL)
+}
+
+func TestCertReloader_Reload(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, 1, nil, true)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	certFile, keyFile := writeCert(t, dir, newTestCert(t, 2, ca, false), time.Now().Add(-time.Minute))
+	certs, err := NewCertReloader(certFile, keyFile, "")
+	require.NoError(t, err)
+	srv := startTLSServer(t, certs)
+
+	resp, err := tlsGet(srv, roots, nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, int64(2), resp.TLS.PeerCertificates[0].SerialNumber.Int64())
+
+	writeCert(t, dir, newTestCert(t, 3, ca, false), time.Now())
+	require.NoError(t, certs.Reload())
+
+	resp, err = tlsGet(srv, roots, nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, int64(3), resp.TLS.PeerCertificates[0].SerialNumber.Int64(), "the new certificate should be served")
+
+	require.NoError(t, os.WriteFile(certFile, []byte("garbage"), 0o600))
+	assert.Error(t, certs.Reload())
+	resp, err = tlsGet(srv, roots, nil)
+	require.NoError(t, err, "the previous certificate should stay in use")
+	resp.Body.Close()
+}
+
+func TestCertReloader_ClientVerification(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, 1, nil, true)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	certFile, keyFile := writeCert(t, dir, newTestCert(t, 2, ca, false), time.Now())
+	caFile := filepath.Join(dir, "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, ca.pem, 0o600))
+
+	certs, err := NewCertReloader(certFile, keyFile, caFile)
+	require.NoError(t, err)
+	srv := startTLSServer(t, certs)
+
+	_, err = tlsGet(srv, roots, nil)
+	assert.Error(t, err, "clients without a certificate should be rejected")
+
+	untrusted := newTestCert(t, 4, nil, true)
+	_, err = tlsGet(srv, roots, []tls.Certificate{newTestCert(t, 5, untrusted, false).tlsCertificate(t)})
+	assert.Error(t, err, "clients with a certificate of another CA should be rejected")
+
+	resp, err := tlsGet(srv, roots, []tls.Certificate{newTestCert(t, 6, ca, false).tlsCertificate(t)})
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestCertReloader_HTTP2(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, 1, nil, true)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	certFile, keyFile := writeCert(t, dir, newTestCert(t, 2, ca, false), time.Now())
+	certs, err := NewCertReloader(certFile, keyFile, "")
+	require.NoError(t, err)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.EnableHTTP2 = true
+	srv.TLS = certs.TLSConfig()
+	srv.StartTLS()
+	defer srv.Close()
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{RootCAs: roots},
+		ForceAttemptHTTP2: true,
+	}}
+	resp, err := client.Get(srv.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "h2", resp.TLS.NegotiatedProtocol)
+	assert.Equal(t, 2, resp.ProtoMajor)
+}
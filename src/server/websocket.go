@@ -0,0 +1,286 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/shutter-network/encrypting-rpc-server/metrics"
+	"github.com/shutter-network/encrypting-rpc-server/utils"
+	"github.com/shutter-network/rolling-shutter/rolling-shutter/medley"
+)
+
+const wsReadLimit = 5 * 1024 * 1024
+
+// wsCloseTimeout bounds the time to tell a client that its connection is closed.
+const wsCloseTimeout = time.Second
+
+// WSProxy serves JSON-RPC over WebSocket. Calls handled by the processor, rejected calls and calls
+// for named backends are dispatched through the JSONRPCProxy, everything else (including
+// eth_subscribe) is relayed to a WebSocket upstream. Every client gets its own upstream connection,
+// so subscription ids are never shared between clients. Batches mixing both kinds of calls are
+// dispatched through the JSONRPCProxy as a whole, except for subscription calls.
+type WSProxy struct {
+	proxy       *JSONRPCProxy
+	upstreamURL string
+	upgrader    websocket.Upgrader
+	dialer      *websocket.Dialer
+}
+
+func NewWSProxy(proxy *JSONRPCProxy, upstreamURL string) *WSProxy {
+	p := &WSProxy{
+		proxy:       proxy,
+		upstreamURL: upstreamURL,
+		dialer:      websocket.DefaultDialer,
+	}
+	p.upgrader = websocket.Upgrader{CheckOrigin: p.checkOrigin}
+	return p
+}
+
+// checkOrigin allows browsers to connect from the origins the CORS policy of the HTTP endpoint
+// allows for the request.
+func (p *WSProxy) checkOrigin(r *http.Request) bool {
+	if p.proxy.cors == nil {
+		return true
+	}
+	return p.proxy.cors.AllowsOrigin(r)
+}
+
+type wsSession struct {
+	client   *websocket.Conn
+	upstream *websocket.Conn
+	writeMu  sync.Mutex
+}
+
+func (s *wsSession) writeClient(msg []byte) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	return s.client.WriteMessage(websocket.TextMessage, msg)
+}
+
+// ServeHTTP upgrades the connection of the client, which checks its origin, and only then connects
+// to the upstream, so that rejected clients never cause upstream connections.
+func (p *WSProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	client, err := p.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		utils.Logger.Info().Err(err).Msg("websocket upgrade failed")
+		return
+	}
+	defer client.Close()
+
+	var header http.Header
+	if id := utils.RequestID(r.Context()); id != "" {
+		header = http.Header{utils.RequestIDHeader: []string{id}}
+	}
+	upstream, _, err := p.dialer.DialContext(r.Context(), p.upstreamURL, header)
+	if err != nil {
+		utils.Logger.Error().Err(err).Msg("can not connect to websocket upstream")
+		closeMsg := websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "upstream unavailable")
+		_ = client.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(wsCloseTimeout))
+		return
+	}
+	defer upstream.Close()
+
+	client.SetReadLimit(wsReadLimit)
+	upstream.SetReadLimit(wsReadLimit)
+
+	session := &wsSession{client: client, upstream: upstream}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		session.relayUpstream()
+	}()
+
+	p.relayClient(r, session)
+	// closing both connections stops the upstream relay as well
+	_ = upstream.Close()
+	_ = client.Close()
+	<-done
+}
+
+// relayUpstream forwards responses and subscription notifications from the upstream to the client.
+func (s *wsSession) relayUpstream() {
+	for {
+		_, msg, err := s.upstream.ReadMessage()
+		if err != nil {
+			utils.Logger.Debug().Err(err).Msg("websocket upstream connection closed")
+			_ = s.client.Close()
+			return
+		}
+		if err := s.writeClient(msg); err != nil {
+			return
+		}
+	}
+}
+
+// relayClient reads the calls of the client and either answers them locally or forwards them upstream.
+func (p *WSProxy) relayClient(r *http.Request, s *wsSession) {
+	for {
+		_, msg, err := s.client.ReadMessage()
+		if err != nil {
+			utils.Logger.Debug().Err(err).Msg("websocket client connection closed")
+			return
+		}
+
+		local, relayed := p.routeCalls(msg)
+		if local && relayed {
+			if resp := p.serveMixedBatch(r, msg); len(resp) > 0 {
+				if err := s.writeClient(resp); err != nil {
+					return
+				}
+			}
+			continue
+		}
+		if !local {
+			if resp := p.checkQuota(r, msg); resp != nil {
+				if err := s.writeClient(resp); err != nil {
+					return
+				}
+				continue
+			}
+			if err := s.upstream.WriteMessage(websocket.TextMessage, msg); err != nil {
+				utils.Logger.Error().Err(err).Msg("failed to forward message to websocket upstream")
+				return
+			}
+			continue
+		}
+
+		resp := p.serveLocally(r, msg)
+		if len(resp) == 0 {
+			continue
+		}
+		if err := s.writeClient(resp); err != nil {
+			return
+		}
+	}
+}
+
+// routeCalls reports whether a message contains calls that have to be answered through the
+// JSONRPCProxy and calls that are relayed to the websocket upstream. Messages which can not be
+// parsed are relayed.
+func (p *WSProxy) routeCalls(msg []byte) (local bool, relayed bool) {
+	var reqs []medley.RPCRequest
+	if isBatch(msg) {
+		if err := json.Unmarshal(msg, &reqs); err != nil {
+			return false, true
+		}
+	} else {
+		rpcreq := medley.RPCRequest{}
+		if err := json.Unmarshal(msg, &rpcreq); err != nil {
+			return false, true
+		}
+		reqs = append(reqs, rpcreq)
+	}
+
+	// only calls for the default backend can be relayed to the websocket upstream
+	for _, rpcreq := range reqs {
+		route := p.proxy.Route(rpcreq.Method)
+		if route.Action != RouteToBackend || route.Backend != DefaultBackend {
+			local = true
+		} else {
+			relayed = true
+		}
+	}
+	return local, relayed
+}
+
+// subscriptionMethods can only be relayed to the websocket upstream, as notifications are sent on
+// the connection the subscription was made on.
+var subscriptionMethods = map[string]bool{
+	"eth_subscribe":   true,
+	"eth_unsubscribe": true,
+}
+
+// serveMixedBatch answers a batch which mixes calls answered locally with calls for the websocket
+// upstream through the JSONRPCProxy, like a batch sent over HTTP. Subscription calls in it are
+// rejected, they have to be sent separately.
+func (p *WSProxy) serveMixedBatch(r *http.Request, msg []byte) []byte {
+	var calls []json.RawMessage
+	if err := json.Unmarshal(msg, &calls); err != nil {
+		return p.serveLocally(r, msg)
+	}
+
+	var responses []json.RawMessage
+	served := make([]json.RawMessage, 0, len(calls))
+	for _, call := range calls {
+		rpcreq := medley.RPCRequest{}
+		if err := json.Unmarshal(call, &rpcreq); err == nil && subscriptionMethods[rpcreq.Method] {
+			responses = append(responses, errorResponse(requestID(call), errCodeInvalidRequest,
+				rpcreq.Method+" can not be batched with calls handled by the server, send it separately"))
+			continue
+		}
+		served = append(served, call)
+	}
+	if len(responses) == 0 {
+		return p.serveLocally(r, msg)
+	}
+
+	if len(served) > 0 {
+		batch, _ := json.Marshal(served)
+		var servedResponses []json.RawMessage
+		if resp := p.serveLocally(r, batch); len(resp) > 0 {
+			if err := json.Unmarshal(resp, &servedResponses); err != nil {
+				servedResponses = []json.RawMessage{resp}
+			}
+		}
+		responses = append(servedResponses, responses...)
+	}
+	resp, _ := json.Marshal(responses)
+	return resp
+}
+
+// checkQuota applies the API key quotas to a message relayed to the websocket upstream, the
+// JSONRPCProxy applies them to the others. It returns the error response if a call is over quota,
+// for a batch one for each of its calls, as the batch is relayed as a whole.
+func (p *WSProxy) checkQuota(r *http.Request, msg []byte) []byte {
+	if p.proxy.apiKeys == nil {
+		return nil
+	}
+	msgs := []json.RawMessage{msg}
+	if isBatch(msg) {
+		if err := json.Unmarshal(msg, &msgs); err != nil {
+			return nil
+		}
+	}
+
+	logger := utils.ContextLogger(r.Context())
+	for _, call := range msgs {
+		rpcreq := medley.RPCRequest{}
+		if err := json.Unmarshal(call, &rpcreq); err != nil {
+			continue
+		}
+		ok, message := p.proxy.apiKeys.CheckQuota(r.Context(), rpcreq.Method)
+		if ok {
+			continue
+		}
+		logger.Info().Str("method", rpcreq.Method).Uint("api-key-id", utils.APIKeyID(r.Context())).Msg(message)
+		metrics.RateLimitedRequests.WithLabelValues("api_key").Inc()
+		if !isBatch(msg) {
+			return errorResponse(requestID(msg), errCodeLimitExceeded, message)
+		}
+		responses := make([]json.RawMessage, 0, len(msgs))
+		for _, call := range msgs {
+			responses = append(responses, errorResponse(requestID(call), errCodeLimitExceeded, message))
+		}
+		resp, _ := json.Marshal(responses)
+		return resp
+	}
+	return nil
+}
+
+// serveLocally runs a message through the HTTP dispatching of the JSONRPCProxy and returns its response.
+func (p *WSProxy) serveLocally(r *http.Request, msg []byte) []byte {
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodPost, "/", bytes.NewReader(msg))
+	if err != nil {
+		return errorResponse(requestID(msg), errCodeInternal, "internal error")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.RemoteAddr = r.RemoteAddr
+
+	rec := newResponseRecorder()
+	p.proxy.ServeHTTP(rec, req)
+	return bytes.TrimSpace(rec.body.Bytes())
+}
@@ -0,0 +1,196 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newWSUpstream answers every call with a subscription id unique to its connection.
+func newWSUpstream(t *testing.T) *httptest.Server {
+	var connections atomic.Int64
+	upgrader := websocket.Upgrader{}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		require.NoError(t, err)
+		defer conn.Close()
+		connID := connections.Add(1)
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			var req struct {
+				ID json.RawMessage `json:"id"`
+			}
+			_ = json.Unmarshal(msg, &req)
+			resp := fmt.Sprintf(`{"jsonrpc":"2.0","id":%s,"result":"0xsub%d"}`, req.ID, connID)
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(resp)); err != nil {
+				return
+			}
+		}
+	}))
+}
+
+func dialWS(t *testing.T, url string) *websocket.Conn {
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(url, "http"), nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func call(t *testing.T, conn *websocket.Conn, msg string) batchResponse {
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(msg)))
+	_, resp, err := conn.ReadMessage()
+	require.NoError(t, err)
+	var response batchResponse
+	require.NoError(t, json.Unmarshal(resp, &response))
+	return response
+}
+
+func TestWSProxy_RoutesCalls(t *testing.T) {
+	upstream := newWSUpstream(t)
+	defer upstream.Close()
+
+	p := &JSONRPCProxy{backend: &stubHandler{name: "backend"}, processor: &stubHandler{name: "processor"}}
+	srv := httptest.NewServer(NewWSProxy(p, "ws"+strings.TrimPrefix(upstream.URL, "http")))
+	defer srv.Close()
+
+	conn := dialWS(t, srv.URL)
+
+	resp := call(t, conn, `{"jsonrpc":"2.0","id":1,"method":"eth_gasPrice","params":[]}`)
+	assert.Equal(t, "1", string(resp.ID))
+	assert.Equal(t, "processor:eth_gasPrice", resp.Result)
+
+	resp = call(t, conn, `{"jsonrpc":"2.0","id":2,"method":"eth_subscribe","params":["newHeads"]}`)
+	assert.Equal(t, "2", string(resp.ID))
+	assert.Equal(t, "0xsub1", resp.Result)
+}
+
+func TestWSProxy_MixedBatch(t *testing.T) {
+	upstream := newWSUpstream(t)
+	defer upstream.Close()
+
+	p := &JSONRPCProxy{backend: &stubHandler{name: "backend"}, processor: &stubHandler{name: "processor"}}
+	srv := httptest.NewServer(NewWSProxy(p, "ws"+strings.TrimPrefix(upstream.URL, "http")))
+	defer srv.Close()
+
+	conn := dialWS(t, srv.URL)
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(`[`+
+		`{"jsonrpc":"2.0","id":1,"method":"eth_sendRawTransaction","params":["0x01"]},`+
+		`{"jsonrpc":"2.0","id":2,"method":"eth_blockNumber","params":[]}]`)))
+	_, msg, err := conn.ReadMessage()
+	require.NoError(t, err)
+	var responses []batchResponse
+	require.NoError(t, json.Unmarshal(msg, &responses))
+	require.Len(t, responses, 2)
+	assert.Equal(t, "processor:eth_sendRawTransaction", responses[0].Result)
+	assert.Equal(t, "backend:eth_blockNumber", responses[1].Result, "relayed calls should go to the http backend")
+
+	// subscriptions can not be served over http
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(`[`+
+		`{"jsonrpc":"2.0","id":3,"method":"eth_sendRawTransaction","params":["0x01"]},`+
+		`{"jsonrpc":"2.0","id":4,"method":"eth_subscribe","params":["newHeads"]}]`)))
+	_, msg, err = conn.ReadMessage()
+	require.NoError(t, err)
+	responses = nil
+	require.NoError(t, json.Unmarshal(msg, &responses))
+	require.Len(t, responses, 2)
+	assert.Equal(t, "3", string(responses[0].ID))
+	assert.Equal(t, "processor:eth_sendRawTransaction", responses[0].Result)
+	assert.Equal(t, "4", string(responses[1].ID))
+	require.NotNil(t, responses[1].Error)
+	assert.Equal(t, -32600, responses[1].Error.Code)
+}
+
+func TestWSProxy_SeparateUpstreamPerClient(t *testing.T) {
+	upstream := newWSUpstream(t)
+	defer upstream.Close()
+
+	p := &JSONRPCProxy{backend: &stubHandler{name: "backend"}, processor: &stubHandler{name: "processor"}}
+	srv := httptest.NewServer(NewWSProxy(p, "ws"+strings.TrimPrefix(upstream.URL, "http")))
+	defer srv.Close()
+
+	first := call(t, dialWS(t, srv.URL), `{"jsonrpc":"2.0","id":1,"method":"eth_subscribe","params":["newHeads"]}`)
+	second := call(t, dialWS(t, srv.URL), `{"jsonrpc":"2.0","id":1,"method":"eth_subscribe","params":["newHeads"]}`)
+	assert.NotEqual(t, first.Result, second.Result, "clients should not share an upstream connection")
+}
+
+func TestWSProxy_APIKeyQuota(t *testing.T) {
+	upstream := newWSUpstream(t)
+	defer upstream.Close()
+
+	store := newTestAPIKeyStore(t, true)
+	p := &JSONRPCProxy{backend: &stubHandler{name: "backend"}, processor: &stubHandler{name: "processor"}, apiKeys: store}
+	srv := httptest.NewServer(store.Middleware(NewWSProxy(p, "ws"+strings.TrimPrefix(upstream.URL, "http"))))
+	defer srv.Close()
+
+	conn := dialWS(t, srv.URL+"/limited")
+	resp := call(t, conn, `{"jsonrpc":"2.0","id":1,"method":"eth_subscribe","params":["newHeads"]}`)
+	assert.Nil(t, resp.Error)
+	resp = call(t, conn, `{"jsonrpc":"2.0","id":2,"method":"eth_subscribe","params":["newHeads"]}`)
+	assert.Nil(t, resp.Error)
+	resp = call(t, conn, `{"jsonrpc":"2.0","id":3,"method":"eth_subscribe","params":["newHeads"]}`)
+	require.NotNil(t, resp.Error, "calls relayed upstream should count against the request quota")
+	assert.Equal(t, -32005, resp.Error.Code)
+	assert.Equal(t, "3", string(resp.ID))
+}
+
+func TestWSProxy_CheckOrigin(t *testing.T) {
+	upstream := newWSUpstream(t)
+	defer upstream.Close()
+
+	cors, err := NewCORSPolicies(&CORSConfig{Default: &CORSPolicy{AllowedOrigins: []string{"https://app.example"}}}, nil)
+	require.NoError(t, err)
+	p := &JSONRPCProxy{backend: &stubHandler{name: "backend"}, processor: &stubHandler{name: "processor"}, cors: cors}
+	srv := httptest.NewServer(NewWSProxy(p, "ws"+strings.TrimPrefix(upstream.URL, "http")))
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": []string{"https://app.example"}})
+	require.NoError(t, err)
+	conn.Close()
+
+	_, resp, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": []string{"https://evil.example"}})
+	require.Error(t, err, "connections from other origins should be rejected")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	conn, _, err = websocket.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err, "clients without an origin are no browsers and should be allowed")
+	conn.Close()
+}
+
+func TestWSProxy_UpgradeBeforeDialingUpstream(t *testing.T) {
+	var dials atomic.Int64
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dials.Add(1)
+		http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+	}))
+	defer upstream.Close()
+
+	cors, err := NewCORSPolicies(&CORSConfig{Default: &CORSPolicy{AllowedOrigins: []string{"https://app.example"}}}, nil)
+	require.NoError(t, err)
+	p := &JSONRPCProxy{backend: &stubHandler{name: "backend"}, processor: &stubHandler{name: "processor"}, cors: cors}
+	srv := httptest.NewServer(NewWSProxy(p, "ws"+strings.TrimPrefix(upstream.URL, "http")))
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	_, _, err = websocket.DefaultDialer.Dial(url, http.Header{"Origin": []string{"https://evil.example"}})
+	require.Error(t, err)
+	assert.Zero(t, dials.Load(), "rejected clients should not connect to the upstream")
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, _, err = conn.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseTryAgainLater), "the client should learn that the upstream is unavailable")
+	assert.Equal(t, int64(1), dials.Load())
+}
@@ -273,8 +273,5 @@ func NewPostgresTestDB(t *testing.T) (sqlmock.Sqlmock, *db.PostgresDb) {
 	testDb, err := gorm.Open(dialector, gormConfig)
 	require.NoError(t, err)
 
-	inclusionCh := make(chan db.TransactionDetails, 10)
-	addTxCh := make(chan db.TransactionDetails, 10)
-
-	return mock, &db.PostgresDb{DB: testDb, InclusionCh: inclusionCh, AddTxCh: addTxCh}
+	return mock, db.NewPostgresDb(testDb, 10)
 }
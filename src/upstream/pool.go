@@ -0,0 +1,219 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/shutter-network/encrypting-rpc-server/metrics"
+	"github.com/shutter-network/encrypting-rpc-server/utils"
+)
+
+// minRequestsForErrorRate is the number of requests an upstream has to serve during a health check
+// interval before its error rate is taken into account.
+const minRequestsForErrorRate = 5
+
+type Config struct {
+	HealthCheckInterval time.Duration
+	MaxBlockLag         uint64
+	MaxErrorRate        float64
+}
+
+// Upstream is a single RPC provider of the pool.
+type Upstream struct {
+	Name   string // the position in the pool and the host, unique even if hosts are shared
+	URL    *url.URL
+	Client *ethclient.Client
+
+	mu          sync.RWMutex
+	healthy     bool
+	blockNumber uint64
+	requests    uint64
+	errors      uint64
+}
+
+func (u *Upstream) Healthy() bool {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	return u.healthy
+}
+
+func (u *Upstream) BlockNumber() uint64 {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	return u.blockNumber
+}
+
+// RecordResult counts a request towards the error rate of the upstream.
+func (u *Upstream) RecordResult(err error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.requests++
+	if err != nil {
+		u.errors++
+		metrics.UpstreamErrors.WithLabelValues(u.Name).Inc()
+	}
+}
+
+// Pool keeps track of the health of a list of upstreams. Upstreams are ordered by priority, the
+// first healthy one is used for requests.
+type Pool struct {
+	upstreams []*Upstream
+	config    Config
+}
+
+func NewPool(rpcURLs []string, config Config) (*Pool, error) {
+	if len(rpcURLs) == 0 {
+		return nil, errors.New("at least one upstream is required")
+	}
+
+	pool := &Pool{config: config}
+	for i, rpcURL := range rpcURLs {
+		parsed, err := url.Parse(rpcURL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse upstream url | err: %w", err)
+		}
+		client, err := ethclient.Dial(rpcURL)
+		if err != nil {
+			return nil, fmt.Errorf("can not connect to upstream %s | err: %w", parsed.Host, err)
+		}
+		pool.upstreams = append(pool.upstreams, &Upstream{
+			Name:    fmt.Sprintf("%d-%s", i, parsed.Host),
+			URL:     parsed,
+			Client:  client,
+			healthy: true,
+		})
+	}
+	return pool, nil
+}
+
+func (p *Pool) Config() Config {
+	return p.config
+}
+
+func (p *Pool) Upstreams() []*Upstream {
+	return p.upstreams
+}
+
+// Candidates returns the upstreams in the order they should be tried. Healthy upstreams come first,
+// unhealthy ones are only used as last resort.
+func (p *Pool) Candidates() []*Upstream {
+	candidates := make([]*Upstream, 0, len(p.upstreams))
+	var unhealthy []*Upstream
+	for _, u := range p.upstreams {
+		if u.Healthy() {
+			candidates = append(candidates, u)
+		} else {
+			unhealthy = append(unhealthy, u)
+		}
+	}
+	return append(candidates, unhealthy...)
+}
+
+// Select returns the upstream requests should be sent to.
+func (p *Pool) Select() *Upstream {
+	return p.Candidates()[0]
+}
+
+// Run checks the health of all upstreams periodically until the context is done.
+func (p *Pool) Run(ctx context.Context) {
+	timer := time.NewTicker(p.config.HealthCheckInterval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			p.CheckHealth(ctx)
+		}
+	}
+}
+
+// CheckHealth fetches the block number of every upstream. An upstream is healthy if it is reachable,
+// lags at most MaxBlockLag blocks behind the highest known block and its error rate since the last
+// check is at most MaxErrorRate.
+func (p *Pool) CheckHealth(ctx context.Context) {
+	blockNumbers := make([]uint64, len(p.upstreams))
+	checkErrs := make([]error, len(p.upstreams))
+
+	var wg sync.WaitGroup
+	for i, u := range p.upstreams {
+		wg.Add(1)
+		go func(i int, u *Upstream) {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, p.config.HealthCheckInterval)
+			defer cancel()
+			blockNumbers[i], checkErrs[i] = u.Client.BlockNumber(checkCtx)
+		}(i, u)
+	}
+	wg.Wait()
+
+	var highest uint64
+	for i, bn := range blockNumbers {
+		if checkErrs[i] == nil && bn > highest {
+			highest = bn
+		}
+	}
+
+	for i, u := range p.upstreams {
+		u.mu.Lock()
+		var errorRate float64
+		if u.requests >= minRequestsForErrorRate {
+			errorRate = float64(u.errors) / float64(u.requests)
+		}
+		u.requests, u.errors = 0, 0
+
+		wasHealthy := u.healthy
+		if checkErrs[i] != nil {
+			u.healthy = false
+		} else {
+			u.blockNumber = blockNumbers[i]
+			u.healthy = highest-blockNumbers[i] <= p.config.MaxBlockLag && errorRate <= p.config.MaxErrorRate
+		}
+		healthy, blockNumber := u.healthy, u.blockNumber
+		u.mu.Unlock()
+
+		if checkErrs[i] != nil {
+			metrics.UpstreamErrors.WithLabelValues(u.Name).Inc()
+		} else {
+			metrics.UpstreamBlockLag.WithLabelValues(u.Name).Set(float64(highest - blockNumber))
+		}
+		if healthy {
+			metrics.UpstreamHealthy.WithLabelValues(u.Name).Set(1)
+		} else {
+			metrics.UpstreamHealthy.WithLabelValues(u.Name).Set(0)
+		}
+
+		if wasHealthy != healthy {
+			utils.Logger.Info().
+				Str("upstream", u.Name).
+				Bool("healthy", healthy).
+				Uint64("block-number", blockNumber).
+				Uint64("highest-block-number", highest).
+				Float64("error-rate", errorRate).
+				AnErr("check-error", checkErrs[i]).
+				Msg("upstream health changed")
+		}
+	}
+}
+
+// IsUpstreamFailure reports whether an error was caused by the upstream itself, as opposed to a
+// regular JSON-RPC error response which would be returned by every upstream alike.
+func IsUpstreamFailure(err error) bool {
+	if err == nil || errors.Is(err, ethereum.NotFound) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var rpcErr rpc.Error
+	if errors.As(err, &rpcErr) {
+		return false
+	}
+	var dataErr rpc.DataError
+	return !errors.As(err, &dataErr)
+}
@@ -0,0 +1,100 @@
+package upstream
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newNode starts a fake node answering eth_blockNumber with the current value of blockNumber.
+func newNode(t *testing.T, blockNumber *atomic.Uint64) *httptest.Server {
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ID json.RawMessage `json:"id"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%s,"result":"0x%x"}`, req.ID, blockNumber.Load())
+	}))
+	t.Cleanup(node.Close)
+	return node
+}
+
+func newTestPool(t *testing.T, urls ...string) *Pool {
+	pool, err := NewPool(urls, Config{
+		HealthCheckInterval: time.Second,
+		MaxBlockLag:         2,
+		MaxErrorRate:        0.5,
+	})
+	require.NoError(t, err)
+	return pool
+}
+
+func TestCheckHealth_BlockLag(t *testing.T) {
+	var head, lagging atomic.Uint64
+	head.Store(100)
+	lagging.Store(90)
+
+	pool := newTestPool(t, newNode(t, &lagging).URL, newNode(t, &head).URL)
+	pool.CheckHealth(context.Background())
+
+	assert.False(t, pool.Upstreams()[0].Healthy(), "upstream lagging behind should be unhealthy")
+	assert.True(t, pool.Upstreams()[1].Healthy())
+	assert.Equal(t, pool.Upstreams()[1], pool.Select())
+
+	lagging.Store(99)
+	pool.CheckHealth(context.Background())
+	assert.True(t, pool.Upstreams()[0].Healthy(), "upstream should recover once it caught up")
+	assert.Equal(t, pool.Upstreams()[0], pool.Select())
+}
+
+func TestCheckHealth_Unreachable(t *testing.T) {
+	var head atomic.Uint64
+	head.Store(100)
+
+	down := newNode(t, &head)
+	down.Close()
+
+	pool := newTestPool(t, down.URL, newNode(t, &head).URL)
+	pool.CheckHealth(context.Background())
+
+	assert.False(t, pool.Upstreams()[0].Healthy())
+	assert.True(t, pool.Upstreams()[1].Healthy())
+}
+
+func TestCheckHealth_ErrorRate(t *testing.T) {
+	var head atomic.Uint64
+	head.Store(100)
+
+	pool := newTestPool(t, newNode(t, &head).URL, newNode(t, &head).URL)
+	for i := 0; i < minRequestsForErrorRate; i++ {
+		pool.Upstreams()[0].RecordResult(fmt.Errorf("failure"))
+	}
+	pool.CheckHealth(context.Background())
+
+	assert.False(t, pool.Upstreams()[0].Healthy())
+	assert.True(t, pool.Upstreams()[1].Healthy())
+}
+
+func TestClient_Failover(t *testing.T) {
+	var head atomic.Uint64
+	head.Store(42)
+
+	down := newNode(t, &head)
+	down.Close()
+
+	pool := newTestPool(t, down.URL, newNode(t, &head).URL)
+	client := NewClient(pool)
+
+	blockNumber, err := client.BlockNumber(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(42), blockNumber)
+}
@@ -0,0 +1,66 @@
+package utils
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/rs/zerolog"
+)
+
+// RequestIDHeader is the header the request id is taken from and passed upstream in.
+const RequestIDHeader = "X-Request-Id"
+
+type apiKeyIDContextKey struct{}
+
+type internalContextKey struct{}
+
+type requestIDContextKey struct{}
+
+// WithAPIKeyID returns a context carrying the id of the API key a request was made with.
+func WithAPIKeyID(ctx context.Context, id uint) context.Context {
+	return context.WithValue(ctx, apiKeyIDContextKey{}, id)
+}
+
+// APIKeyID returns the id of the API key of the request, or 0 if no key was used.
+func APIKeyID(ctx context.Context) uint {
+	id, _ := ctx.Value(apiKeyIDContextKey{}).(uint)
+	return id
+}
+
+// WithInternal marks a context as belonging to a call made by the server itself, e.g. sending a
+// delayed transaction, which is not subject to client rate limits.
+func WithInternal(ctx context.Context) context.Context {
+	return context.WithValue(ctx, internalContextKey{}, true)
+}
+
+// IsInternal reports whether the context was marked with WithInternal.
+func IsInternal(ctx context.Context) bool {
+	internal, _ := ctx.Value(internalContextKey{}).(bool)
+	return internal
+}
+
+// WithRequestID returns a context carrying the id of the HTTP request. Upstream calls made by
+// go-ethereum rpc clients with the context send the id in the X-Request-Id header.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	if id == "" {
+		return ctx
+	}
+	ctx = rpc.NewContextWithHeaders(ctx, http.Header{RequestIDHeader: []string{id}})
+	return context.WithValue(ctx, requestIDContextKey{}, id)
+}
+
+// RequestID returns the id of the HTTP request, or an empty string if there is none.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey{}).(string)
+	return id
+}
+
+// ContextLogger returns Logger with the request id of ctx added to every line.
+func ContextLogger(ctx context.Context) zerolog.Logger {
+	id := RequestID(ctx)
+	if id == "" {
+		return Logger
+	}
+	return Logger.With().Str("request-id", id).Logger()
+}
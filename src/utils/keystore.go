@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+)
+
+// KeystorePasswordEnv is the environment variable the keystore password is read from if no
+// password file is given.
+const KeystorePasswordEnv = "SIGNING_KEYSTORE_PASSWORD"
+
+// ReadKeystorePassword reads the keystore password from passwordFile, or from KeystorePasswordEnv
+// if passwordFile is empty. A trailing newline is not part of the password. The environment
+// variable is unset, so that it is not passed on to child processes.
+func ReadKeystorePassword(passwordFile string) ([]byte, error) {
+	if passwordFile != "" {
+		password, err := os.ReadFile(passwordFile)
+		if err != nil {
+			return nil, fmt.Errorf("can not read keystore password file: %w", err)
+		}
+		trimmed := bytes.TrimRight(password, "\r\n")
+		// zero the trimmed newline, the rest is zeroed by the caller
+		ZeroBytes(password[len(trimmed):])
+		return trimmed, nil
+	}
+
+	password, ok := os.LookupEnv(KeystorePasswordEnv)
+	if !ok {
+		return nil, fmt.Errorf("no keystore password file given and %s is not set", KeystorePasswordEnv)
+	}
+	if err := os.Unsetenv(KeystorePasswordEnv); err != nil {
+		return nil, err
+	}
+	return []byte(password), nil
+}
+
+// LoadKeystoreKey decrypts the go-ethereum v3 keystore file at path with password. password is
+// zeroed afterwards, whether decryption succeeds or not.
+func LoadKeystoreKey(path string, password []byte) (*ecdsa.PrivateKey, error) {
+	defer ZeroBytes(password)
+
+	keyJSON, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("can not read keystore file: %w", err)
+	}
+	// the keystore package only takes the password as string, which can not be zeroed
+	key, err := keystore.DecryptKey(keyJSON, string(password))
+	if errors.Is(err, keystore.ErrDecrypt) {
+		return nil, fmt.Errorf("can not decrypt keystore file %s, the password is wrong", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can not decrypt keystore file %s: %w", path, err)
+	}
+	return key.PrivateKey, nil
+}
+
+// ZeroBytes overwrites b with zeros.
+func ZeroBytes(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
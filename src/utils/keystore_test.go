@@ -0,0 +1,68 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeKeystore(t *testing.T, password string) (string, *keystore.Key) {
+	privateKey, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	key := &keystore.Key{
+		Address:    crypto.PubkeyToAddress(privateKey.PublicKey),
+		PrivateKey: privateKey,
+	}
+	keyJSON, err := keystore.EncryptKey(key, password, keystore.LightScryptN, keystore.LightScryptP)
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "keystore.json")
+	require.NoError(t, os.WriteFile(path, keyJSON, 0o600))
+	return path, key
+}
+
+func TestLoadKeystoreKey(t *testing.T) {
+	path, key := writeKeystore(t, "secret")
+	passwordFile := filepath.Join(t.TempDir(), "password")
+	require.NoError(t, os.WriteFile(passwordFile, []byte("secret\n"), 0o600))
+
+	password, err := ReadKeystorePassword(passwordFile)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("secret"), password)
+
+	privateKey, err := LoadKeystoreKey(path, password)
+	require.NoError(t, err)
+	assert.Equal(t, key.Address, crypto.PubkeyToAddress(privateKey.PublicKey))
+	assert.Equal(t, make([]byte, len("secret")), password, "the password should be zeroed")
+}
+
+func TestLoadKeystoreKey_PasswordFromEnv(t *testing.T) {
+	path, key := writeKeystore(t, "secret")
+	t.Setenv(KeystorePasswordEnv, "secret")
+
+	password, err := ReadKeystorePassword("")
+	require.NoError(t, err)
+	_, ok := os.LookupEnv(KeystorePasswordEnv)
+	assert.False(t, ok, "the password should be removed from the environment")
+
+	privateKey, err := LoadKeystoreKey(path, password)
+	require.NoError(t, err)
+	assert.Equal(t, key.Address, crypto.PubkeyToAddress(privateKey.PublicKey))
+
+	_, err = ReadKeystorePassword("")
+	assert.ErrorContains(t, err, KeystorePasswordEnv)
+}
+
+func TestLoadKeystoreKey_WrongPassword(t *testing.T) {
+	path, _ := writeKeystore(t, "secret")
+	password := []byte("wrong")
+
+	_, err := LoadKeystoreKey(path, password)
+	assert.ErrorContains(t, err, "the password is wrong")
+	assert.Equal(t, make([]byte, len("wrong")), password)
+}